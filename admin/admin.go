@@ -0,0 +1,546 @@
+// Package admin provides a http api for managing intercepts and viewing captured traffic while hflow is running
+package admin
+
+import (
+	"bytes"
+	"comradequinn/hflow/breakpoint"
+	"comradequinn/hflow/capture"
+	"comradequinn/hflow/log"
+	"comradequinn/hflow/proxy"
+	"comradequinn/hflow/proxy/intercept"
+	"comradequinn/hflow/rules"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	interceptInfo struct {
+		ID       int         `json:"id"`
+		Label    string      `json:"label"`
+		Phase    string      `json:"phase"`
+		Priority int         `json:"priority"`
+		Enabled  bool        `json:"enabled"`
+		Buffered bool        `json:"buffered"`
+		Rule     *rules.Rule `json:"rule,omitempty"`
+	}
+
+	breakpointInfo struct {
+		Name  string      `json:"name"`
+		Match rules.Match `json:"match"`
+		On    []string    `json:"on"`
+	}
+
+	heldInfo struct {
+		breakpoint.Held
+		Exchange json.RawMessage `json:"exchange"`
+	}
+
+	statusInfo struct {
+		Started           time.Time             `json:"started"`
+		Uptime            string                `json:"uptime"`
+		Ports             map[string]int        `json:"ports"`
+		Intercepts        int                   `json:"intercepts"`
+		ExchangesHeld     int                   `json:"exchanges_held"`
+		ExchangesCaptured uint64                `json:"exchanges_captured"`
+		Held              int                   `json:"held"`
+		Connections       proxy.ConnectionStats `json:"connections"`
+	}
+)
+
+// Handler returns a http.Handler that serves the admin api. Captured exchanges are read from store, breakpoints are
+// created in and held exchanges are managed through breakpoints and ports describes the ports hflow is listening on,
+// keyed by their purpose. Where token is set, requests must present it, either as a bearer token in the Authorization
+// header or as the token query parameter. Where it is not, requests must have a Host of a loopback host or of addr, the
+// address the api is served on, so that pages using dns rebinding cannot use the admin api. Requests that can change
+// state are rejected where they are cross-origin or have a body that is not json, so that pages browsed through hflow
+// cannot use the admin api
+//
+// * GET /status returns the status of hflow, including the use of connections to upstream hosts
+// * GET /intercepts lists all intercepts in the order they are applied
+// * POST /intercepts creates an intercept from the rule described by the json request body
+// * GET /intercepts/{id} returns the intercept identified by id
+// * DELETE /intercepts/{id} removes the intercept identified by id
+// * PATCH /intercepts/{id} enables or disables the intercept identified by id, as per a json body of {"enabled": bool}
+// * GET /exchanges returns captured exchanges, oldest first, filtered by the optional query parameters below
+//   * limit: the maximum number of the most recent matching exchanges to return, defaulting to 100, or -1 for all
+//   * url, host, path, body: text that the request url, request host, request path or either body must contain
+//   * method, status: the request method and response status code, where an x in status matches any digit
+//   * from, to: rfc3339 times bounding when the exchange started
+//   * format: one of jsonl (the default), text or har
+// * GET /events streams exchanges, as they are captured, as server-sent events of type exchange with jsonl data
+// * POST /breakpoints creates a breakpoint intercept from a json body of {"name": string, "match": rules.Match, "on": ["request", "response"]}
+// * GET /held lists requests and responses held at breakpoints
+// * GET /held/{id} returns the request or response held with id
+// * POST /held/{id}/forward forwards the request or response held with id, applying any breakpoint.Edit in the json body
+// * POST /held/{id}/drop drops the request or response held with id, closing the client connection
+// * GET /ui/ serves a web ui for browsing captured exchanges and toggling intercepts
+func Handler(store *capture.Store, breakpoints *breakpoint.Registry, ports map[string]int, addr, token string) http.Handler {
+	started, created, mx := time.Now(), map[int]rules.Rule{}, sync.RWMutex{}
+
+	info := func(id int) (interceptInfo, bool) {
+		i, ok := proxy.Intercepts()[id]
+
+		if !ok {
+			return interceptInfo{}, false
+		}
+
+		ii := interceptInfo{ID: id, Label: i.Label(), Phase: i.Phase().String(), Priority: i.Priority(), Enabled: i.Enabled(), Buffered: i.Buffered()}
+
+		mx.RLock()
+		if r, ok := created[id]; ok {
+			ii.Rule = &r
+		}
+		mx.RUnlock()
+
+		return ii, true
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/status", func(rw http.ResponseWriter, r *http.Request) {
+		if !allow(rw, r, http.MethodGet) {
+			return
+		}
+
+		held, captured := store.Len()
+
+		writeJSON(rw, http.StatusOK, statusInfo{
+			Started:           started,
+			Uptime:            time.Since(started).Round(time.Second).String(),
+			Ports:             ports,
+			Intercepts:        len(proxy.Intercepts()),
+			ExchangesHeld:     held,
+			ExchangesCaptured: captured,
+			Held:              len(breakpoints.List()),
+			Connections:       proxy.Connections(),
+		})
+	})
+
+	mux.HandleFunc("/intercepts", func(rw http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			iis := []interceptInfo{}
+
+			for _, id := range intercept.Order(proxy.Intercepts()) {
+				if ii, ok := info(id); ok {
+					iis = append(iis, ii)
+				}
+			}
+
+			writeJSON(rw, http.StatusOK, iis)
+		case http.MethodPost:
+			rule := rules.Rule{}
+			d := json.NewDecoder(r.Body)
+			d.DisallowUnknownFields()
+
+			if err := d.Decode(&rule); err != nil {
+				writeError(rw, http.StatusBadRequest, fmt.Errorf("invalid rule: [%v]", err))
+				return
+			}
+
+			if rule.Name == "" {
+				writeError(rw, http.StatusBadRequest, fmt.Errorf("invalid rule: rule has no name"))
+				return
+			}
+
+			i, err := rule.Intercept()
+
+			if err != nil {
+				writeError(rw, http.StatusBadRequest, err)
+				return
+			}
+
+			mx.Lock()
+			id := proxy.SetIntercept(i)
+			created[id] = rule
+			mx.Unlock()
+
+			log.Printf(1, "created intercept [%v] from rule [%v] via admin api", id, rule.Name)
+
+			ii, _ := info(id)
+			writeJSON(rw, http.StatusCreated, ii)
+		default:
+			allow(rw, r, http.MethodGet, http.MethodPost)
+		}
+	})
+
+	mux.HandleFunc("/intercepts/", func(rw http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/intercepts/"))
+
+		if err != nil {
+			writeError(rw, http.StatusNotFound, fmt.Errorf("invalid intercept id in [%v]", r.URL.Path))
+			return
+		}
+
+		ii, ok := info(id)
+
+		if !ok {
+			writeError(rw, http.StatusNotFound, fmt.Errorf("intercept [%v] does not exist", id))
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(rw, http.StatusOK, ii)
+		case http.MethodDelete:
+			mx.Lock()
+			proxy.UnsetIntercept(id)
+			delete(created, id)
+			mx.Unlock()
+
+			log.Printf(1, "deleted intercept [%v] via admin api", id)
+
+			rw.WriteHeader(http.StatusNoContent)
+		case http.MethodPatch:
+			p := struct {
+				Enabled *bool `json:"enabled"`
+			}{}
+
+			if err := json.NewDecoder(r.Body).Decode(&p); err != nil || p.Enabled == nil {
+				writeError(rw, http.StatusBadRequest, fmt.Errorf("expected json body of the form {\"enabled\": bool}"))
+				return
+			}
+
+			if !proxy.EnableIntercept(id, *p.Enabled) {
+				writeError(rw, http.StatusNotFound, fmt.Errorf("intercept [%v] does not exist", id))
+				return
+			}
+
+			ii, _ = info(id)
+			writeJSON(rw, http.StatusOK, ii)
+		default:
+			allow(rw, r, http.MethodGet, http.MethodDelete, http.MethodPatch)
+		}
+	})
+
+	mux.HandleFunc("/exchanges", func(rw http.ResponseWriter, r *http.Request) {
+		if !allow(rw, r, http.MethodGet) {
+			return
+		}
+
+		q, limit, format := r.URL.Query(), 100, "jsonl"
+
+		if l := q.Get("limit"); l != "" {
+			var err error
+
+			if limit, err = strconv.Atoi(l); err != nil {
+				writeError(rw, http.StatusBadRequest, fmt.Errorf("invalid limit [%v]", l))
+				return
+			}
+		}
+
+		if f := q.Get("format"); f != "" {
+			format = f
+		}
+
+		f := capture.Filter{URL: q.Get("url"), Host: q.Get("host"), Path: q.Get("path"), Status: q.Get("status"), Method: q.Get("method"), Body: q.Get("body")}
+
+		for k, t := range map[string]*time.Time{"from": &f.From, "to": &f.To} {
+			if v := q.Get(k); v != "" {
+				var err error
+
+				if *t, err = time.Parse(time.RFC3339, v); err != nil {
+					writeError(rw, http.StatusBadRequest, fmt.Errorf("invalid %v time [%v], expected rfc3339", k, v))
+					return
+				}
+			}
+		}
+
+		b := bytes.Buffer{}
+
+		if err := store.Export(&b, format, f, limit, true, -1); err != nil {
+			writeError(rw, http.StatusBadRequest, err)
+			return
+		}
+
+		rw.Header().Set("Content-Type", contentTypes[format])
+		rw.Write(b.Bytes())
+	})
+
+	mux.HandleFunc("/events", func(rw http.ResponseWriter, r *http.Request) {
+		if !allow(rw, r, http.MethodGet) {
+			return
+		}
+
+		flusher, ok := rw.(http.Flusher)
+
+		if !ok {
+			writeError(rw, http.StatusInternalServerError, fmt.Errorf("streaming is not supported by the connection"))
+			return
+		}
+
+		c, unsubscribe := store.Subscribe(256)
+		defer unsubscribe()
+
+		rw.Header().Set("Content-Type", "text/event-stream")
+		rw.Header().Set("Cache-Control", "no-cache")
+		rw.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(15 * time.Second)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-heartbeat.C:
+				rw.Write([]byte(": heartbeat\n\n"))
+			case rec, ok := <-c:
+				if !ok {
+					return
+				}
+
+				b := bytes.Buffer{}
+
+				if err := intercept.WriteJSONL(&b, []*intercept.Record{rec}, true, -1); err != nil {
+					log.Printf(0, "unable to encode exchange [%v] as an admin api event: [%v]", rec.ID, err)
+					continue
+				}
+
+				fmt.Fprintf(rw, "event: exchange\ndata: %s\n\n", bytes.TrimSpace(b.Bytes()))
+			}
+
+			flusher.Flush()
+		}
+	})
+
+	mux.HandleFunc("/breakpoints", func(rw http.ResponseWriter, r *http.Request) {
+		if !allow(rw, r, http.MethodPost) {
+			return
+		}
+
+		bi := breakpointInfo{}
+		d := json.NewDecoder(r.Body)
+		d.DisallowUnknownFields()
+
+		if err := d.Decode(&bi); err != nil {
+			writeError(rw, http.StatusBadRequest, fmt.Errorf("invalid breakpoint: [%v]", err))
+			return
+		}
+
+		mrq, mrs, err := bi.Match.Funcs()
+
+		if err != nil {
+			writeError(rw, http.StatusBadRequest, fmt.Errorf("invalid breakpoint match: [%v]", err))
+			return
+		}
+
+		if bi.Name == "" {
+			bi.Name = "unnamed"
+		}
+
+		i, err := breakpoints.Intercept("breakpoint "+bi.Name, mrq, mrs, bi.On...)
+
+		if err != nil {
+			writeError(rw, http.StatusBadRequest, err)
+			return
+		}
+
+		id := proxy.SetIntercept(i)
+
+		log.Printf(1, "created breakpoint intercept [%v] named [%v] via admin api", id, bi.Name)
+
+		ii, _ := info(id)
+		writeJSON(rw, http.StatusCreated, ii)
+	})
+
+	held := func(h breakpoint.Held) heldInfo {
+		b := bytes.Buffer{}
+		rec := &intercept.Record{ID: h.Request.ID, Start: h.Request.Start, End: h.Request.End, Request: h.Request, Response: h.Response}
+
+		if rec.Response == nil {
+			rec.Response = &intercept.ProxyResponse{Exchange: h.Request.Exchange, Header: http.Header{}}
+		}
+
+		if err := intercept.WriteJSONL(&b, []*intercept.Record{rec}, true, -1); err != nil {
+			log.Printf(0, "unable to encode held %v [%v] as json: [%v]", h.On, h.ID, err)
+		}
+
+		return heldInfo{Held: h, Exchange: json.RawMessage(bytes.TrimSpace(b.Bytes()))}
+	}
+
+	mux.HandleFunc("/held", func(rw http.ResponseWriter, r *http.Request) {
+		if !allow(rw, r, http.MethodGet) {
+			return
+		}
+
+		his := []heldInfo{}
+
+		for _, h := range breakpoints.List() {
+			his = append(his, held(h))
+		}
+
+		writeJSON(rw, http.StatusOK, his)
+	})
+
+	mux.HandleFunc("/held/", func(rw http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/held/"), "/")
+		id, err := strconv.Atoi(parts[0])
+
+		if err != nil || len(parts) > 2 {
+			writeError(rw, http.StatusNotFound, fmt.Errorf("invalid held exchange in [%v]", r.URL.Path))
+			return
+		}
+
+		h, ok := breakpoints.Get(id)
+
+		if !ok {
+			writeError(rw, http.StatusNotFound, fmt.Errorf("nothing is held with id [%v]", id))
+			return
+		}
+
+		if len(parts) == 1 {
+			if allow(rw, r, http.MethodGet) {
+				writeJSON(rw, http.StatusOK, held(h))
+			}
+
+			return
+		}
+
+		if !allow(rw, r, http.MethodPost) {
+			return
+		}
+
+		switch parts[1] {
+		case "forward":
+			e := breakpoint.Edit{}
+
+			if r.ContentLength != 0 {
+				d := json.NewDecoder(r.Body)
+				d.DisallowUnknownFields()
+
+				if err := d.Decode(&e); err != nil && err != io.EOF {
+					writeError(rw, http.StatusBadRequest, fmt.Errorf("invalid edit: [%v]", err))
+					return
+				}
+			}
+
+			err = breakpoints.Forward(id, e)
+		case "drop":
+			err = breakpoints.Drop(id)
+		default:
+			writeError(rw, http.StatusNotFound, fmt.Errorf("unsupported action [%v] on held exchange, expected forward or drop", parts[1]))
+			return
+		}
+
+		if err != nil {
+			writeError(rw, http.StatusBadRequest, err)
+			return
+		}
+
+		rw.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.Handle("/ui/", http.StripPrefix("/ui/", http.FileServer(http.FS(ui))))
+
+	mux.HandleFunc("/", func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			writeError(rw, http.StatusNotFound, fmt.Errorf("[%v] not found", r.URL.Path))
+			return
+		}
+
+		http.Redirect(rw, r, (&url.URL{Path: "/ui/", RawQuery: r.URL.RawQuery}).String(), http.StatusFound)
+	})
+
+	return guard(mux, addr, token)
+}
+
+// guard returns a http.Handler that passes requests to h where they present token, if set, or are otherwise addressed to a
+// loopback host or addr, and, where their method can change state, are not cross-origin and have no body or a json body
+func guard(h http.Handler, addr, token string) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			host := r.Host
+
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+
+			host = strings.Trim(host, "[]")
+
+			if ip := net.ParseIP(host); !strings.EqualFold(host, "localhost") && (ip == nil || !ip.IsLoopback()) && (addr == "" || !strings.EqualFold(host, addr)) {
+				writeError(rw, http.StatusForbidden, fmt.Errorf("request for host [%v] for [%v %v] not allowed without a token", r.Host, r.Method, r.URL.Path))
+				return
+			}
+		} else {
+			t := r.URL.Query().Get("token")
+
+			if a := r.Header.Get("Authorization"); strings.HasPrefix(a, "Bearer ") {
+				t = strings.TrimPrefix(a, "Bearer ")
+			}
+
+			if subtle.ConstantTimeCompare([]byte(t), []byte(token)) != 1 {
+				writeError(rw, http.StatusUnauthorized, fmt.Errorf("missing or invalid token for [%v %v]", r.Method, r.URL.Path))
+				return
+			}
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			h.ServeHTTP(rw, r)
+			return
+		}
+
+		if o := r.Header.Get("Origin"); o != "" {
+			if u, err := url.Parse(o); err != nil || !strings.EqualFold(u.Host, r.Host) {
+				writeError(rw, http.StatusForbidden, fmt.Errorf("cross-origin request from [%v] for [%v %v] not allowed", o, r.Method, r.URL.Path))
+				return
+			}
+		}
+
+		if r.ContentLength != 0 {
+			if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mt != "application/json" {
+				writeError(rw, http.StatusUnsupportedMediaType, fmt.Errorf("expected a content type of application/json for [%v %v]", r.Method, r.URL.Path))
+				return
+			}
+		}
+
+		h.ServeHTTP(rw, r)
+	})
+}
+
+var contentTypes = map[string]string{"text": "text/plain; charset=utf-8", "jsonl": "application/x-ndjson", "har": "application/json"}
+
+// allow writes a 405 to rw, and returns false, if the method of r is not one of methods
+func allow(rw http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+
+	rw.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(rw, http.StatusMethodNotAllowed, fmt.Errorf("method [%v] not allowed on [%v]", r.Method, r.URL.Path))
+
+	return false
+}
+
+func writeJSON(rw http.ResponseWriter, status int, v interface{}) {
+	b, err := json.Marshal(v)
+
+	if err != nil {
+		log.Printf(0, "unable to encode admin api response: [%v]", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	rw.Write(append(b, '\n'))
+}
+
+func writeError(rw http.ResponseWriter, status int, err error) {
+	log.Printf(2, "admin api error: [%v]", err)
+	writeJSON(rw, status, map[string]string{"error": err.Error()})
+}
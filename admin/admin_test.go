@@ -0,0 +1,246 @@
+package admin
+
+import (
+	"bufio"
+	"comradequinn/hflow/breakpoint"
+	"comradequinn/hflow/proxy"
+	"comradequinn/hflow/capture"
+	"comradequinn/hflow/proxy/intercept"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandler(t *testing.T) {
+	store := capture.NewStore(10, 0)
+	u, _ := url.Parse("http://www.test.com/")
+
+	store.Add(&intercept.Record{
+		ID:       1,
+		Request:  &intercept.ProxyRequest{Method: http.MethodGet, URL: *u, Header: http.Header{}},
+		Response: &intercept.ProxyResponse{Status: "200 OK", StatusCode: http.StatusOK, Header: http.Header{}},
+	})
+
+	breakpoints := breakpoint.NewRegistry(0)
+	svr := httptest.NewServer(Handler(store, breakpoints, map[string]int{"api": 0}, "127.0.0.1", ""))
+	defer svr.Close()
+
+	do := func(method, path, body string, expStatus int) []byte {
+		rq, _ := http.NewRequest(method, svr.URL+path, strings.NewReader(body))
+
+		if body != "" {
+			rq.Header.Set("Content-Type", "application/json")
+		}
+
+		rs, err := http.DefaultClient.Do(rq)
+
+		if err != nil {
+			t.Fatalf("expected no error calling [%v %v], got [%v]", method, path, err)
+		}
+
+		defer rs.Body.Close()
+
+		b, _ := io.ReadAll(rs.Body)
+
+		if rs.StatusCode != expStatus {
+			t.Fatalf("expected status [%v] from [%v %v], got [%v]: [%v]", expStatus, method, path, rs.StatusCode, string(b))
+		}
+
+		return b
+	}
+
+	ii := interceptInfo{}
+
+	if err := json.Unmarshal(do(http.MethodPost, "/intercepts", `{ "name": "test", "actions": [ { "type": "set_header", "name": "A", "value": "B" } ] }`, http.StatusCreated), &ii); err != nil {
+		t.Fatalf("expected created intercept to be returned as json, got [%v]", err)
+	}
+
+	if ii.Label != "rule test" || ii.Rule == nil || ii.Rule.Name != "test" {
+		t.Fatalf("expected created intercept to describe rule [test], got [%+v]", ii)
+	}
+
+	iis := []interceptInfo{}
+	json.Unmarshal(do(http.MethodGet, "/intercepts", "", http.StatusOK), &iis)
+
+	found := false
+
+	for _, i := range iis {
+		found = found || i.ID == ii.ID
+	}
+
+	if !found {
+		t.Fatalf("expected created intercept [%v] to be listed, got [%+v]", ii.ID, iis)
+	}
+
+	if err := json.Unmarshal(do(http.MethodPatch, fmt.Sprintf("/intercepts/%v", ii.ID), `{ "enabled": false }`, http.StatusOK), &ii); err != nil || ii.Enabled {
+		t.Fatalf("expected intercept to be disabled, got [%+v] and [%v]", ii, err)
+	}
+
+	do(http.MethodPatch, fmt.Sprintf("/intercepts/%v", ii.ID), `{}`, http.StatusBadRequest)
+	do(http.MethodPost, "/intercepts", `{ "name": "invalid", "actions": [ { "type": "unknown" } ] }`, http.StatusBadRequest)
+	do(http.MethodDelete, fmt.Sprintf("/intercepts/%v", ii.ID), "", http.StatusNoContent)
+	do(http.MethodGet, fmt.Sprintf("/intercepts/%v", ii.ID), "", http.StatusNotFound)
+	do(http.MethodPut, "/status", "", http.StatusMethodNotAllowed)
+
+	st := statusInfo{}
+	json.Unmarshal(do(http.MethodGet, "/status", "", http.StatusOK), &st)
+
+	if st.ExchangesHeld != 1 {
+		t.Fatalf("expected status to report [1] exchange held, got [%v]", st.ExchangesHeld)
+	}
+
+	if b := do(http.MethodGet, "/exchanges?limit=10", "", http.StatusOK); !strings.Contains(string(b), u.String()) {
+		t.Fatalf("expected exchanges to contain [%v], got [%v]", u.String(), string(b))
+	}
+
+	if b := do(http.MethodGet, "/exchanges?host=other.com", "", http.StatusOK); len(b) != 0 {
+		t.Fatalf("expected no exchanges to match host filter, got [%v]", string(b))
+	}
+
+	if b := do(http.MethodGet, "/exchanges?status=2xx&method=get&format=har", "", http.StatusOK); !strings.Contains(string(b), `"entries"`) || !strings.Contains(string(b), u.String()) {
+		t.Fatalf("expected exchanges as har containing [%v], got [%v]", u.String(), string(b))
+	}
+
+	do(http.MethodGet, "/exchanges?from=yesterday", "", http.StatusBadRequest)
+	do(http.MethodGet, "/exchanges?format=unknown", "", http.StatusBadRequest)
+
+	if b := do(http.MethodGet, "/ui/", "", http.StatusOK); !strings.Contains(string(b), "<title>hflow</title>") {
+		t.Fatalf("expected web ui to be served, got [%v]", string(b))
+	}
+
+	t.Run("Breakpoints", func(t *testing.T) {
+		if err := json.Unmarshal(do(http.MethodPost, "/breakpoints", `{ "name": "bp", "match": { "path": "/bp" }, "on": ["request"] }`, http.StatusCreated), &ii); err != nil {
+			t.Fatalf("expected created breakpoint to be returned as json, got [%v]", err)
+		}
+
+		defer proxy.UnsetIntercept(ii.ID)
+
+		do(http.MethodPost, "/breakpoints", `{ "name": "invalid", "on": ["unknown"] }`, http.StatusBadRequest)
+
+		rqs := make(chan *http.Request, 1)
+
+		go func() {
+			rq, _ := http.NewRequest(http.MethodGet, "http://www.test.com/bp", nil)
+			rq, _, _ = intercept.Request(intercept.NewExchange("127.0.0.1:50000", 0), rq, proxy.Intercepts())
+			rqs <- rq
+		}()
+
+		his := []heldInfo{}
+
+		for i := 0; i < 100 && len(his) == 0; i++ {
+			time.Sleep(time.Millisecond * 10)
+			json.Unmarshal(do(http.MethodGet, "/held", "", http.StatusOK), &his)
+		}
+
+		if len(his) != 1 || !strings.Contains(string(his[0].Exchange), "http://www.test.com/bp") {
+			t.Fatalf("expected request to be held, got [%+v]", his)
+		}
+
+		do(http.MethodPost, fmt.Sprintf("/held/%v/forward", his[0].ID), `{ "status_code": 500 }`, http.StatusBadRequest)
+		do(http.MethodPost, fmt.Sprintf("/held/%v/forward", his[0].ID), `{ "method": "POST" }`, http.StatusNoContent)
+
+		if rq := <-rqs; rq.Method != http.MethodPost {
+			t.Fatalf("expected forwarded request to be edited, got method [%v]", rq.Method)
+		}
+
+		do(http.MethodPost, fmt.Sprintf("/held/%v/drop", his[0].ID), "", http.StatusNotFound)
+	})
+
+	t.Run("Guard", func(t *testing.T) {
+		rule := `{ "name": "guarded", "actions": [ { "type": "drop" } ] }`
+
+		test := func(t *testing.T, svr *httptest.Server, method, path string, h http.Header, expStatus int) {
+			rq, _ := http.NewRequest(method, svr.URL+path, strings.NewReader(rule))
+
+			for k, v := range h {
+				rq.Header[k] = v
+			}
+
+			if host := h.Get("Host"); host != "" {
+				rq.Host = host
+			}
+
+			rs, err := http.DefaultClient.Do(rq)
+
+			if err != nil {
+				t.Fatalf("expected no error calling [%v %v], got [%v]", method, path, err)
+			}
+
+			defer rs.Body.Close()
+
+			if rs.StatusCode != expStatus {
+				t.Fatalf("expected status [%v] from [%v %v], got [%v]", expStatus, method, path, rs.StatusCode)
+			}
+
+			if rs.StatusCode == http.StatusCreated {
+				json.NewDecoder(rs.Body).Decode(&ii)
+				proxy.UnsetIntercept(ii.ID)
+			}
+		}
+
+		tokenSvr := httptest.NewServer(Handler(store, breakpoints, map[string]int{"api": 0}, "127.0.0.1", "secret"))
+		defer tokenSvr.Close()
+
+		addrSvr := httptest.NewServer(Handler(store, breakpoints, map[string]int{"api": 0}, "hflow.test.com", ""))
+		defer addrSvr.Close()
+
+		jsonCT := http.Header{"Content-Type": []string{"application/json"}}
+
+		test(t, svr, http.MethodPost, "/intercepts", http.Header{"Content-Type": []string{"text/plain"}}, http.StatusUnsupportedMediaType)
+		test(t, svr, http.MethodPost, "/intercepts", http.Header{}, http.StatusUnsupportedMediaType)
+		test(t, svr, http.MethodPost, "/intercepts", http.Header{"Content-Type": jsonCT["Content-Type"], "Origin": []string{"http://www.test.com"}}, http.StatusForbidden)
+		test(t, svr, http.MethodPost, "/intercepts", http.Header{"Content-Type": jsonCT["Content-Type"], "Origin": []string{"null"}}, http.StatusForbidden)
+		test(t, svr, http.MethodPost, "/intercepts", http.Header{"Content-Type": jsonCT["Content-Type"], "Origin": []string{svr.URL}}, http.StatusCreated)
+		test(t, tokenSvr, http.MethodGet, "/status", http.Header{}, http.StatusUnauthorized)
+		test(t, tokenSvr, http.MethodGet, "/status", http.Header{"Authorization": []string{"Bearer wrong"}}, http.StatusUnauthorized)
+		test(t, tokenSvr, http.MethodGet, "/status", http.Header{"Authorization": []string{"Bearer secret"}}, http.StatusOK)
+		test(t, tokenSvr, http.MethodGet, "/status?token=secret", http.Header{}, http.StatusOK)
+		test(t, tokenSvr, http.MethodPost, "/intercepts?token=secret", jsonCT, http.StatusCreated)
+		test(t, svr, http.MethodGet, "/status", http.Header{"Host": []string{"rebind.test.com"}}, http.StatusForbidden)
+		test(t, svr, http.MethodGet, "/status", http.Header{"Host": []string{"localhost:8888"}}, http.StatusOK)
+		test(t, svr, http.MethodGet, "/status", http.Header{"Host": []string{"[::1]:8888"}}, http.StatusOK)
+		test(t, addrSvr, http.MethodGet, "/status", http.Header{"Host": []string{"hflow.test.com:8888"}}, http.StatusOK)
+		test(t, addrSvr, http.MethodGet, "/status", http.Header{"Host": []string{"rebind.test.com:8888"}}, http.StatusForbidden)
+		test(t, tokenSvr, http.MethodGet, "/status?token=secret", http.Header{"Host": []string{"rebind.test.com"}}, http.StatusOK)
+	})
+
+	t.Run("Events", func(t *testing.T) {
+		rs, err := http.Get(svr.URL + "/events")
+
+		if err != nil {
+			t.Fatalf("expected no error subscribing to events, got [%v]", err)
+		}
+
+		defer rs.Body.Close()
+
+		if ct := rs.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Fatalf("expected event stream, got content type [%v]", ct)
+		}
+
+		store.Add(&intercept.Record{
+			ID:       2,
+			Request:  &intercept.ProxyRequest{Method: http.MethodGet, URL: *u, Header: http.Header{}},
+			Response: &intercept.ProxyResponse{Status: "200 OK", StatusCode: http.StatusOK, Header: http.Header{}},
+		})
+
+		sc := bufio.NewScanner(rs.Body)
+
+		for sc.Scan() {
+			if l := sc.Text(); strings.HasPrefix(l, "data: ") {
+				if !strings.Contains(l, `"id":2`) {
+					t.Fatalf("expected event for exchange [2], got [%v]", l)
+				}
+
+				return
+			}
+		}
+
+		t.Fatalf("expected an exchange event, got [%v]", sc.Err())
+	})
+}
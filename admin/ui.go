@@ -0,0 +1,21 @@
+package admin
+
+import (
+	"comradequinn/hflow/log"
+	"embed"
+	"io/fs"
+)
+
+//go:embed ui
+var uiFS embed.FS
+
+// ui holds the static files of the web ui, rooted at the ui directory
+var ui = func() fs.FS {
+	f, err := fs.Sub(uiFS, "ui")
+
+	if err != nil {
+		log.Panicf(0, "unable to load embedded web ui: [%v]", err)
+	}
+
+	return f
+}()
@@ -0,0 +1,304 @@
+// Package breakpoint provides intercepts that hold matching requests and responses until a user edits, forwards or
+// drops them
+package breakpoint
+
+import (
+	"comradequinn/hflow/log"
+	"comradequinn/hflow/proxy/intercept"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// OnRequest describes a request held before it is sent upstream
+	OnRequest = "request"
+	// OnResponse describes a response held before it is written to the client
+	OnResponse = "response"
+)
+
+// Held describes a request or response held at a breakpoint. Response is nil for held requests. For held responses,
+// Request describes the request that the response was received for
+type Held struct {
+	ID       int                      `json:"id"`
+	On       string                   `json:"on"`
+	Label    string                   `json:"label"`
+	Since    time.Time                `json:"since"`
+	Deadline time.Time                `json:"deadline,omitempty"`
+	Request  *intercept.ProxyRequest  `json:"-"`
+	Response *intercept.ProxyResponse `json:"-"`
+
+	release chan error
+}
+
+// Edit describes changes to make to a held request or response. Unset fields are left unchanged. Method and URL apply
+// only to requests and StatusCode only to responses. A non-nil Header replaces all existing headers
+type Edit struct {
+	Method     string      `json:"method,omitempty"`
+	URL        string      `json:"url,omitempty"`
+	StatusCode int         `json:"status_code,omitempty"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       *string     `json:"body,omitempty"`
+}
+
+// Registry holds requests and responses at breakpoints until they are forwarded or dropped, or their timeout elapses
+type Registry struct {
+	mx      sync.Mutex
+	held    map[int]*Held
+	id      int
+	timeout time.Duration
+}
+
+// NewRegistry returns a *Registry that forwards held requests and responses unchanged once they have been held for
+// timeout. A timeout of 0 holds them until they are forwarded or dropped
+func NewRegistry(timeout time.Duration) *Registry {
+	return &Registry{held: map[int]*Held{}, timeout: timeout}
+}
+
+// Intercept returns an *intercept.Intercept that holds requests matching mrq where on includes OnRequest and responses
+// matching mrs where on includes OnResponse. The Intercept is applied after all other PhaseModify intercepts, unless
+// its priority is changed, so that what is held reflects their changes and what is recorded reflects any edits. It
+// requires buffering so that held bodies can be edited
+func (r *Registry) Intercept(label string, mrq intercept.MatchRequestFunc, mrs intercept.MatchResponseFunc, on ...string) (*intercept.Intercept, error) {
+	onRq, onRs := false, false
+
+	for _, o := range on {
+		switch o {
+		case OnRequest:
+			onRq = true
+		case OnResponse:
+			onRs = true
+		default:
+			return nil, fmt.Errorf("invalid breakpoint target [%v], expected [%v] or [%v]", o, OnRequest, OnResponse)
+		}
+	}
+
+	if !onRq && !onRs {
+		return nil, fmt.Errorf("breakpoint must target requests, responses or both")
+	}
+
+	return intercept.NewIntercept(label,
+		func(pr *intercept.ProxyRequest) (bool, error) {
+			if !onRq {
+				return false, nil
+			}
+
+			return mrq(pr)
+		},
+		func(pr *intercept.ProxyRequest, rs *intercept.ProxyResponse) (bool, error) {
+			if !onRs {
+				return false, nil
+			}
+
+			return mrs(pr, rs)
+		},
+		func(pr *intercept.ProxyRequest) error {
+			return r.hold(&Held{On: OnRequest, Label: label, Request: pr})
+		},
+		func(rs *intercept.ProxyResponse) error {
+			pr := &intercept.ProxyRequest{Exchange: rs.Exchange, Header: http.Header{}}
+
+			if rs.Request != nil {
+				pr.Method, pr.URL, pr.Header = rs.Request.Method, *rs.Request.URL, rs.Request.Header.Clone()
+			}
+
+			return r.hold(&Held{On: OnResponse, Label: label, Request: pr, Response: rs})
+		},
+	).WithPriority(math.MaxInt32).WithBuffering(true), nil
+}
+
+// hold blocks until h is released, returning intercept.ErrDrop if it was dropped
+func (r *Registry) hold(h *Held) error {
+	h.Since, h.release = time.Now(), make(chan error, 1)
+
+	var timeout <-chan time.Time
+
+	if r.timeout > 0 {
+		h.Deadline = h.Since.Add(r.timeout)
+		t := time.NewTimer(r.timeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	r.mx.Lock()
+	r.id++
+	h.ID = r.id
+	log.Printf(0, "holding %v [%v] for [%v] at breakpoint labelled [%v]", h.On, h.ID, h.Request.URL.String(), h.Label)
+	r.held[h.ID] = h
+	r.mx.Unlock()
+
+	select {
+	case err := <-h.release:
+		return err
+	case <-timeout:
+		r.mx.Lock()
+		defer r.mx.Unlock()
+
+		if _, ok := r.held[h.ID]; !ok {
+			return <-h.release
+		}
+
+		delete(r.held, h.ID)
+		log.Printf(0, "breakpoint timeout of [%v] elapsed for held %v [%v], forwarding it unchanged", r.timeout, h.On, h.ID)
+
+		return nil
+	}
+}
+
+// List returns copies of all held requests and responses, oldest first
+func (r *Registry) List() []Held {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	hs := make([]Held, 0, len(r.held))
+
+	for _, h := range r.held {
+		hs = append(hs, h.copy())
+	}
+
+	sort.Slice(hs, func(a, b int) bool { return hs[a].ID < hs[b].ID })
+
+	return hs
+}
+
+// Get returns a copy of the held request or response identified by id
+func (r *Registry) Get(id int) (Held, bool) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	h, ok := r.held[id]
+
+	if !ok {
+		return Held{}, false
+	}
+
+	return h.copy(), true
+}
+
+// Edit applies e to the held request or response identified by id, which remains held
+func (r *Registry) Edit(id int, e Edit) error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	h, ok := r.held[id]
+
+	if !ok {
+		return fmt.Errorf("no request or response is held with id [%v]", id)
+	}
+
+	return e.apply(h)
+}
+
+// Forward applies e to the held request or response identified by id and releases it
+func (r *Registry) Forward(id int, e Edit) error {
+	return r.release(id, func(h *Held) error {
+		if err := e.apply(h); err != nil {
+			return err
+		}
+
+		log.Printf(0, "forwarding held %v [%v] for [%v]", h.On, h.ID, h.Request.URL.String())
+
+		h.release <- nil
+
+		return nil
+	})
+}
+
+// Drop releases the held request or response identified by id, causing the client connection to be closed
+func (r *Registry) Drop(id int) error {
+	return r.release(id, func(h *Held) error {
+		log.Printf(0, "dropping held %v [%v] for [%v]", h.On, h.ID, h.Request.URL.String())
+
+		h.release <- intercept.ErrDrop
+
+		return nil
+	})
+}
+
+func (r *Registry) release(id int, f func(*Held) error) error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	h, ok := r.held[id]
+
+	if !ok {
+		return fmt.Errorf("no request or response is held with id [%v]", id)
+	}
+
+	if err := f(h); err != nil {
+		return err
+	}
+
+	delete(r.held, id)
+
+	return nil
+}
+
+func (h *Held) copy() Held {
+	c := *h
+	c.Request, c.release = h.Request.Clone(), nil
+
+	if h.Response != nil {
+		c.Response = h.Response.Clone()
+	}
+
+	return c
+}
+
+func (e Edit) apply(h *Held) error {
+	if h.On == OnResponse {
+		if e.Method != "" || e.URL != "" {
+			return fmt.Errorf("method and url cannot be edited on a held response")
+		}
+
+		if e.StatusCode != 0 {
+			if e.StatusCode < 100 || e.StatusCode > 999 {
+				return fmt.Errorf("invalid status code [%v]", e.StatusCode)
+			}
+
+			h.Response.StatusCode, h.Response.Status = e.StatusCode, fmt.Sprintf("%v %v", e.StatusCode, http.StatusText(e.StatusCode))
+		}
+
+		if e.Header != nil {
+			h.Response.Header = e.Header.Clone()
+		}
+
+		if e.Body != nil {
+			h.Response.Body = []byte(*e.Body)
+		}
+
+		return nil
+	}
+
+	if e.StatusCode != 0 {
+		return fmt.Errorf("status code cannot be edited on a held request")
+	}
+
+	if e.URL != "" {
+		u, err := url.Parse(e.URL)
+
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid url [%v], an absolute url is required", e.URL)
+		}
+
+		h.Request.URL = *u
+	}
+
+	if e.Method != "" {
+		h.Request.Method = e.Method
+	}
+
+	if e.Header != nil {
+		h.Request.Header = e.Header.Clone()
+	}
+
+	if e.Body != nil {
+		h.Request.Body = []byte(*e.Body)
+	}
+
+	return nil
+}
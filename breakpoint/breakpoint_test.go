@@ -0,0 +1,126 @@
+package breakpoint
+
+import (
+	"comradequinn/hflow/proxy/intercept"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	request := func(t *testing.T, r *Registry) (chan *http.Request, chan error) {
+		i, err := r.Intercept("test", intercept.MatchAllRequests, intercept.MatchAllResponses, OnRequest)
+
+		if err != nil {
+			t.Fatalf("expected no error creating breakpoint, got [%v]", err)
+		}
+
+		rqs, errs := make(chan *http.Request, 1), make(chan error, 1)
+
+		go func() {
+			rq, _ := http.NewRequest(http.MethodGet, "http://www.test.com/", strings.NewReader("rq-body"))
+			rq, _, err := intercept.Request(intercept.NewExchange("127.0.0.1:50000", 0), rq, map[int]*intercept.Intercept{1: i})
+			rqs <- rq
+			errs <- err
+		}()
+
+		return rqs, errs
+	}
+
+	held := func(t *testing.T, r *Registry) Held {
+		for i := 0; i < 100; i++ {
+			if hs := r.List(); len(hs) > 0 {
+				return hs[0]
+			}
+
+			time.Sleep(time.Millisecond * 10)
+		}
+
+		t.Fatalf("expected request to be held")
+
+		return Held{}
+	}
+
+	t.Run("Forward", func(t *testing.T) {
+		r := NewRegistry(0)
+		rqs, errs := request(t, r)
+		h := held(t, r)
+
+		if h.On != OnRequest || h.Request.URL.String() != "http://www.test.com/" {
+			t.Fatalf("expected held request for [http://www.test.com/], got [%v] for [%v]", h.On, h.Request.URL.String())
+		}
+
+		body := "edited-body"
+
+		if err := r.Edit(h.ID, Edit{Method: http.MethodPost}); err != nil {
+			t.Fatalf("expected no error editing held request, got [%v]", err)
+		}
+
+		if err := r.Forward(h.ID, Edit{URL: "http://edited.test.com/", Body: &body}); err != nil {
+			t.Fatalf("expected no error forwarding held request, got [%v]", err)
+		}
+
+		rq, err := <-rqs, <-errs
+
+		if err != nil {
+			t.Fatalf("expected no error from forwarded request, got [%v]", err)
+		}
+
+		b, _ := io.ReadAll(rq.Body)
+
+		if rq.Method != http.MethodPost || rq.URL.String() != "http://edited.test.com/" || string(b) != body {
+			t.Fatalf("expected edited request, got [%v %v] with body [%v]", rq.Method, rq.URL.String(), string(b))
+		}
+
+		if len(r.List()) != 0 {
+			t.Fatalf("expected forwarded request to no longer be held")
+		}
+	})
+
+	t.Run("Drop", func(t *testing.T) {
+		r := NewRegistry(0)
+		_, errs := request(t, r)
+
+		if err := r.Drop(held(t, r).ID); err != nil {
+			t.Fatalf("expected no error dropping held request, got [%v]", err)
+		}
+
+		if err := <-errs; err != intercept.ErrDrop {
+			t.Fatalf("expected dropped request to return [%v], got [%v]", intercept.ErrDrop, err)
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		r := NewRegistry(time.Millisecond * 50)
+		rqs, errs := request(t, r)
+
+		if rq, err := <-rqs, <-errs; err != nil || rq.URL.String() != "http://www.test.com/" {
+			t.Fatalf("expected request to be forwarded unchanged after timeout, got [%v] and [%v]", rq, err)
+		}
+	})
+
+	t.Run("InvalidEdit", func(t *testing.T) {
+		r := NewRegistry(0)
+		_, errs := request(t, r)
+		h := held(t, r)
+
+		if err := r.Forward(h.ID, Edit{StatusCode: 500}); err == nil {
+			t.Fatalf("expected error editing status code of held request")
+		}
+
+		if err := r.Forward(h.ID, Edit{URL: "/relative"}); err == nil {
+			t.Fatalf("expected error editing url of held request to a relative url")
+		}
+
+		r.Drop(h.ID)
+		<-errs
+	})
+
+	t.Run("InvalidTarget", func(t *testing.T) {
+		if _, err := NewRegistry(0).Intercept("test", intercept.MatchAllRequests, intercept.MatchAllResponses, "unknown"); err == nil {
+			t.Fatalf("expected error creating breakpoint with an invalid target")
+		}
+	})
+}
@@ -0,0 +1,150 @@
+// Package capture provides an in-memory store of captured http exchanges
+package capture
+
+import (
+	"comradequinn/hflow/log"
+	"comradequinn/hflow/proxy/intercept"
+	"io"
+	"sync"
+)
+
+// Store holds the most recently captured exchanges in a ring buffer bounded by a maximum count and total size in bytes
+type Store struct {
+	mx       sync.RWMutex
+	recs     []*intercept.Record
+	sizes    []int64
+	head     int
+	n        int
+	size     int64
+	maxBytes int64
+	total    uint64
+	subs     map[chan *intercept.Record]struct{}
+}
+
+// NewStore returns a *Store that holds, at most, the maxCount most recently captured exchanges. If maxBytes is greater
+// than 0, the oldest exchanges are also removed while the total size of the held exchanges exceeds maxBytes
+func NewStore(maxCount int, maxBytes int64) *Store {
+	if maxCount < 1 {
+		log.Panicf(0, "capture store must hold at least 1 exchange, got [%v]", maxCount)
+	}
+
+	return &Store{
+		recs:     make([]*intercept.Record, maxCount),
+		sizes:    make([]int64, maxCount),
+		maxBytes: maxBytes,
+		subs:     map[chan *intercept.Record]struct{}{},
+	}
+}
+
+// Intercept returns an *intercept.Intercept that adds exchanges matching mrq and mrs to the Store
+func (s *Store) Intercept(label string, mrq intercept.MatchRequestFunc, mrs intercept.MatchResponseFunc) *intercept.Intercept {
+	return intercept.Recorder(label, mrq, mrs, s.Add)
+}
+
+// Add adds rec to the Store, removing the oldest exchanges until the Store is within its bounds. An exchange that alone
+// exceeds the maximum size of the Store is not held
+func (s *Store) Add(rec *intercept.Record) {
+	sz := Size(rec)
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.total++
+
+	for c := range s.subs {
+		select {
+		case c <- rec:
+		default:
+			log.Printf(2, "capture store subscriber is not keeping up, exchange [%v] was not delivered to it", rec.ID)
+		}
+	}
+
+	if s.maxBytes > 0 && sz > s.maxBytes {
+		log.Printf(2, "exchange [%v] of [%v] bytes exceeds capture store size of [%v] bytes and was not held", rec.ID, sz, s.maxBytes)
+		return
+	}
+
+	for s.n == len(s.recs) || (s.maxBytes > 0 && s.n > 0 && s.size+sz > s.maxBytes) {
+		s.evict()
+	}
+
+	i := (s.head + s.n) % len(s.recs)
+	s.recs[i], s.sizes[i] = rec, sz
+	s.n++
+	s.size += sz
+}
+
+func (s *Store) evict() {
+	s.size -= s.sizes[s.head]
+	s.recs[s.head], s.sizes[s.head] = nil, 0
+	s.head = (s.head + 1) % len(s.recs)
+	s.n--
+}
+
+// Subscribe returns a channel on which each exchange subsequently added to the Store is sent, and a func that ends the
+// subscription and closes the channel. The channel is buffered to size; exchanges added while it is full are not sent
+func (s *Store) Subscribe(size int) (<-chan *intercept.Record, func()) {
+	c, once := make(chan *intercept.Record, size), sync.Once{}
+
+	s.mx.Lock()
+	s.subs[c] = struct{}{}
+	s.mx.Unlock()
+
+	return c, func() {
+		once.Do(func() {
+			s.mx.Lock()
+			delete(s.subs, c)
+			close(c)
+			s.mx.Unlock()
+		})
+	}
+}
+
+// Recent returns, at most, the n most recently captured exchanges, oldest first. If n is less than 0, all held exchanges
+// are returned
+func (s *Store) Recent(n int) []*intercept.Record {
+	return s.Query(Filter{}, n)
+}
+
+// Query returns, at most, the n most recently captured exchanges that match f, oldest first. If n is less than 0, all
+// matching exchanges are returned
+func (s *Store) Query(f Filter, n int) []*intercept.Record {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	recs := []*intercept.Record{}
+
+	for i := s.n - 1; i >= 0 && (n < 0 || len(recs) < n); i-- {
+		if rec := s.recs[(s.head+i)%len(s.recs)]; f.Match(rec) {
+			recs = append(recs, rec)
+		}
+	}
+
+	for i, j := 0, len(recs)-1; i < j; i, j = i+1, j-1 {
+		recs[i], recs[j] = recs[j], recs[i]
+	}
+
+	return recs
+}
+
+// Export writes, at most, the n most recently captured exchanges that match f to w in the specified format, which must
+// be one of intercept.Formats. The binary and limit arguments are as per intercept.WriteRecords
+func (s *Store) Export(w io.Writer, format string, f Filter, n int, binary bool, limit int) error {
+	return intercept.WriteRecords(w, format, s.Query(f, n), binary, limit)
+}
+
+// Len returns the number of exchanges held in the Store and the total number of exchanges ever added to it
+func (s *Store) Len() (int, uint64) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	return s.n, s.total
+}
+
+// Size returns the number of bytes held in the Store
+func (s *Store) Size() int64 {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	return s.size
+}
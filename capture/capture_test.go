@@ -0,0 +1,138 @@
+package capture
+
+import (
+	"bytes"
+	"comradequinn/hflow/proxy/intercept"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func record(id uint64, method, rawURL string, status int, body string, start time.Time) *intercept.Record {
+	u, _ := url.Parse(rawURL)
+
+	return &intercept.Record{
+		ID:       id,
+		Start:    start,
+		End:      start,
+		Request:  &intercept.ProxyRequest{Method: method, URL: *u, Header: http.Header{}},
+		Response: &intercept.ProxyResponse{StatusCode: status, Status: http.StatusText(status), Header: http.Header{}, Body: []byte(body)},
+	}
+}
+
+func TestStore(t *testing.T) {
+	t.Run("Count", func(t *testing.T) {
+		s := NewStore(2, 0)
+
+		for i := uint64(1); i <= 3; i++ {
+			s.Add(&intercept.Record{ID: i, Request: &intercept.ProxyRequest{}})
+		}
+
+		held, total := s.Len()
+
+		if held != 2 || total != 3 {
+			t.Fatalf("expected store to hold [2] of [3] exchanges, got [%v] of [%v]", held, total)
+		}
+
+		recs := s.Recent(-1)
+
+		if len(recs) != 2 || recs[0].ID != 2 || recs[1].ID != 3 {
+			t.Fatalf("expected store to hold the most recent exchanges, oldest first, got [%+v]", recs)
+		}
+
+		if recs = s.Recent(1); len(recs) != 1 || recs[0].ID != 3 {
+			t.Fatalf("expected store to return the most recent exchange, got [%+v]", recs)
+		}
+	})
+
+	t.Run("Bytes", func(t *testing.T) {
+		now := time.Now()
+		rec := record(1, http.MethodGet, "http://a/", 200, strings.Repeat("x", 100), now)
+		sz := Size(rec)
+		s := NewStore(10, sz*2)
+
+		for i := uint64(1); i <= 3; i++ {
+			s.Add(record(i, http.MethodGet, "http://a/", 200, strings.Repeat("x", 100), now))
+		}
+
+		if recs := s.Recent(-1); len(recs) != 2 || recs[0].ID != 2 || s.Size() != sz*2 {
+			t.Fatalf("expected store to hold [2] exchanges of [%v] bytes, got [%v] of [%v] bytes", sz*2, len(recs), s.Size())
+		}
+
+		s.Add(record(4, http.MethodGet, "http://a/", 200, strings.Repeat("x", 1000), now))
+
+		if held, total := s.Len(); held != 2 || total != 4 {
+			t.Fatalf("expected oversized exchange not to be held, got [%v] of [%v] exchanges held", held, total)
+		}
+	})
+}
+
+func TestQuery(t *testing.T) {
+	now := time.Now()
+	s := NewStore(10, 0)
+
+	s.Add(record(1, http.MethodGet, "http://www.test.com/api/users", 200, "alice", now.Add(-time.Hour)))
+	s.Add(record(2, http.MethodPost, "http://www.test.com/api/orders", 201, "order", now.Add(-time.Minute)))
+	s.Add(record(3, http.MethodGet, "http://other.com/", 404, "not found", now))
+
+	test := func(t *testing.T, f Filter, expIDs ...uint64) {
+		recs := s.Query(f, -1)
+
+		if len(recs) != len(expIDs) {
+			t.Fatalf("expected [%v] exchanges, got [%v]", len(expIDs), len(recs))
+		}
+
+		for i, rec := range recs {
+			if rec.ID != expIDs[i] {
+				t.Fatalf("expected exchange [%v] at index [%v], got [%v]", expIDs[i], i, rec.ID)
+			}
+		}
+	}
+
+	t.Run("All", func(t *testing.T) { test(t, Filter{}, 1, 2, 3) })
+	t.Run("URL", func(t *testing.T) { test(t, Filter{URL: "test.com/api/u"}, 1) })
+	t.Run("Host", func(t *testing.T) { test(t, Filter{Host: "TEST.com"}, 1, 2) })
+	t.Run("Path", func(t *testing.T) { test(t, Filter{Path: "/orders"}, 2) })
+	t.Run("Status", func(t *testing.T) { test(t, Filter{Status: "2xx"}, 1, 2) })
+	t.Run("Method", func(t *testing.T) { test(t, Filter{Method: "post"}, 2) })
+	t.Run("Time", func(t *testing.T) { test(t, Filter{From: now.Add(-2 * time.Minute), To: now.Add(-time.Second)}, 2) })
+	t.Run("Body", func(t *testing.T) { test(t, Filter{Body: "found"}, 3) })
+	t.Run("Combined", func(t *testing.T) { test(t, Filter{Host: "test.com", Method: "get"}, 1) })
+
+	t.Run("Export", func(t *testing.T) {
+		b := bytes.Buffer{}
+
+		if err := s.Export(&b, "jsonl", Filter{Host: "test.com"}, -1, true, -1); err != nil {
+			t.Fatalf("expected no error exporting exchanges, got [%v]", err)
+		}
+
+		if n := strings.Count(b.String(), "\n"); n != 2 {
+			t.Fatalf("expected [2] exported json lines, got [%v]", n)
+		}
+
+		if err := s.Export(&b, "unknown", Filter{}, -1, true, -1); err == nil {
+			t.Fatalf("expected error exporting exchanges in an unknown format")
+		}
+	})
+}
+
+func TestSubscribe(t *testing.T) {
+	s := NewStore(10, 0)
+	c, unsubscribe := s.Subscribe(1)
+
+	s.Add(&intercept.Record{ID: 1, Request: &intercept.ProxyRequest{}})
+	s.Add(&intercept.Record{ID: 2, Request: &intercept.ProxyRequest{}})
+
+	if rec := <-c; rec.ID != 1 {
+		t.Fatalf("expected subscriber to receive exchange [1], got [%v]", rec.ID)
+	}
+
+	unsubscribe()
+	unsubscribe()
+
+	if _, ok := <-c; ok {
+		t.Fatalf("expected subscription channel to be closed and exchanges added while it was full to be skipped")
+	}
+}
@@ -0,0 +1,119 @@
+package capture
+
+import (
+	"bytes"
+	"comradequinn/hflow/proxy/intercept"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Filter describes the conditions a captured exchange must meet to be returned from a query. Unset conditions match all
+// exchanges
+type Filter struct {
+	// URL is text that the request url must contain, as per intercept.MatchRequestURL
+	URL string
+	// Host is text that the request host must contain, compared case insensitively
+	Host string
+	// Path is text that the request path must contain
+	Path string
+	// Status is the response status code, where an x matches any digit, such that 404 and 4xx are both valid
+	Status string
+	// Method is matched, case insensitively, against the request method
+	Method string
+	// From and To bound the time at which the exchange started
+	From, To time.Time
+	// Body is text that either the request or response body must contain
+	Body string
+}
+
+// Match returns true if rec meets the conditions described by f
+func (f Filter) Match(rec *intercept.Record) bool {
+	rq, rs := rec.Request, rec.Response
+
+	if rq == nil {
+		return false
+	}
+
+	if f.URL != "" && !strings.Contains(rq.URL.String(), f.URL) {
+		return false
+	}
+
+	if f.Host != "" && !strings.Contains(strings.ToLower(rq.URL.Host), strings.ToLower(f.Host)) {
+		return false
+	}
+
+	if f.Path != "" && !strings.Contains(rq.URL.Path, f.Path) {
+		return false
+	}
+
+	if f.Method != "" && !strings.EqualFold(f.Method, rq.Method) {
+		return false
+	}
+
+	if !f.From.IsZero() && rec.Start.Before(f.From) {
+		return false
+	}
+
+	if !f.To.IsZero() && rec.Start.After(f.To) {
+		return false
+	}
+
+	if f.Status != "" && (rs == nil || !matchStatus(f.Status, rs.StatusCode)) {
+		return false
+	}
+
+	if f.Body != "" && !bytes.Contains(rq.Body, []byte(f.Body)) && (rs == nil || !bytes.Contains(rs.Body, []byte(f.Body))) {
+		return false
+	}
+
+	return true
+}
+
+func matchStatus(pattern string, code int) bool {
+	s := strconv.Itoa(code)
+
+	if len(pattern) != len(s) {
+		return false
+	}
+
+	for i := range pattern {
+		if p := pattern[i]; p != s[i] && p != 'x' && p != 'X' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Size returns the approximate number of bytes held by rec, being the size of its urls, headers, bodies and frame payloads
+func Size(rec *intercept.Record) int64 {
+	size := func(h http.Header, b []byte) int64 {
+		n := int64(len(b))
+
+		for k, vs := range h {
+			for _, v := range vs {
+				n += int64(len(k) + len(v))
+			}
+		}
+
+		return n
+	}
+
+	var n int64
+
+	if rq := rec.Request; rq != nil {
+		n += int64(len(rq.URL.String())) + size(rq.Header, rq.Body)
+	}
+
+	if rs := rec.Response; rs != nil {
+		n += size(rs.Header, rs.Body) + size(rs.Trailer, nil)
+	}
+
+	for _, f := range rec.Frames {
+		n += int64(len(f.Payload))
+	}
+
+	return n
+}
@@ -2,4 +2,7 @@ module comradequinn/hflow
 
 go 1.16
 
-require github.com/andybalholm/brotli v1.0.1
+require (
+	github.com/andybalholm/brotli v1.0.1
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -3,6 +3,7 @@ package log
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strconv"
@@ -22,6 +23,11 @@ func SetVerbosity(v int) {
 	verbosity = v
 }
 
+// SetOutput specifies the io.Writer that logs are written to, which defaults to stderr
+func SetOutput(w io.Writer) {
+	log.SetOutput(w)
+}
+
 // Printf writes s to the log formatted with args if the configured verbosity is <= to v
 func Printf(v int, s string, args ...interface{}) {
 	if v <= verbosity {
@@ -1,66 +1,159 @@
 package proxy
 
 import (
+	"bufio"
 	"comradequinn/hflow/log"
 	"comradequinn/hflow/proxy/intercept"
 	"comradequinn/hflow/proxy/internal/copy"
+	"comradequinn/hflow/proxy/internal/websocket"
+	"net"
 	"net/http"
 )
 
+// Handler is a http.HandlerFunc that acts as both a HTTP and HTTPS Proxy, tunnelling CONNECT requests as per HTTPSHandler
+// and proxying all other requests as per HTTPHandler, so that both can be proxied over a single port
+func Handler() http.HandlerFunc {
+	httpHandler, httpsHandler := HTTPHandler(), HTTPSHandler()
+
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodConnect {
+			httpsHandler(rw, r)
+			return
+		}
+
+		httpHandler(rw, r)
+	}
+}
+
 // HTTPHandler is is a http.HandlerFunc that acts as HTTP Proxy
 func HTTPHandler() http.HandlerFunc {
-	client := http.Client{
-		CheckRedirect: func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse },
-	}
+	client := upstreamClient()
 
 	return func(rw http.ResponseWriter, r *http.Request) {
-		log.Printf(1, "<<< received proxy request for [%v] on host [%v]", r.URL.String(), r.Host)
+		x := intercept.NewExchange(r.RemoteAddr, 0)
+		x.Proto = r.Proto
 
-		var err error
+		log.Printf(1, "<<< received proxy request for [%v] on host [%v] as exchange [%v]", r.URL.String(), r.Host, x.ID)
 
-		r, err = intercept.Request(r, Intercepts())
+		proxyExchange(client, x, rw, r)
+	}
+}
 
-		if err != nil {
-			rw.WriteHeader(http.StatusServiceUnavailable)
-			log.Printf(0, "error intercepting request for [%v] on host [%v]: [%v]", r.URL.String(), r.Host, err)
-			return
-		}
+// proxyExchange proxies r, received from the hflow client as exchange x, to the upstream host using client, applying any
+// intercepts, and writes the response to rw
+func proxyExchange(client *http.Client, x intercept.Exchange, rw http.ResponseWriter, r *http.Request) {
+	ws := websocket.IsUpgrade(r)
+
+	if ws {
+		r.Header.Del("Sec-WebSocket-Extensions")
+	}
+
+	removeHopHeaders(r.Header, ws)
+
+	ir, rs, err := intercept.Request(x, r, Intercepts())
+
+	if err == intercept.ErrDrop {
+		log.Printf(2, "dropped request for [%v] on host [%v]", r.URL.String(), r.Host)
+		panic(http.ErrAbortHandler)
+	}
+
+	if err != nil {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		log.Printf(0, "error intercepting request for [%v] on host [%v]: [%v]", r.URL.String(), r.Host, err)
+		return
+	}
 
+	r = ir
+
+	var (
+		upstream net.Conn
+		ur       *bufio.Reader
+	)
+
+	if rs == nil {
 		log.Printf(2, ">>> requesting [%v] from host [%v]", r.URL.String(), r.Host)
 
-		rs, err := client.Do(r)
+		if ws {
+			upstream, ur, rs, err = dialWebSocket(r)
+		} else {
+			rs, err = client.Do(r)
+		}
 
 		if err != nil {
-			rw.WriteHeader(http.StatusServiceUnavailable)
-			log.Printf(0, "error proxying request for [%v] on host [%v]: [%v]", r.URL.String(), r.Host, err)
+			rw.WriteHeader(upstreamError(r, err))
 			return
 		}
 
 		log.Printf(2, "<<< received [%v] in response to [%v] on [%v]", rs.StatusCode, r.URL.String(), r.Host)
 
-		rs, err = intercept.Response(r, rs, Intercepts())
+		removeHopHeaders(rs.Header, rs.StatusCode == http.StatusSwitchingProtocols)
+	}
 
-		if err != nil {
-			rw.WriteHeader(http.StatusServiceUnavailable)
-			log.Printf(0, "error intercepting response to [%v] on host [%v]: [%v]", r.URL.String(), r.Host, err)
+	rs, err = intercept.Response(x, r, rs, Intercepts())
+
+	if err == intercept.ErrDrop {
+		log.Printf(2, "dropped response to [%v] on host [%v]", r.URL.String(), r.Host)
+		panic(http.ErrAbortHandler)
+	}
+
+	if err != nil {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		log.Printf(0, "error intercepting response to [%v] on host [%v]: [%v]", r.URL.String(), r.Host, err)
+		return
+	}
+
+	defer rs.Body.Close()
+
+	if upstream != nil {
+		defer upstream.Close()
+	}
+
+	if upstream != nil && rs.StatusCode == http.StatusSwitchingProtocols {
+		hj, ok := rw.(http.Hijacker)
+
+		if !ok {
+			log.Printf(0, "http websocket request for [%v] not hijackable", r.URL.String())
 			return
 		}
 
-		copy.Header(rs.Header, rw.Header())
-
-		b, err := copy.CloserToBytes(&rs.Body)
+		conn, brw, err := hj.Hijack()
 
 		if err != nil {
-			rw.WriteHeader(http.StatusServiceUnavailable)
-			log.Printf(0, "error reading response body from [%v]: [%v]", r.URL.String(), err)
+			log.Printf(0, "error hijacking http websocket request for [%v]: [%v]", r.URL.String(), err)
 			return
 		}
 
-		if _, err = rw.Write(b); err != nil {
-			log.Printf(0, "error writing response body from [%v] on [%v] to hflow client: [%v]", r.URL.String(), r.Host, err)
+		defer conn.Close()
+
+		if err = rs.Write(conn); err != nil {
+			log.Printf(0, "error writing websocket upgrade response for [%v] to hflow client: [%v]", r.URL.String(), err)
 			return
 		}
 
-		log.Printf(2, ">>> wrote proxy response for [%v]", r.URL.String())
+		spliceWebSocket(x, r, conn, brw.Reader, upstream, ur)
+
+		return
+	}
+
+	copy.Header(rs.Header, rw.Header())
+
+	rw.WriteHeader(rs.StatusCode)
+
+	if _, err = copy.Stream(rw, rs.Body); err != nil {
+		if kind, ok := timedOut(err); ok {
+			log.Printf(0, "%v timeout writing response body from [%v] on [%v] to hflow client: [%v]", kind, r.URL.String(), r.Host, err)
+			return
+		}
+
+		log.Printf(0, "error writing response body from [%v] on [%v] to hflow client: [%v]", r.URL.String(), r.Host, err)
+		return
+	}
+
+	for k, vs := range rs.Trailer {
+		for _, v := range vs {
+			rw.Header().Add(http.TrailerPrefix+k, v)
+		}
 	}
+
+	log.Printf(2, ">>> wrote proxy response for [%v]", r.URL.String())
 }
@@ -1,24 +1,18 @@
 package proxy
 
 import (
-	"bufio"
-	"comradequinn/hflow/cert"
 	"comradequinn/hflow/log"
-	"comradequinn/hflow/proxy/intercept"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
-	"time"
+	"sync"
 )
 
 // HTTPSHandler is is a http.HandlerFunc that acts as HTTPS Proxy
 func HTTPSHandler() http.HandlerFunc {
-	client := http.Client{
-		CheckRedirect: func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse },
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
-	}
+	client := upstreamClient()
 
 	return func(connectRs http.ResponseWriter, connectRq *http.Request) {
 		if connectRq.Method != http.MethodConnect {
@@ -34,7 +28,7 @@ func HTTPSHandler() http.HandlerFunc {
 			return
 		}
 
-		tcpConn, _, err := hj.Hijack()
+		tcpConn, brw, err := hj.Hijack()
 
 		if err != nil {
 			log.Printf(0, "error hijacking http connect request for [%v]. [%v]", connectRq.Host, err)
@@ -43,84 +37,57 @@ func HTTPSHandler() http.HandlerFunc {
 
 		fmt.Fprintf(tcpConn, "HTTP/1.1 200 Connection Established\r\n\r\n")
 
-		tlsConn := tls.Server(tcpConn, &tls.Config{GetCertificate: cert.Get})
-
-		log.Printf(3, "tunneling to [%v] on behalf of [%v]", connectRq.Host, tcpConn.RemoteAddr())
-
-		go func() {
-			defer func() {
-				tlsConn.Close()
-				log.Printf(3, "closed tunnel to [%v] on behalf of [%v]", connectRq.Host, tcpConn.RemoteAddr())
-
-				if err := recover(); err != nil {
-					log.Printf(0, "panic while tunneling from remote client [%v] to remote host [%v]. [%+v]", connectRq.RemoteAddr, connectRq.Host, err)
-				}
-			}()
-
-			if err := tlsConn.Handshake(); err != nil {
-				log.Printf(0, "tls handshake with remote client [%v] failed. [%v]", connectRq.RemoteAddr, err)
-				return
-			}
-
-			br, eof := bufio.NewReader(tlsConn), func(br *bufio.Reader) bool {
-				log.Printf(3, "waiting to receive from remote client [%v]", connectRq.RemoteAddr)
-
-				if err := tcpConn.SetReadDeadline(time.Now().Add(time.Second * 60)); err != nil {
-					log.Printf(0, "error setting read deadline on connection with remote client [%v]. [%v]", connectRq.RemoteAddr, err)
-					return true
-				}
+		go serveTunnel(client, tcpConn, brw.Reader, connectRq.Host, connectRq.RemoteAddr)
+	}
+}
 
-				if _, err := br.Peek(1); err != nil {
-					log.Printf(3, "unable to read from connection with remote client [%v]. [%v]", connectRq.RemoteAddr, err)
-					return true
-				}
+// serveHTTP2 serves the HTTP/2 connection conn, on which h2 has been negotiated, passing each request received on it to h
+// until it closes
+func serveHTTP2(conn *tls.Conn, h http.Handler) {
+	l := &connListener{conn: conn, closed: make(chan struct{})}
 
-				log.Printf(3, "receiving from remote client [%v]", connectRq.RemoteAddr)
+	idle := timeouts().ClientIdle
 
-				return false
+	srv := http.Server{
+		Handler:           h,
+		IdleTimeout:       idle,
+		ReadHeaderTimeout: idle,
+		ConnState: func(_ net.Conn, s http.ConnState) {
+			if s == http.StateClosed || s == http.StateHijacked {
+				l.Close()
 			}
+		},
+	}
 
-			for !eof(br) {
-				rq, err := http.ReadRequest(br)
-
-				if err != nil {
-					log.Printf(0, "error reading https request from remote client [%v]. [%v]", connectRq.RemoteAddr, err)
-					return
-				}
-
-				log.Printf(1, "<<< received proxy request for [%v] on host [%v]", rq.URL.String(), rq.Host)
-
-				rq.RequestURI, rq.URL.Scheme, rq.URL.Host = "", "https", connectRq.Host
-
-				rq, err = intercept.Request(rq, Intercepts())
-
-				if err != nil {
-					log.Printf(0, "error intercepting https request from remote client [%v]. [%v]", connectRq.RemoteAddr, err)
-					return
-				}
-
-				log.Printf(3, ">>> requesting [%v] from host [%v]", rq.URL.String(), rq.Host)
-
-				rs, err := client.Do(rq)
+	if err := srv.Serve(l); err != errListenerClosed {
+		log.Printf(0, "error serving http/2 connection with remote client [%v]. [%v]", conn.RemoteAddr(), err)
+	}
+}
 
-				if err != nil {
-					log.Printf(0, "error proxying request for [%v] on host [%v]: [%v]", rq.URL.String(), rq.Host, err)
-					return
-				}
+var errListenerClosed = errors.New("listener closed")
 
-				log.Printf(3, "<<< received [%v] in response to [%v] on [%v]", rs.StatusCode, rq.URL.String(), rq.Host)
+// connListener is a net.Listener that accepts conn once, then blocks until conn is closed
+type connListener struct {
+	conn     net.Conn
+	accepted bool
+	once     sync.Once
+	closed   chan struct{}
+}
 
-				rs, err = intercept.Response(rq, rs, Intercepts())
+func (l *connListener) Accept() (net.Conn, error) {
+	if !l.accepted {
+		l.accepted = true
+		return l.conn, nil
+	}
 
-				if err != nil {
-					log.Printf(0, "error intercepting response to [%v] on host [%v]: [%v]", rq.URL.String(), rq.Host, err)
-					return
-				}
+	<-l.closed
 
-				rs.Write(tlsConn)
+	return nil, errListenerClosed
+}
 
-				log.Printf(2, ">>> wrote proxy response for [%v] on [%v]", rq.URL.String(), rq.Host)
-			}
-		}()
-	}
+func (l *connListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
 }
+
+func (l *connListener) Addr() net.Addr { return l.conn.LocalAddr() }
@@ -45,6 +45,65 @@ func SetIntercept(i *intercept.Intercept) int {
 	return iid
 }
 
+// replaceHeadroom is the number of ids reserved beyond those needed when ReplaceIntercepts first adds a set of intercepts,
+// so that the set can grow on replacement without being applied after intercepts set since
+const replaceHeadroom = 64
+
+// ReplaceIntercepts atomically removes the intercepts identified by ids and adds is in their place, so that no exchange
+// is subject to a partial set of either. An added intercept with the same label as a removed one is enabled only where
+// the removed one was, so that intercepts disabled by EnableIntercept remain so. The added intercepts take the ids
+// passed, in order, so that they are applied in the same position relative to other intercepts as those they replace.
+// The ids of the added intercepts are returned in the order of is, followed by those reserved for the set but unused,
+// and should be passed to the next call to ReplaceIntercepts for the set
+func ReplaceIntercepts(ids []int, is []*intercept.Intercept) []int {
+	for _, i := range is {
+		if i == nil {
+			log.Panicf(0, "cannot set nil as an intercept")
+		}
+	}
+
+	nids, removed := append([]int{}, ids...), 0
+
+	lockIntercepts(func(intercepts map[int]*intercept.Intercept, id *int) {
+		enabled := map[string]bool{}
+
+		for _, iid := range ids {
+			if i, ok := intercepts[iid]; ok {
+				enabled[i.Label()] = i.Enabled()
+				removed++
+			}
+
+			delete(intercepts, iid)
+		}
+
+		reserve := len(is) - len(nids)
+
+		if ids == nil {
+			reserve += replaceHeadroom
+		} else if reserve > 0 && len(nids) > 0 && nids[len(nids)-1] != *id {
+			log.Printf(0, "[%v] replacement intercepts exceed those reserved and will be applied after intercepts set since", reserve)
+		}
+
+		for ; reserve > 0; reserve-- {
+			*id++
+			nids = append(nids, *id)
+		}
+
+		for n, i := range is {
+			if e, ok := enabled[i.Label()]; ok && e != i.Enabled() {
+				c := *i
+				i = c.WithEnabled(e)
+			}
+
+			intercepts[nids[n]] = i
+		}
+	}, false)
+
+	log.Printf(1, "replaced [%v] intercepts with [%v] intercepts", removed, len(is))
+
+	return nids
+}
+
 // UnsetIntercept causes the specified intercept to cease being applied to http traffic
 func UnsetIntercept(id int) {
 	lockIntercepts(func(intercepts map[int]*intercept.Intercept, _ *int) { delete(intercepts, id) }, false)
@@ -52,7 +111,28 @@ func UnsetIntercept(id int) {
 	log.Printf(1, "removed intercept labelled [%v]", id)
 }
 
-// Intercepts returns all configured intercepts
+// EnableIntercept sets whether the specified intercept is applied to http traffic, returning false if it does not exist
+func EnableIntercept(id int, enabled bool) bool {
+	ok := false
+
+	lockIntercepts(func(intercepts map[int]*intercept.Intercept, _ *int) {
+		var i *intercept.Intercept
+
+		if i, ok = intercepts[id]; ok {
+			c := *i
+			intercepts[id] = c.WithEnabled(enabled)
+		}
+	}, false)
+
+	if ok {
+		log.Printf(1, "set enabled to [%v] on intercept [%v]", enabled, id)
+	}
+
+	return ok
+}
+
+// Intercepts returns all configured intercepts keyed by their id. Ids increase with each call to SetIntercept and so
+// reflect the order in which intercepts were registered, which is the order they are applied within a phase and priority
 func Intercepts() map[int]*intercept.Intercept {
 	copy := map[int]*intercept.Intercept{}
 
@@ -0,0 +1,40 @@
+package intercept
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Curl returns a curl command line that reproduces r. Headers are written in name order and Content-Length is omitted
+// as curl derives it from the body
+func Curl(r *ProxyRequest) string {
+	quote := func(s string) string { return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'" }
+
+	sb := strings.Builder{}
+	sb.WriteString("curl -X " + r.Method + " " + quote(r.URL.String()))
+
+	ks := make([]string, 0, len(r.Header))
+
+	for k := range r.Header {
+		ks = append(ks, k)
+	}
+
+	sort.Strings(ks)
+
+	for _, k := range ks {
+		if http.CanonicalHeaderKey(k) == "Content-Length" {
+			continue
+		}
+
+		for _, v := range r.Header[k] {
+			sb.WriteString(" -H " + quote(k+": "+v))
+		}
+	}
+
+	if len(r.Body) > 0 {
+		sb.WriteString(" --data-binary " + quote(string(r.Body)))
+	}
+
+	return sb.String()
+}
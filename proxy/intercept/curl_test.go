@@ -0,0 +1,26 @@
+package intercept
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCurl(t *testing.T) {
+	rq, _ := http.NewRequest(http.MethodPost, "http://www.test.com/path?q=1", strings.NewReader("it's"))
+	rq.Header.Set("B-Hk", "b-hv")
+	rq.Header.Set("A-Hk", "a-hv")
+	rq.Header.Set("Content-Length", "4")
+
+	r, err := newProxyRequest(NewExchange("127.0.0.1:50000", 0), rq)
+
+	if err != nil {
+		t.Fatalf("expected no error creating proxy request, got [%v]", err)
+	}
+
+	expected := `curl -X POST 'http://www.test.com/path?q=1' -H 'A-Hk: a-hv' -H 'B-Hk: b-hv' --data-binary 'it'\''s'`
+
+	if got := Curl(r); got != expected {
+		t.Fatalf("expected curl command [%v], got [%v]", expected, got)
+	}
+}
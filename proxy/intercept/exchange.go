@@ -0,0 +1,31 @@
+package intercept
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+var exchangeID uint64
+
+// Exchange describes a single request and response pair as it passes through the proxy. It is carried
+// on both the ProxyRequest and ProxyResponse so that each can be correlated with the other
+type Exchange struct {
+	// ID uniquely identifies the exchange for the lifetime of the process
+	ID uint64
+	// Start is the time at which the request was received from the client
+	Start time.Time
+	// End is the time at which the response was received, it is zero until then
+	End time.Time
+	// ClientAddr is the network address of the client that made the request
+	ClientAddr string
+	// TunnelID identifies the CONNECT tunnel the request was received over, it is 0 where the request was not tunnelled
+	TunnelID uint64
+	// Proto is the protocol the client made the request with, such as HTTP/1.1 or, where negotiated over a tunnel, HTTP/2.0
+	Proto string
+}
+
+// NewExchange returns an Exchange, with a unique ID, that started now for a request from clientAddr
+// received over the tunnel identified by tunnelID
+func NewExchange(clientAddr string, tunnelID uint64) Exchange {
+	return Exchange{ID: atomic.AddUint64(&exchangeID, 1), Start: time.Now(), ClientAddr: clientAddr, TunnelID: tunnelID}
+}
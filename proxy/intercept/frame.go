@@ -0,0 +1,144 @@
+package intercept
+
+import (
+	"comradequinn/hflow/log"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// FrameSent describes a frame sent by the client to the upstream host
+	FrameSent = "sent"
+	// FrameReceived describes a frame received by the client from the upstream host
+	FrameReceived = "received"
+)
+
+// Frame represents a WebSocket frame being currently processed by proxy, on a connection upgraded by the request of
+// its Exchange
+type Frame struct {
+	Exchange
+	// Direction is either FrameSent or FrameReceived
+	Direction string
+	// Time is the time at which the frame was received by the proxy
+	Time time.Time
+	// Opcode is the WebSocket opcode of the frame, as described by Type
+	Opcode int
+	// Fin is true where the frame is the final frame of a message
+	Fin bool
+	// Payload is the unmasked payload of the frame. It is nil where the payload exceeded the buffer limit, in which case
+	// the frame is forwarded unchanged
+	Payload []byte
+	// Size is the size of the payload as received
+	Size int64
+}
+
+// FrameFunc is applied to WebSocket frames. Changes to the Payload of the *Frame are forwarded. Returning ErrDrop causes
+// the frame not to be forwarded. Sent and received frames are processed concurrently, so a FrameFunc may be called from
+// more than one goroutine
+type FrameFunc func(*Frame) error
+
+// Type returns the name of the opcode of f
+func (f *Frame) Type() string {
+	switch f.Opcode {
+	case 0x0:
+		return "continuation"
+	case 0x1:
+		return "text"
+	case 0x2:
+		return "binary"
+	case 0x8:
+		return "close"
+	case 0x9:
+		return "ping"
+	case 0xA:
+		return "pong"
+	default:
+		return fmt.Sprintf("opcode(%v)", f.Opcode)
+	}
+}
+
+// Clone returns a copy of f that shares no payload with it
+func (f *Frame) Clone() *Frame {
+	c := *f
+
+	if f.Payload != nil {
+		c.Payload = append([]byte(nil), f.Payload...)
+	}
+
+	return &c
+}
+
+// frameHeader returns a http.Header with a content-type describing the payload of f, so that it can be captured as per
+// a body
+func frameHeader(f *Frame) http.Header {
+	if f.Opcode == 0x1 {
+		return http.Header{"Content-Type": []string{"text/plain"}}
+	}
+
+	return http.Header{"Content-Type": []string{"application/octet-stream"}}
+}
+
+// WithFrames sets f to be applied to the WebSocket frames of connections upgraded by requests that the Intercept matches and
+// returns the Intercept. Where closed is not nil, it is called once each upgraded connection closes, whether or not the
+// Intercept matched the request that upgraded it
+func (i *Intercept) WithFrames(f FrameFunc, closed func(Exchange)) *Intercept {
+	i.frame, i.frameClosed = f, closed
+	return i
+}
+
+// Frames returns a func that applies the frame funcs of intercepts that match hr, the request that upgraded the connection
+// of exchange x, to each frame of that connection, and a func to be called once the connection closes. Intercepts are
+// applied in phase, then priority, then registration order until one returns ErrStopChain. Where an intercept returns
+// ErrDrop, it is returned unwrapped and the frame should not be forwarded
+func Frames(x Exchange, hr *http.Request, intercepts map[int]*Intercept) (func(*Frame) error, func()) {
+	r, all, matched := requestHead(x, hr), []*Intercept{}, []*Intercept{}
+
+	for _, intercept := range ordered(intercepts) {
+		if intercept.frame == nil {
+			continue
+		}
+
+		all = append(all, intercept)
+
+		ok, err := intercept.matchRq(r)
+
+		if err != nil {
+			log.Printf(0, "error matching intercept [%v] to websocket request for [%v]: [%v]", intercept.label, r.URL.String(), err)
+			continue
+		}
+
+		if ok {
+			matched = append(matched, intercept)
+		}
+	}
+
+	apply := func(f *Frame) error {
+		for _, intercept := range matched {
+			log.Printf(3, "applying intercept labelled [%v] to %v %v frame for [%v]", intercept.label, f.Direction, f.Type(), r.URL.String())
+
+			switch err := intercept.frame(f); err {
+			case nil:
+			case ErrStopChain:
+				return nil
+			case ErrDrop:
+				log.Printf(2, "intercept labelled [%v] dropped %v %v frame for [%v]", intercept.label, f.Direction, f.Type(), r.URL.String())
+				return err
+			default:
+				return fmt.Errorf("error applying intercept [%v] to %v %v frame for [%v]: [%v]", intercept.label, f.Direction, f.Type(), r.URL.String(), err)
+			}
+		}
+
+		return nil
+	}
+
+	closed := func() {
+		for _, intercept := range all {
+			if intercept.frameClosed != nil {
+				intercept.frameClosed(x)
+			}
+		}
+	}
+
+	return apply, closed
+}
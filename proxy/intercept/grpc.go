@@ -0,0 +1,100 @@
+package intercept
+
+import (
+	"bytes"
+	"comradequinn/hflow/proxy/internal/codec"
+	"comradequinn/hflow/proxy/internal/protobuf"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var descriptors = protobuf.NewDescriptors()
+
+// LoadDescriptorSet loads the protobuf descriptor set at path, as written by protoc --descriptor_set_out, so that the
+// messages of gRPC methods it describes are decoded with their field names when captured. Messages of other methods are
+// decoded by field number
+func LoadDescriptorSet(path string) error {
+	b, err := os.ReadFile(path)
+
+	if err != nil {
+		return fmt.Errorf("unable to read descriptor set [%v]: [%v]", path, err)
+	}
+
+	if err = descriptors.Add(b); err != nil {
+		return fmt.Errorf("unable to load descriptor set [%v]: [%v]", path, err)
+	}
+
+	return nil
+}
+
+// isGRPC returns true where h specifies a content-type of a gRPC body encoded as protobuf
+func isGRPC(h http.Header) bool {
+	ct := strings.Split(h.Get("Content-Type"), ";")[0]
+
+	return strings.HasPrefix(ct, "application/grpc") && !strings.Contains(ct, "json") && !strings.Contains(ct, "text")
+}
+
+// grpcBody returns body b, described by h, of a request or response for path as it should be captured, and whether it is
+// text. gRPC bodies are returned as text, with each message decoded to JSON, other bodies are returned unchanged
+func grpcBody(path string, h http.Header, b []byte, response bool) ([]byte, bool) {
+	if !isGRPC(h) {
+		return b, isText(h)
+	}
+
+	in, out, _ := descriptors.Method(path)
+	name, buf := in, bytes.Buffer{}
+
+	if response {
+		name = out
+	}
+
+	for len(b) > 0 {
+		if len(b) < 5 || int64(binary.BigEndian.Uint32(b[1:5])) > int64(len(b)-5) {
+			buf.WriteString(fmt.Sprintf("[incomplete grpc message of %v bytes]\n", len(b)))
+			break
+		}
+
+		flags, m := b[0], b[5:5+binary.BigEndian.Uint32(b[1:5])]
+		b = b[5+len(m):]
+
+		if flags&0x80 != 0 {
+			buf.WriteString(fmt.Sprintf("[grpc-web trailers]\n%v\n", strings.TrimSpace(string(m))))
+			continue
+		}
+
+		if flags&0x1 != 0 {
+			enc := h.Get("Grpc-Encoding")
+
+			if !codec.Supported(enc) {
+				buf.WriteString(fmt.Sprintf("[grpc message of %v bytes compressed with unsupported encoding [%v]]\n", len(m), enc))
+				continue
+			}
+
+			d, err := codec.Decode(enc, m)
+
+			if err != nil {
+				buf.WriteString(fmt.Sprintf("[grpc message of %v bytes that could not be decompressed: %v]\n", len(m), err))
+				continue
+			}
+
+			m = d
+		}
+
+		o, err := descriptors.Decode(name, m)
+
+		if err != nil {
+			buf.WriteString(fmt.Sprintf("[grpc message of %v bytes that could not be decoded: %v]\n", len(m), err))
+			continue
+		}
+
+		j, _ := json.MarshalIndent(o, "", "  ")
+		buf.Write(j)
+		buf.WriteString("\n")
+	}
+
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), true
+}
@@ -0,0 +1,40 @@
+package intercept
+
+import (
+	"comradequinn/hflow/proxy/internal/codec"
+	"encoding/binary"
+	"net/http"
+	"testing"
+)
+
+func TestGRPCBody(t *testing.T) {
+	frame := func(compressed bool, m []byte) []byte {
+		b := make([]byte, 5)
+		binary.BigEndian.PutUint32(b[1:], uint32(len(m)))
+
+		if compressed {
+			b[0] = 1
+		}
+
+		return append(b, m...)
+	}
+
+	test := func(t *testing.T, h http.Header, b []byte, expected string, expectedText bool) {
+		got, text := grpcBody("/test.Service/Call", h, b, true)
+
+		if string(got) != expected || text != expectedText {
+			t.Fatalf("expected body [%v] with text [%v], got [%v] with text [%v]", expected, expectedText, string(got), text)
+		}
+	}
+
+	grpc := http.Header{"Content-Type": []string{"application/grpc"}, "Grpc-Encoding": []string{"gzip"}}
+	msg := []byte{0x08, 0x96, 0x01}
+	gz, _ := codec.Encode("gzip", msg)
+
+	t.Run("Message", func(t *testing.T) { test(t, grpc, frame(false, msg), "{\n  \"1\": 150\n}", true) })
+	t.Run("Messages", func(t *testing.T) {
+		test(t, grpc, append(frame(false, msg), frame(true, gz)...), "{\n  \"1\": 150\n}\n{\n  \"1\": 150\n}", true)
+	})
+	t.Run("Incomplete", func(t *testing.T) { test(t, grpc, frame(false, msg)[:4], "[incomplete grpc message of 4 bytes]", true) })
+	t.Run("NotGRPC", func(t *testing.T) { test(t, http.Header{"Content-Type": []string{"image/png"}}, msg, string(msg), false) })
+}
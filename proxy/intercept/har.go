@@ -0,0 +1,282 @@
+package intercept
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	harLog struct {
+		Log struct {
+			Version string     `json:"version"`
+			Creator harCreator `json:"creator"`
+			Entries []harEntry `json:"entries"`
+		} `json:"log"`
+	}
+
+	harCreator struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+
+	harEntry struct {
+		StartedDateTime   string                `json:"startedDateTime"`
+		Time              float64               `json:"time"`
+		Request           harRequest            `json:"request"`
+		Response          harResponse           `json:"response"`
+		Cache             struct{}              `json:"cache"`
+		Timings           harTimings            `json:"timings"`
+		WebSocketMessages []harWebSocketMessage `json:"_webSocketMessages,omitempty"`
+	}
+
+	// harWebSocketMessage describes a websocket frame in the form used by browser devtools, which is not part of HAR 1.2
+	harWebSocketMessage struct {
+		Type   string  `json:"type"`
+		Time   float64 `json:"time"`
+		Opcode int     `json:"opcode"`
+		Data   string  `json:"data"`
+	}
+
+	harRequest struct {
+		Method      string         `json:"method"`
+		URL         string         `json:"url"`
+		HTTPVersion string         `json:"httpVersion"`
+		Cookies     []harNameValue `json:"cookies"`
+		Headers     []harNameValue `json:"headers"`
+		QueryString []harNameValue `json:"queryString"`
+		PostData    *harPostData   `json:"postData,omitempty"`
+		HeadersSize int            `json:"headersSize"`
+		BodySize    int            `json:"bodySize"`
+	}
+
+	harResponse struct {
+		Status      int            `json:"status"`
+		StatusText  string         `json:"statusText"`
+		HTTPVersion string         `json:"httpVersion"`
+		Cookies     []harNameValue `json:"cookies"`
+		Headers     []harNameValue `json:"headers"`
+		Content     harContent     `json:"content"`
+		RedirectURL string         `json:"redirectURL"`
+		HeadersSize int            `json:"headersSize"`
+		BodySize    int            `json:"bodySize"`
+		Trailers    []harNameValue `json:"_trailers,omitempty"`
+	}
+
+	harNameValue struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+
+	harPostData struct {
+		MimeType string         `json:"mimeType"`
+		Params   []harNameValue `json:"params"`
+		Text     string         `json:"text"`
+	}
+
+	harContent struct {
+		Size        int    `json:"size"`
+		Compression int    `json:"compression,omitempty"`
+		MimeType    string `json:"mimeType"`
+		Text        string `json:"text,omitempty"`
+		Encoding    string `json:"encoding,omitempty"`
+	}
+
+	harTimings struct {
+		Send    float64 `json:"send"`
+		Wait    float64 `json:"wait"`
+		Receive float64 `json:"receive"`
+	}
+)
+
+// HAR collects the exchanges matched by its intercept so they can be written as a HAR 1.2 log
+type HAR struct {
+	mx        sync.Mutex
+	entries   []harEntry
+	intercept *Intercept
+}
+
+// NewHAR returns a *HAR which records
+// * request traffic where the mrq matches the request
+// * response traffic where mrs matches the response
+//
+// Unless binary is set to true, only text-based mime-type bodies are recorded
+// If limit is greater than or equal to 0, then recorded text bodies are capped at that number of bytes
+func NewHAR(label string, mrq MatchRequestFunc, mrs MatchResponseFunc, binary bool, limit int) *HAR {
+	h := &HAR{}
+
+	h.intercept = Recorder(label, mrq, mrs, func(rec *Record) {
+		e := harEntryFromRecord(rec, binary, limit)
+
+		h.mx.Lock()
+		h.entries = append(h.entries, e)
+		h.mx.Unlock()
+	})
+
+	return h
+}
+
+// Intercept returns the *Intercept that records exchanges to the HAR
+func (h *HAR) Intercept() *Intercept {
+	return h.intercept
+}
+
+// WriteTo writes all exchanges recorded so far to w as a HAR 1.2 log
+func (h *HAR) WriteTo(w io.Writer) (int64, error) {
+	h.mx.Lock()
+	entries := append([]harEntry{}, h.entries...)
+	h.mx.Unlock()
+
+	return writeHARLog(w, entries)
+}
+
+// WriteHAR writes recs to w as a HAR 1.2 log, as per HAR
+func WriteHAR(w io.Writer, recs []*Record, binary bool, limit int) error {
+	entries := make([]harEntry, 0, len(recs))
+
+	for _, rec := range recs {
+		entries = append(entries, harEntryFromRecord(rec, binary, limit))
+	}
+
+	_, err := writeHARLog(w, entries)
+
+	return err
+}
+
+func writeHARLog(w io.Writer, entries []harEntry) (int64, error) {
+	l := harLog{}
+	l.Log.Version, l.Log.Creator, l.Log.Entries = "1.2", harCreator{Name: "hflow", Version: "1.0"}, entries
+
+	b, err := json.MarshalIndent(l, "", "  ")
+
+	if err != nil {
+		return 0, fmt.Errorf("unable to encode har log: [%v]", err)
+	}
+
+	n, err := w.Write(append(b, '\n'))
+
+	return int64(n), err
+}
+
+func harEntryFromRecord(rec *Record, binary bool, limit int) harEntry {
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	rq, rs, e := rec.Request, rec.Response, harEntry{}
+
+	e.StartedDateTime, e.Time = rec.Start.Format(time.RFC3339Nano), ms(rec.End.Sub(rec.Start))
+	e.Timings = harTimings{Send: 0, Wait: e.Time, Receive: 0}
+
+	e.Request = harRequest{
+		Method:      rq.Method,
+		URL:         rq.URL.String(),
+		HTTPVersion: rq.Proto,
+		Cookies:     harCookies((&http.Request{Header: rq.Header}).Cookies()),
+		Headers:     harHeaders(rq.Header),
+		QueryString: harValues(rq.URL.Query()),
+		HeadersSize: -1,
+		BodySize:    len(rq.Body),
+	}
+
+	if e.Request.HTTPVersion == "" {
+		e.Request.HTTPVersion = "HTTP/1.1"
+	}
+
+	if len(rq.Body) > 0 {
+		pd := harPostData{MimeType: rq.Header.Get("Content-Type"), Params: []harNameValue{}}
+		b, text := grpcBody(rq.URL.Path, rq.Header, rq.Body, false)
+		pd.Text, _ = harBody(b, text, binary, limit)
+
+		if strings.HasPrefix(pd.MimeType, "application/x-www-form-urlencoded") {
+			if form, err := url.ParseQuery(string(rq.Body)); err == nil {
+				pd.Params = harValues(form)
+			}
+		}
+
+		e.Request.PostData = &pd
+	}
+
+	e.Response = harResponse{
+		Status:      rs.StatusCode,
+		StatusText:  strings.TrimSpace(strings.TrimPrefix(rs.Status, strconv.Itoa(rs.StatusCode))),
+		HTTPVersion: rs.Proto,
+		Cookies:     harCookies((&http.Response{Header: rs.Header}).Cookies()),
+		Headers:     harHeaders(rs.Header),
+		RedirectURL: rs.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    rs.encodedSize,
+	}
+
+	e.Response.Content = harContent{Size: len(rs.Body), MimeType: rs.Header.Get("Content-Type")}
+	b, text := grpcBody(rq.URL.Path, rs.Header, rs.Body, true)
+	e.Response.Content.Text, e.Response.Content.Encoding = harBody(b, text, binary, limit)
+
+	if len(rs.Trailer) > 0 {
+		e.Response.Trailers = harHeaders(rs.Trailer)
+	}
+
+	if rs.encodedSize > 0 && rs.encodedSize != len(rs.Body) {
+		e.Response.Content.Compression = len(rs.Body) - rs.encodedSize
+	}
+
+	for _, f := range rec.Frames {
+		m := harWebSocketMessage{Type: "send", Time: float64(f.Time.UnixNano()) / float64(time.Second), Opcode: f.Opcode}
+
+		if f.Direction == FrameReceived {
+			m.Type = "receive"
+		}
+
+		m.Data, _ = harBody(f.Payload, isText(frameHeader(f)), binary, limit)
+		e.WebSocketMessages = append(e.WebSocketMessages, m)
+	}
+
+	return e
+}
+
+// harBody returns the text of b, as it should be written to the HAR, along with the encoding that was applied to it
+func harBody(b []byte, text, binary bool, limit int) (string, string) {
+	body, encoding, _ := captureBody(b, text, binary, limit)
+
+	if encoding == bodyOmitted {
+		return "", ""
+	}
+
+	return body, encoding
+}
+
+func harHeaders(h http.Header) []harNameValue {
+	return harValues(map[string][]string(h))
+}
+
+func harValues(m map[string][]string) []harNameValue {
+	nvs, ks := []harNameValue{}, make([]string, 0, len(m))
+
+	for k := range m {
+		ks = append(ks, k)
+	}
+
+	sort.Strings(ks)
+
+	for _, k := range ks {
+		for _, v := range m[k] {
+			nvs = append(nvs, harNameValue{Name: k, Value: v})
+		}
+	}
+
+	return nvs
+}
+
+func harCookies(cs []*http.Cookie) []harNameValue {
+	nvs := []harNameValue{}
+
+	for _, c := range cs {
+		nvs = append(nvs, harNameValue{Name: c.Name, Value: c.Value})
+	}
+
+	return nvs
+}
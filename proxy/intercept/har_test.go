@@ -0,0 +1,78 @@
+package intercept
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestHAR(t *testing.T) {
+	rqHdrK, rqHdrV, rqbody, rsHdrK, rsHdrV, rsbody := "Rq-Hk", "Rq-Hv", "rq-body-data", "Rs-Hk", "Rs-Hv", "rs-body-data"
+
+	rq, _ := http.NewRequest(http.MethodPost, "http://www.test.com/echo/?data=some-qs-data", strings.NewReader(rqbody))
+	rq.Header.Set(rqHdrK, rqHdrV)
+	rq.Header.Set("Cookie", "rq-cookie=rq-cookie-value")
+
+	prq, _ := newProxyRequest(NewExchange("127.0.0.1:50000", 0), rq)
+
+	h, prs := NewHAR("testhar", MatchAllRequests, MatchAllResponses, false, -1),
+		&ProxyResponse{Exchange: prq.Exchange, Status: "200 OK", StatusCode: 200, Proto: "HTTP/1.1", Header: http.Header{rsHdrK: []string{rsHdrV}, "Content-Type": []string{"text/plain"}}, Body: []byte(rsbody), Request: rq}
+
+	if err := h.Intercept().request(prq); err != nil {
+		t.Fatalf("expected no error processing request, got [%v]", err)
+	}
+
+	if err := h.Intercept().response(prs); err != nil {
+		t.Fatalf("expected no error processing response, got [%v]", err)
+	}
+
+	b := bytes.Buffer{}
+
+	if _, err := h.WriteTo(&b); err != nil {
+		t.Fatalf("expected no error writing har log, got [%v]", err)
+	}
+
+	l := harLog{}
+
+	if err := json.Unmarshal(b.Bytes(), &l); err != nil {
+		t.Fatalf("expected har log to be valid json, got [%v]", err)
+	}
+
+	if l.Log.Version != "1.2" || len(l.Log.Entries) != 1 {
+		t.Fatalf("expected a version 1.2 har log with 1 entry, got version [%v] with [%v] entries", l.Log.Version, len(l.Log.Entries))
+	}
+
+	e := l.Log.Entries[0]
+
+	assert := func(attr, exp, got string) {
+		if got != exp {
+			t.Fatalf("expected %v of [%v], got [%v]", attr, exp, got)
+		}
+	}
+
+	assert("request url", rq.URL.String(), e.Request.URL)
+	assert("request method", rq.Method, e.Request.Method)
+	assert("request body", rqbody, e.Request.PostData.Text)
+	assert("response status text", "OK", e.Response.StatusText)
+	assert("response body", rsbody, e.Response.Content.Text)
+
+	if len(e.Request.QueryString) != 1 || e.Request.QueryString[0].Value != "some-qs-data" {
+		t.Fatalf("expected request querystring to be recorded, got [%+v]", e.Request.QueryString)
+	}
+
+	if len(e.Request.Cookies) != 1 || e.Request.Cookies[0].Value != "rq-cookie-value" {
+		t.Fatalf("expected request cookie to be recorded, got [%+v]", e.Request.Cookies)
+	}
+
+	found := false
+
+	for _, h := range e.Response.Headers {
+		found = found || (h.Name == rsHdrK && h.Value == rsHdrV)
+	}
+
+	if !found {
+		t.Fatalf("expected response header [%v:%v] to be recorded, got [%+v]", rsHdrK, rsHdrV, e.Response.Headers)
+	}
+}
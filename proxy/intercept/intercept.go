@@ -2,18 +2,77 @@ package intercept
 
 import (
 	"comradequinn/hflow/log"
+	"comradequinn/hflow/proxy/internal/copy"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
+// Phase describes the stage of an exchange's processing at which an Intercept is applied
+type Phase int
+
+const (
+	// PhaseModify intercepts are applied first and are expected to change the exchange
+	PhaseModify Phase = iota
+	// PhaseObserve intercepts are applied after all PhaseModify intercepts and are expected only to read the exchange
+	PhaseObserve
+)
+
+// String returns the name of the Phase
+func (p Phase) String() string {
+	switch p {
+	case PhaseModify:
+		return "modify"
+	case PhaseObserve:
+		return "observe"
+	default:
+		return fmt.Sprintf("phase(%v)", int(p))
+	}
+}
+
+// ErrStopChain can be returned by a RequestFunc or ResponseFunc to prevent any further intercepts being applied
+// to the request or response. It is not treated as an error by Request or Response
+var ErrStopChain = errors.New("stop intercept chain")
+
+// ErrDrop can be returned by a RequestFunc or ResponseFunc to cause the proxy to close the client connection without
+// responding. It is returned unwrapped by Request and Response
+var ErrDrop = errors.New("drop exchange")
+
+// bufferLimit is the maximum size, in bytes, of a buffered body
+var bufferLimit int64 = 10 << 20
+
+// SetBufferLimit sets the maximum size, in bytes, of a body buffered for intercepts created WithBuffering and of the copy
+// of a streamed body captured for PhaseObserve intercepts. Bodies larger than n are streamed without the intercepts that
+// require buffering being applied and are captured only up to n bytes. The default is 10MB
+func SetBufferLimit(n int64) {
+	atomic.StoreInt64(&bufferLimit, n)
+}
+
+// BufferLimit returns the maximum size, in bytes, of a buffered body, as set by SetBufferLimit
+func BufferLimit() int64 {
+	return atomic.LoadInt64(&bufferLimit)
+}
+
 // Intercept describes an action to be taken on a http exchange
 // and the conditions to be met in order for that action to be applied
 type Intercept struct {
 	label    string
+	phase    Phase
+	priority int
+	disabled bool
+	buffered bool
 	matchRq  MatchRequestFunc
 	request  RequestFunc
 	matchRs  MatchResponseFunc
 	response ResponseFunc
+
+	frame       FrameFunc
+	frameClosed func(Exchange)
 }
 
 // NewIntercept returns a new Intercept based on the passed arguments
@@ -39,70 +98,369 @@ func (i *Intercept) Label() string {
 	return i.label
 }
 
-// Request returns a new *http.Request which is the result of applying any matching intercepts to hr
-func Request(hr *http.Request, intercepts map[int]*Intercept) (*http.Request, error) {
-	log.Printf(3, "intercepting request for [%v]", hr.URL.String())
+// Phase returns the Phase of the Intercept
+func (i *Intercept) Phase() Phase {
+	return i.phase
+}
 
-	r, err := newProxyRequest(hr)
+// Priority returns the Priority of the Intercept
+func (i *Intercept) Priority() int {
+	return i.priority
+}
 
-	if err != nil {
-		return nil, fmt.Errorf("error creating proxy request from https request to remote client [%v]. [%v]", hr.URL.String(), err)
+// Enabled returns true unless the Intercept has been disabled
+func (i *Intercept) Enabled() bool {
+	return !i.disabled
+}
+
+// WithEnabled sets whether the Intercept is applied and returns the Intercept. Disabled intercepts retain their place in
+// the order of intercepts but are skipped by Request and Response. Intercepts default to enabled
+func (i *Intercept) WithEnabled(enabled bool) *Intercept {
+	i.disabled = !enabled
+	return i
+}
+
+// Buffered returns true if the Intercept requires complete bodies
+func (i *Intercept) Buffered() bool {
+	return i.buffered
+}
+
+// WithBuffering sets whether the Intercept requires complete bodies and returns the Intercept. Bodies are streamed
+// between the client and upstream host unless an enabled Intercept requires buffering, so ProxyRequest.Body and
+// ProxyResponse.Body are nil for PhaseModify intercepts that do not. PhaseObserve intercepts applied to streamed bodies
+// are applied once the body has been streamed, to a copy of it capped at the buffer limit. Intercepts default to not
+// requiring buffering
+func (i *Intercept) WithBuffering(buffered bool) *Intercept {
+	i.buffered = buffered
+	return i
+}
+
+// WithPhase sets the Phase in which the Intercept is applied and returns the Intercept. Intercepts default to PhaseModify
+func (i *Intercept) WithPhase(p Phase) *Intercept {
+	i.phase = p
+	return i
+}
+
+// WithPriority sets the priority of the Intercept within its Phase and returns the Intercept. Intercepts with a lower
+// priority are applied first, intercepts with equal priority are applied in the order they were registered. Intercepts
+// default to a priority of 0
+func (i *Intercept) WithPriority(p int) *Intercept {
+	i.priority = p
+	return i
+}
+
+// Order returns the ids of intercepts in the order the intercepts are applied; sorted by phase, then priority, then id,
+// where id reflects the order of registration
+func Order(intercepts map[int]*Intercept) []int {
+	ids := make([]int, 0, len(intercepts))
+
+	for id := range intercepts {
+		ids = append(ids, id)
 	}
 
-	matched := false
+	sort.Slice(ids, func(a, b int) bool {
+		ia, ib := intercepts[ids[a]], intercepts[ids[b]]
 
-	for _, intercept := range intercepts {
-		if matched, err = intercept.matchRq(r); err != nil {
-			return nil, fmt.Errorf("error matching intercept [%v] to request for [%v]: [%v]", intercept.label, r.URL.String(), err)
+		if ia.phase != ib.phase {
+			return ia.phase < ib.phase
 		}
 
-		if matched {
-			log.Printf(2, "applying intercept labelled [%v] to request for [%v]", intercept.label, r.URL.String())
+		if ia.priority != ib.priority {
+			return ia.priority < ib.priority
+		}
 
-			if err = intercept.request(r); err != nil {
-				return nil, fmt.Errorf("error applying intercept [%v] to request for [%v]: [%v]", intercept.label, r.URL.String(), err)
-			}
+		return ids[a] < ids[b]
+	})
+
+	return ids
+}
+
+func ordered(intercepts map[int]*Intercept) []*Intercept {
+	o := make([]*Intercept, 0, len(intercepts))
+
+	for _, id := range Order(intercepts) {
+		if i := intercepts[id]; !i.disabled {
+			o = append(o, i)
+		}
+	}
+
+	return o
+}
+
+// buffering returns true where any of intercepts requires complete bodies
+func buffering(intercepts []*Intercept) bool {
+	for _, i := range intercepts {
+		if i.buffered {
+			return true
 		}
 	}
 
-	return r.http()
+	return false
+}
+
+func hasBody(b io.ReadCloser) bool {
+	return b != nil && b != http.NoBody
 }
 
-// Response returns a new *http.Response which is the result of applying any matching intercepts to hrs
-func Response(hr *http.Request, hrs *http.Response, intercepts map[int]*Intercept) (*http.Response, error) {
-	log.Printf(3, "interupting response for [%v]", hr.URL.String())
+// Request returns a new *http.Request which is the result of applying any matching intercepts to hr as part of exchange x.
+// Intercepts are applied in phase, then priority, then registration order until one returns ErrStopChain.
+//
+// Where an intercept sets a response on the request, that response is also returned and should be used in place of
+// sending the request upstream. Any delay set by an intercept has elapsed by the time Request returns.
+//
+// The body of hr is streamed to the returned *http.Request unless an intercept requires buffering, in which case PhaseObserve
+// intercepts are applied once it has been read
+func Request(x Exchange, hr *http.Request, intercepts map[int]*Intercept) (*http.Request, *http.Response, error) {
+	log.Printf(3, "intercepting request for [%v] in exchange [%v]", hr.URL.String(), x.ID)
+
+	r, is, limit := requestHead(x, hr), ordered(intercepts), BufferLimit()
+
+	if hasBody(hr.Body) {
+		r.stream, r.contentLength = hr.Body, hr.ContentLength
+
+		if buffering(is) {
+			if hr.GetBody != nil {
+				if b, err := hr.GetBody(); err == nil {
+					hr.Body = b
+				}
+			}
+
+			b, ok, err := copy.CloserToBytesN(&hr.Body, limit)
+
+			if err != nil {
+				return nil, nil, fmt.Errorf("unable to read request body for [%v]: [%v]", hr.URL.String(), err)
+			}
+
+			if r.stream = hr.Body; ok {
+				r.Body, r.stream = b, nil
+			} else {
+				log.Printf(1, "request body for [%v] exceeds the buffer limit of [%v] bytes, streaming it without intercepts that require buffering", hr.URL.String(), limit)
+			}
+		}
+	}
+
+	observe := []*Intercept{}
+
+	for _, intercept := range is {
+		if r.stream != nil && intercept.phase == PhaseObserve {
+			observe = append(observe, intercept)
+			continue
+		}
+
+		if r.stream != nil && intercept.buffered {
+			continue
+		}
+
+		err := intercept.applyRequest(r)
+
+		if err == ErrStopChain {
+			break
+		}
+
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if r.Delay > 0 {
+		log.Printf(2, "delaying request for [%v] by [%v]", r.URL.String(), r.Delay)
+		time.Sleep(r.Delay)
+	}
+
+	if r.stream != nil && len(observe) > 0 {
+		r.stream = copy.Tee(r.stream, limit, func(b []byte, complete bool) {
+			if !complete {
+				log.Printf(1, "captured request body for [%v] is incomplete, having exceeded the buffer limit or not been read in full", r.URL.String())
+			}
+
+			r.Body = b
+
+			for _, intercept := range observe {
+				if err := intercept.applyRequest(r); err != nil && err != ErrStopChain {
+					log.Printf(0, "%v", err)
+				}
+			}
+		})
+	}
 
-	rs, err := newProxyResponse(hrs)
+	nr, err := r.http()
+
+	if err != nil || r.Response == nil {
+		return nr, nil, err
+	}
+
+	log.Printf(2, "using response set by intercept in place of upstream response to [%v]", r.URL.String())
+
+	if r.stream != nil {
+		io.Copy(io.Discard, nr.Body)
+		nr.Body.Close()
+	}
+
+	r.Response.Request = nr
+
+	nrs, err := r.Response.http()
+
+	return nr, nrs, err
+}
+
+// applyRequest applies i to r where it matches r, returning ErrStopChain and ErrDrop unwrapped
+func (i *Intercept) applyRequest(r *ProxyRequest) error {
+	matched, err := i.matchRq(r)
 
 	if err != nil {
-		return nil, fmt.Errorf("error creating proxy response from https response to [%v]: [%v]", hr.URL.String(), err)
+		return fmt.Errorf("error matching intercept [%v] to request for [%v]: [%v]", i.label, r.URL.String(), err)
+	}
+
+	if !matched {
+		return nil
 	}
 
-	r, err := newProxyRequest(hr)
+	log.Printf(2, "applying intercept labelled [%v] to request for [%v]", i.label, r.URL.String())
+
+	switch err = i.request(r); err {
+	case nil:
+		return nil
+	case ErrStopChain:
+		log.Printf(2, "intercept labelled [%v] stopped the intercept chain for request for [%v]", i.label, r.URL.String())
+		return err
+	case ErrDrop:
+		log.Printf(2, "intercept labelled [%v] dropped request for [%v]", i.label, r.URL.String())
+		return err
+	default:
+		return fmt.Errorf("error applying intercept [%v] to request for [%v]: [%v]", i.label, r.URL.String(), err)
+	}
+}
+
+// Response returns a new *http.Response which is the result of applying any matching intercepts to hrs as part of exchange x.
+// The End of x is set to the time at which Response is called. Intercepts are applied in phase, then priority, then
+// registration order until one returns ErrStopChain.
+//
+// The body of hrs is streamed to the returned *http.Response unless an intercept requires buffering, in which case
+// PhaseObserve intercepts are applied once it has been read or closed
+func Response(x Exchange, hr *http.Request, hrs *http.Response, intercepts map[int]*Intercept) (*http.Response, error) {
+	log.Printf(3, "interupting response for [%v] in exchange [%v]", hr.URL.String(), x.ID)
+
+	x.End = time.Now()
+
+	rs, is, limit := newProxyResponse(x, hrs), ordered(intercepts), BufferLimit()
+
+	r, err := newProxyRequest(x, hr)
 
 	if err != nil {
+		if hrs.Body != nil {
+			hrs.Body.Close()
+		}
+
 		return nil, fmt.Errorf("error creating proxy request from https request to remote client [%v]. [%v]", hr.URL.String(), err)
 	}
 
-	matched := false
+	if hasBody(hrs.Body) {
+		hrs.Body = trailerReader{ReadCloser: hrs.Body, hr: hrs, trailer: rs.Trailer}
+		rs.stream, rs.contentLength, rs.transferEncoding = hrs.Body, hrs.ContentLength, hrs.TransferEncoding
 
-	for _, intercept := range intercepts {
-		if matched, err = intercept.matchRs(r, rs); err != nil {
-			return nil, fmt.Errorf("error matching intercept [%v] to response to [%v]: [%v]", intercept.label, hr.URL.String(), err)
+		// responses that may have trailers are chunked so that the trailers can be written to HTTP/1.1 clients
+		if hrs.Trailer != nil || isGRPC(rs.Header) {
+			rs.contentLength = -1
 		}
 
-		if matched {
-			log.Printf(2, "applying intercept labelled [%v] to response to [%v]", intercept.label, hr.URL.String())
+		switch {
+		case !buffering(is):
+		case strings.HasPrefix(rs.Header.Get("Content-Type"), "text/event-stream"):
+			log.Printf(1, "streaming event stream response to [%v] without intercepts that require buffering", hr.URL.String())
+		default:
+			b, ok, err := copy.CloserToBytesN(&hrs.Body, limit)
+
+			if err != nil {
+				hrs.Body.Close()
+				return nil, fmt.Errorf("unable to read response body from [%v]: [%v]", hr.URL.String(), err)
+			}
 
-			if err = intercept.response(rs); err != nil {
-				return nil, fmt.Errorf("error applying intercept [%v] to response to [%v]: [%v]", intercept.label, hr.URL.String(), err)
+			if rs.stream = hrs.Body; !ok {
+				log.Printf(1, "response body from [%v] exceeds the buffer limit of [%v] bytes, streaming it without intercepts that require buffering", hr.URL.String(), limit)
+				break
+			}
+
+			rs.stream = nil
+
+			if err := rs.decode(b); err != nil {
+				return nil, err
 			}
 		}
 	}
 
-	if err != nil {
-		return nil, fmt.Errorf("error creating proxy request from https request to remote client [%v]. [%v]", hr.URL.String(), err)
+	observe := []*Intercept{}
+
+	for _, intercept := range is {
+		if rs.stream != nil && intercept.phase == PhaseObserve {
+			observe = append(observe, intercept)
+			continue
+		}
+
+		if rs.stream != nil && intercept.buffered {
+			continue
+		}
+
+		err := intercept.applyResponse(r, rs)
+
+		if err == ErrStopChain {
+			break
+		}
+
+		if err != nil {
+			if rs.stream != nil {
+				rs.stream.Close()
+			}
+
+			return nil, err
+		}
+	}
+
+	if rs.stream != nil && len(observe) > 0 {
+		rs.stream = copy.Tee(rs.stream, limit, func(b []byte, complete bool) {
+			if !complete {
+				log.Printf(1, "captured response body from [%v] is incomplete, having exceeded the buffer limit or not been read in full", hr.URL.String())
+			}
+
+			if err := rs.decode(b); err != nil {
+				log.Printf(1, "%v, capturing it encoded", err)
+				rs.Body = b
+			}
+
+			for _, intercept := range observe {
+				if err := intercept.applyResponse(r, rs); err != nil && err != ErrStopChain {
+					log.Printf(0, "%v", err)
+				}
+			}
+		})
 	}
 
 	return rs.http()
 }
+
+// applyResponse applies i to rs, as received for r, where it matches them, returning ErrStopChain and ErrDrop unwrapped
+func (i *Intercept) applyResponse(r *ProxyRequest, rs *ProxyResponse) error {
+	matched, err := i.matchRs(r, rs)
+
+	if err != nil {
+		return fmt.Errorf("error matching intercept [%v] to response to [%v]: [%v]", i.label, r.URL.String(), err)
+	}
+
+	if !matched {
+		return nil
+	}
+
+	log.Printf(2, "applying intercept labelled [%v] to response to [%v]", i.label, r.URL.String())
+
+	switch err = i.response(rs); err {
+	case nil:
+		return nil
+	case ErrStopChain:
+		log.Printf(2, "intercept labelled [%v] stopped the intercept chain for response to [%v]", i.label, r.URL.String())
+		return err
+	case ErrDrop:
+		log.Printf(2, "intercept labelled [%v] dropped response to [%v]", i.label, r.URL.String())
+		return err
+	default:
+		return fmt.Errorf("error applying intercept [%v] to response to [%v]: [%v]", i.label, r.URL.String(), err)
+	}
+}
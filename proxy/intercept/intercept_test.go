@@ -0,0 +1,142 @@
+package intercept
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestOrder(t *testing.T) {
+	test := func(t *testing.T, intercepts map[int]*Intercept, expected string) {
+		rq, _ := http.NewRequest(http.MethodGet, "http://www.test.com/", nil)
+
+		rq, _, err := Request(NewExchange("127.0.0.1:50000", 0), rq, intercepts)
+
+		if err != nil {
+			t.Fatalf("expected no error applying intercepts, got [%v]", err)
+		}
+
+		if got := rq.Header.Get("Order"); got != expected {
+			t.Fatalf("expected intercepts to be applied in order [%v], got [%v]", expected, got)
+		}
+	}
+
+	appender := func(s string, err error) *Intercept {
+		return NewIntercept(s, MatchAllRequests, MatchAllResponses,
+			func(r *ProxyRequest) error {
+				r.Header.Set("Order", strings.TrimSpace(r.Header.Get("Order")+" "+s))
+				return err
+			},
+			func(r *ProxyResponse) error { return nil },
+		)
+	}
+
+	t.Run("RegistrationOrder", func(t *testing.T) {
+		test(t, map[int]*Intercept{3: appender("c", nil), 1: appender("a", nil), 2: appender("b", nil)}, "a b c")
+	})
+
+	t.Run("PhaseOrder", func(t *testing.T) {
+		test(t, map[int]*Intercept{1: appender("b", nil).WithPhase(PhaseObserve), 2: appender("a", nil)}, "a b")
+	})
+
+	t.Run("PriorityOrder", func(t *testing.T) {
+		test(t, map[int]*Intercept{1: appender("c", nil).WithPriority(2), 2: appender("b", nil).WithPriority(1), 3: appender("a", nil)}, "a b c")
+	})
+
+	t.Run("StopChain", func(t *testing.T) {
+		test(t, map[int]*Intercept{1: appender("a", nil), 2: appender("b", ErrStopChain), 3: appender("c", nil)}, "a b")
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		test(t, map[int]*Intercept{1: appender("a", nil), 2: appender("b", nil).WithEnabled(false), 3: appender("c", nil)}, "a c")
+	})
+}
+
+func TestRequestMock(t *testing.T) {
+	rq, _ := http.NewRequest(http.MethodGet, "http://www.test.com/", nil)
+	delay, body := time.Millisecond*50, "mock-body"
+
+	start := time.Now()
+
+	_, rs, err := Request(NewExchange("127.0.0.1:50000", 0), rq, map[int]*Intercept{
+		1: Mock("mock", MatchAllRequests, http.StatusTeapot, http.Header{"Mock-Hk": []string{"Mock-Hv"}}, []byte(body), delay),
+	})
+
+	if err != nil {
+		t.Fatalf("expected no error applying intercepts, got [%v]", err)
+	}
+
+	if rs == nil {
+		t.Fatalf("expected a mock response, got nil")
+	}
+
+	if time.Since(start) < delay {
+		t.Fatalf("expected mock response to be delayed by [%v], got [%v]", delay, time.Since(start))
+	}
+
+	b, _ := io.ReadAll(rs.Body)
+
+	if rs.StatusCode != http.StatusTeapot || rs.Header.Get("Mock-Hk") != "Mock-Hv" || string(b) != body {
+		t.Fatalf("expected mock response with status [%v], header [%v] and body [%v], got [%v], [%v] and [%v]", http.StatusTeapot, "Mock-Hv", body, rs.StatusCode, rs.Header.Get("Mock-Hk"), string(b))
+	}
+}
+
+func TestResponseStreaming(t *testing.T) {
+	test := func(t *testing.T, buffered bool, limit int64, expStreamed bool, expBody, expObserved string) {
+		SetBufferLimit(limit)
+		defer SetBufferLimit(10 << 20)
+
+		rq, _ := http.NewRequest(http.MethodGet, "http://www.test.com/", nil)
+		pr, pw := io.Pipe()
+		observed := make(chan string, 1)
+
+		go func() {
+			pw.Write([]byte("body"))
+			pw.Close()
+		}()
+
+		rs, err := Response(NewExchange("127.0.0.1:50000", 0), rq,
+			&http.Response{Status: "200 OK", StatusCode: http.StatusOK, Header: http.Header{}, Body: pr, ContentLength: -1, Request: rq},
+			map[int]*Intercept{
+				1: NewIntercept("modify", MatchAllRequests, MatchAllResponses,
+					func(r *ProxyRequest) error { return nil },
+					func(r *ProxyResponse) error {
+						if r.Body != nil {
+							r.Body = append(r.Body, "-modified"...)
+						}
+
+						return nil
+					},
+				).WithBuffering(buffered),
+				2: NewIntercept("observe", MatchAllRequests, MatchAllResponses,
+					func(r *ProxyRequest) error { return nil },
+					func(r *ProxyResponse) error { observed <- string(r.Body); return nil },
+				).WithPhase(PhaseObserve),
+			})
+
+		if err != nil {
+			t.Fatalf("expected no error applying intercepts, got [%v]", err)
+		}
+
+		if streamed := len(observed) == 0; streamed != expStreamed {
+			t.Fatalf("expected response body to be streamed [%v], got [%v]", expStreamed, streamed)
+		}
+
+		b, _ := io.ReadAll(rs.Body)
+		rs.Body.Close()
+
+		if string(b) != expBody {
+			t.Fatalf("expected response body [%v], got [%v]", expBody, string(b))
+		}
+
+		if got := <-observed; got != expObserved {
+			t.Fatalf("expected observed response body [%v], got [%v]", expObserved, got)
+		}
+	}
+
+	t.Run("Streamed", func(t *testing.T) { test(t, false, 10<<20, true, "body", "body") })
+	t.Run("Buffered", func(t *testing.T) { test(t, true, 10<<20, false, "body-modified", "body-modified") })
+	t.Run("ExceedsLimit", func(t *testing.T) { test(t, true, 2, true, "body", "bo") })
+}
@@ -0,0 +1,167 @@
+package intercept
+
+import (
+	"comradequinn/hflow/log"
+	"comradequinn/hflow/proxy/internal/codec"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+type (
+	jsonlRecord struct {
+		ID         uint64        `json:"id"`
+		Start      time.Time     `json:"start"`
+		End        time.Time     `json:"end"`
+		Duration   float64       `json:"duration_ms"`
+		ClientAddr string        `json:"client_addr"`
+		TunnelID   uint64        `json:"tunnel_id,omitempty"`
+		Request    jsonlRequest  `json:"request"`
+		Response   jsonlResponse `json:"response"`
+		TLS        *jsonlTLS     `json:"tls,omitempty"`
+		Frames     []jsonlFrame  `json:"frames,omitempty"`
+	}
+
+	jsonlFrame struct {
+		Direction     string    `json:"direction"`
+		Time          time.Time `json:"time"`
+		Type          string    `json:"type"`
+		Fin           bool      `json:"fin"`
+		Body          string    `json:"body"`
+		BodyEncoding  string    `json:"body_encoding,omitempty"`
+		BodySize      int64     `json:"body_size"`
+		BodyTruncated bool      `json:"body_truncated"`
+	}
+
+	jsonlRequest struct {
+		Method        string      `json:"method"`
+		URL           string      `json:"url"`
+		Proto         string      `json:"proto,omitempty"`
+		Header        http.Header `json:"header"`
+		Body          string      `json:"body"`
+		BodyEncoding  string      `json:"body_encoding,omitempty"`
+		BodySize      int         `json:"body_size"`
+		BodyTruncated bool        `json:"body_truncated"`
+	}
+
+	jsonlResponse struct {
+		Status          string      `json:"status"`
+		StatusCode      int         `json:"status_code"`
+		Proto           string      `json:"proto"`
+		Header          http.Header `json:"header"`
+		Body            string      `json:"body"`
+		BodyEncoding    string      `json:"body_encoding,omitempty"`
+		BodySize        int         `json:"body_size"`
+		BodyTruncated   bool        `json:"body_truncated"`
+		ContentEncoding string      `json:"content_encoding,omitempty"`
+		Decoded         bool        `json:"decoded"`
+		EncodedSize     int         `json:"encoded_size"`
+		Trailer         http.Header `json:"trailer,omitempty"`
+	}
+
+	jsonlTLS struct {
+		Version            string `json:"version"`
+		CipherSuite        string `json:"cipher_suite"`
+		ServerName         string `json:"server_name,omitempty"`
+		NegotiatedProtocol string `json:"negotiated_protocol,omitempty"`
+	}
+)
+
+// JSONLWriter writes each complete exchange to the specified io.Writer as a single line of JSON where
+// mrq matches the request and mrs matches the response
+//
+// Unless binary is set to true, non-text bodies are omitted, otherwise they are written base64 encoded
+// If limit is greater than or equal to 0, then text body writes are capped at that number of bytes
+func JSONLWriter(label string, mrq MatchRequestFunc, mrs MatchResponseFunc, binary bool, limit int, w io.Writer) *Intercept {
+	return Recorder(label, mrq, mrs, func(rec *Record) {
+		b, err := json.Marshal(jsonlFromRecord(rec, binary, limit))
+
+		if err != nil {
+			log.Printf(0, "unable to encode exchange as json during jsonl writer intercept labelled [%v]: [%v]", label, err)
+			return
+		}
+
+		go func() {
+			if _, err := w.Write(append(b, '\n')); err != nil {
+				log.Printf(0, "unable to write to io.Writer during jsonl writer intercept labelled [%v]: [%v]", label, err)
+			}
+		}()
+	})
+}
+
+// WriteJSONL writes each of recs to w as a single line of JSON, as per JSONLWriter
+func WriteJSONL(w io.Writer, recs []*Record, binary bool, limit int) error {
+	e := json.NewEncoder(w)
+
+	for _, rec := range recs {
+		if err := e.Encode(jsonlFromRecord(rec, binary, limit)); err != nil {
+			return fmt.Errorf("unable to write exchange [%v] as json: [%v]", rec.ID, err)
+		}
+	}
+
+	return nil
+}
+
+func jsonlFromRecord(rec *Record, binary bool, limit int) jsonlRecord {
+	rq, rs := rec.Request, rec.Response
+
+	jr := jsonlRecord{ID: rec.ID, Start: rec.Start, End: rec.End, Duration: float64(rec.End.Sub(rec.Start)) / float64(time.Millisecond), ClientAddr: rq.ClientAddr, TunnelID: rq.TunnelID}
+
+	jr.Request = jsonlRequest{Method: rq.Method, URL: rq.URL.String(), Proto: rq.Proto, Header: rq.Header, BodySize: len(rq.Body)}
+	b, text := grpcBody(rq.URL.Path, rq.Header, rq.Body, false)
+	jr.Request.Body, jr.Request.BodyEncoding, jr.Request.BodyTruncated = captureBody(b, text, binary, limit)
+
+	ce := rs.Header.Get("Content-Encoding")
+
+	jr.Response = jsonlResponse{
+		Status:          rs.Status,
+		StatusCode:      rs.StatusCode,
+		Proto:           rs.Proto,
+		Header:          rs.Header,
+		BodySize:        len(rs.Body),
+		ContentEncoding: ce,
+		Decoded:         codec.Supported(ce),
+		EncodedSize:     rs.encodedSize,
+	}
+	b, text = grpcBody(rq.URL.Path, rs.Header, rs.Body, true)
+	jr.Response.Body, jr.Response.BodyEncoding, jr.Response.BodyTruncated = captureBody(b, text, binary, limit)
+
+	if len(rs.Trailer) > 0 {
+		jr.Response.Trailer = rs.Trailer
+	}
+
+	for _, f := range rec.Frames {
+		jf := jsonlFrame{Direction: f.Direction, Time: f.Time, Type: f.Type(), Fin: f.Fin, BodySize: f.Size}
+		jf.Body, jf.BodyEncoding, jf.BodyTruncated = captureBody(f.Payload, isText(frameHeader(f)), binary, limit)
+		jr.Frames = append(jr.Frames, jf)
+	}
+
+	if rs.TLS != nil {
+		jr.TLS = &jsonlTLS{
+			Version:            tlsVersion(rs.TLS.Version),
+			CipherSuite:        tls.CipherSuiteName(rs.TLS.CipherSuite),
+			ServerName:         rs.TLS.ServerName,
+			NegotiatedProtocol: rs.TLS.NegotiatedProtocol,
+		}
+	}
+
+	return jr
+}
+
+func tlsVersion(v uint16) string {
+	switch v {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	default:
+		return fmt.Sprintf("0x%04X", v)
+	}
+}
@@ -0,0 +1,53 @@
+package intercept
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestJSONLWriter(t *testing.T) {
+	test := func(t *testing.T, rsContentType string, limit int, expBody, expEncoding string, expTruncated bool) {
+		rqbody, rsbody, tb := "rq-body-data", "rs-body-data", &TestBuffer{Wrote: make(chan struct{}, 1)}
+
+		rq, _ := http.NewRequest(http.MethodPost, "http://www.test.com/echo/?data=some-qs-data", strings.NewReader(rqbody))
+		prq, _ := newProxyRequest(NewExchange("127.0.0.1:50000", 0), rq)
+
+		i, prs := JSONLWriter("testjsonlwriter", MatchAllRequests, MatchAllResponses, false, limit, tb),
+			&ProxyResponse{Exchange: prq.Exchange, Status: "200 OK", StatusCode: 200, Header: http.Header{"Content-Type": []string{rsContentType}}, Body: []byte(rsbody), Request: rq}
+
+		if err := i.request(prq); err != nil {
+			t.Fatalf("expected no error processing request, got [%v]", err)
+		}
+
+		if err := i.response(prs); err != nil {
+			t.Fatalf("expected no error processing response, got [%v]", err)
+		}
+
+		<-tb.Wrote
+
+		if lines := strings.Split(strings.TrimSpace(tb.Buffer.String()), "\n"); len(lines) != 1 {
+			t.Fatalf("expected a single line per exchange, got [%v]", len(lines))
+		}
+
+		jr := jsonlRecord{}
+
+		if err := json.Unmarshal(tb.Buffer.Bytes(), &jr); err != nil {
+			t.Fatalf("expected output to be valid json, got [%v]", err)
+		}
+
+		if jr.ID != prq.ID || jr.Request.URL != rq.URL.String() || jr.Request.Method != rq.Method || jr.Response.StatusCode != prs.StatusCode {
+			t.Fatalf("expected output to describe the exchange, got [%+v]", jr)
+		}
+
+		if jr.Response.Body != expBody || jr.Response.BodyEncoding != expEncoding || jr.Response.BodyTruncated != expTruncated {
+			t.Fatalf("expected response body [%v] with encoding [%v] and truncated [%v], got [%v] with encoding [%v] and truncated [%v]",
+				expBody, expEncoding, expTruncated, jr.Response.Body, jr.Response.BodyEncoding, jr.Response.BodyTruncated)
+		}
+	}
+
+	t.Run("Text", func(t *testing.T) { test(t, "text/plain", -1, "rs-body-data", "", false) })
+	t.Run("TruncatedText", func(t *testing.T) { test(t, "text/plain", 2, "rs", "", true) })
+	t.Run("Binary", func(t *testing.T) { test(t, "image/gif", -1, "", bodyOmitted, false) })
+}
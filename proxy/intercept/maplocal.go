@@ -0,0 +1,118 @@
+package intercept
+
+import (
+	"bytes"
+	"comradequinn/hflow/log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// MapLocal returns an *Intercept that responds to requests with a url starting with urlPrefix using the content of the
+// local file or directory at path, without contacting the upstream host. The response remains subject to any matching
+// response intercepts. urlPrefix is matched as per the From location of Remote, so the scheme and port are optional and
+// its path matches whole path segments only. Query strings are ignored when matching urlPrefix
+//
+// Where path is a file, it is served for all matching requests. Where path is a directory, the remainder of the request
+// url after urlPrefix is served from within it, with index.html served for directories. Content-Type is guessed from the
+// file extension, or content, and Range and conditional requests are honoured
+func MapLocal(label, urlPrefix, path string) *Intercept {
+	if _, err := os.Stat(path); err != nil {
+		log.Printf(0, "warning: path [%v] mapped to [%v] by intercept labelled [%v] is not currently accessible: [%v]", path, urlPrefix, label, err)
+	}
+
+	prefix, err := parseLocation(urlPrefix)
+
+	if err != nil {
+		log.Printf(0, "warning: url prefix [%v] mapped to [%v] by intercept labelled [%v] is invalid and matches no requests: [%v]", urlPrefix, path, label, err)
+	}
+
+	return NewIntercept(label,
+		func(r *ProxyRequest) (bool, error) {
+			_, ok := localPath(r, prefix)
+			return ok, nil
+		},
+		func(*ProxyRequest, *ProxyResponse) (bool, error) { return false, nil },
+		ServeLocal(urlPrefix, path),
+		func(r *ProxyResponse) error { return nil },
+	)
+}
+
+// ServeLocal returns a RequestFunc that sets the Response of requests with a url starting with urlPrefix to the content
+// of the local file or directory at path, as per MapLocal. Other requests are unchanged
+func ServeLocal(urlPrefix, path string) RequestFunc {
+	prefix, err := parseLocation(urlPrefix)
+
+	if err != nil {
+		log.Printf(0, "warning: url prefix [%v] mapped to local path [%v] is invalid and matches no requests: [%v]", urlPrefix, path, err)
+	}
+
+	return func(r *ProxyRequest) error {
+		rest, ok := localPath(r, prefix)
+
+		if !ok {
+			return nil
+		}
+
+		rq := &http.Request{Method: r.Method, URL: &url.URL{Path: "/" + rest}, Header: r.Header.Clone(), Proto: "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1}
+		rw := &bufferWriter{header: http.Header{}}
+
+		info, err := os.Stat(path)
+
+		switch {
+		case err != nil:
+			log.Printf(1, "unable to serve local path [%v] for [%v]: [%v]", path, r.URL.String(), err)
+			http.NotFound(rw, rq)
+		case info.IsDir():
+			http.FileServer(http.Dir(path)).ServeHTTP(rw, rq)
+		default:
+			rq.URL.Path = "/"
+			http.ServeFile(rw, rq, path)
+		}
+
+		if rw.status == 0 {
+			rw.status = http.StatusOK
+		}
+
+		log.Printf(2, "serving [%v] from local path [%v] with status [%v]", r.URL.String(), path, rw.status)
+
+		r.Response = NewResponse(rw.status, rw.header, rw.body.Bytes())
+
+		return nil
+	}
+}
+
+// localPath returns the remainder of the path of r after that of prefix, and true if the url of r is within prefix. A nil
+// prefix matches no requests
+func localPath(r *ProxyRequest, prefix *url.URL) (string, bool) {
+	if prefix == nil {
+		return "", false
+	}
+
+	rest, ok := matchLocation(prefix, &r.URL)
+
+	return strings.TrimPrefix(rest, "/"), ok
+}
+
+// bufferWriter is a http.ResponseWriter that buffers the response written to it
+type bufferWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *bufferWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
@@ -0,0 +1,93 @@
+package intercept
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMapLocal(t *testing.T) {
+	dir := t.TempDir()
+
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	os.WriteFile(filepath.Join(dir, "app.js"), []byte("console.log('local');"), 0644)
+	os.WriteFile(filepath.Join(dir, "sub", "index.html"), []byte("<html>index</html>"), 0644)
+
+	test := func(t *testing.T, i *Intercept, url string, h http.Header, expStatus int, expContentType, expBody string) {
+		rq, _ := http.NewRequest(http.MethodGet, url, nil)
+
+		for k, v := range h {
+			rq.Header[k] = v
+		}
+
+		_, rs, err := Request(NewExchange("127.0.0.1:50000", 0), rq, map[int]*Intercept{1: i})
+
+		if err != nil {
+			t.Fatalf("expected no error applying intercept, got [%v]", err)
+		}
+
+		if expStatus == 0 {
+			if rs != nil {
+				t.Fatalf("expected request not to be mapped, got status [%v]", rs.StatusCode)
+			}
+
+			return
+		}
+
+		if rs == nil {
+			t.Fatalf("expected request to be mapped to a local response")
+		}
+
+		b, _ := io.ReadAll(rs.Body)
+
+		if rs.StatusCode != expStatus || !strings.HasPrefix(rs.Header.Get("Content-Type"), expContentType) || string(b) != expBody {
+			t.Fatalf("expected status [%v], content type [%v] and body [%v], got [%v], [%v] and [%v]", expStatus, expContentType, expBody, rs.StatusCode, rs.Header.Get("Content-Type"), string(b))
+		}
+	}
+
+	dirMap := MapLocal("dir", "https://www.test.com/static/", dir)
+	fileMap := MapLocal("file", "https://www.test.com/bundle.js", filepath.Join(dir, "app.js"))
+
+	t.Run("File", func(t *testing.T) {
+		test(t, fileMap, "https://www.test.com/bundle.js?v=2", nil, http.StatusOK, "text/javascript", "console.log('local');")
+	})
+
+	t.Run("Directory", func(t *testing.T) {
+		test(t, dirMap, "https://www.test.com/static/app.js", nil, http.StatusOK, "text/javascript", "console.log('local');")
+	})
+
+	t.Run("DefaultPort", func(t *testing.T) {
+		test(t, dirMap, "https://www.test.com:443/static/app.js", nil, http.StatusOK, "text/javascript", "console.log('local');")
+	})
+
+	t.Run("Index", func(t *testing.T) {
+		test(t, dirMap, "https://www.test.com/static/sub/", nil, http.StatusOK, "text/html", "<html>index</html>")
+	})
+
+	t.Run("Range", func(t *testing.T) {
+		test(t, dirMap, "https://www.test.com/static/app.js", http.Header{"Range": []string{"bytes=0-6"}}, http.StatusPartialContent, "text/javascript", "console")
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		test(t, dirMap, "https://www.test.com/static/missing.js", nil, http.StatusNotFound, "text/plain", "404 page not found\n")
+	})
+
+	t.Run("Traversal", func(t *testing.T) {
+		test(t, dirMap, "https://www.test.com/static/../../etc/passwd", nil, http.StatusNotFound, "text/plain", "404 page not found\n")
+	})
+
+	t.Run("NoMatch", func(t *testing.T) {
+		test(t, dirMap, "https://www.test.com/other/app.js", nil, 0, "", "")
+	})
+
+	t.Run("PartialSegment", func(t *testing.T) {
+		test(t, fileMap, "https://www.test.com/bundle.jsx", nil, 0, "", "")
+	})
+
+	t.Run("OtherScheme", func(t *testing.T) {
+		test(t, fileMap, "http://www.test.com/bundle.js", nil, 0, "", "")
+	})
+}
@@ -0,0 +1,164 @@
+package intercept
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// Remote describes the routing of requests from one remote location to another
+type Remote struct {
+	// From is the location that requests are routed from, as a url prefix. The scheme and port are optional, where omitted
+	// requests with any scheme or port match. The path, where specified, must prefix the request path
+	From string
+	// To is the location that requests are routed to, as a url prefix. The scheme, port and path are optional, where omitted
+	// those of the request are kept, other than a port that is the default for the scheme of the request. Where specified,
+	// the path replaces the path prefix matched by From
+	To string
+	// PreserveHost sends the original Host header of the request, rather than the host of To. A Host set by an intercept
+	// applied earlier is kept regardless
+	PreserveHost bool
+	// Host, where set, is sent as the Host header, overriding PreserveHost
+	Host string
+}
+
+// MapRemote returns an *Intercept that routes requests matching the From location of rm to its To location
+func MapRemote(label string, rm Remote) (*Intercept, error) {
+	rqf, err := rm.RequestFunc()
+
+	if err != nil {
+		return nil, err
+	}
+
+	from, _ := parseLocation(rm.From)
+
+	return NewIntercept(label,
+		func(r *ProxyRequest) (bool, error) {
+			_, ok := matchLocation(from, &r.URL)
+			return ok, nil
+		},
+		func(*ProxyRequest, *ProxyResponse) (bool, error) { return false, nil },
+		rqf,
+		func(r *ProxyResponse) error { return nil },
+	), nil
+}
+
+// RequestFunc returns a RequestFunc that routes requests matching the From location of rm to its To location. Other
+// requests are unchanged
+func (rm Remote) RequestFunc() (RequestFunc, error) {
+	from, err := parseLocation(rm.From)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid map remote from location [%v]: [%v]", rm.From, err)
+	}
+
+	to, err := parseLocation(rm.To)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid map remote to location [%v]: [%v]", rm.To, err)
+	}
+
+	return func(r *ProxyRequest) error {
+		rest, ok := matchLocation(from, &r.URL)
+
+		if !ok {
+			return nil
+		}
+
+		orig := r.URL
+
+		if to.Scheme != "" {
+			r.URL.Scheme = to.Scheme
+		}
+
+		r.URL.Host = to.Host
+
+		if _, p, err := net.SplitHostPort(hostHeader(&orig)); err == nil && to.Port() == "" {
+			r.URL.Host = net.JoinHostPort(to.Hostname(), p)
+		}
+
+		if to.Path != "" {
+			r.URL.Path, r.URL.RawPath = strings.TrimSuffix(to.Path, "/")+rest, ""
+		}
+
+		switch {
+		case rm.Host != "":
+			r.Host = rm.Host
+		case rm.PreserveHost:
+			if r.Host == "" {
+				r.Host = hostHeader(&orig)
+			}
+		default:
+			if r.Host == r.clientHost {
+				r.Host = ""
+			}
+		}
+
+		return nil
+	}, nil
+}
+
+// parseLocation parses s as a url, treating it as scheme relative where it has no scheme
+func parseLocation(s string) (*url.URL, error) {
+	if !strings.Contains(s, "://") {
+		s = "//" + s
+	}
+
+	u, err := url.Parse(s)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("a host is required")
+	}
+
+	return u, nil
+}
+
+// matchLocation returns the remainder of the path of u after the path of loc, and true if u is within loc
+func matchLocation(loc *url.URL, u *url.URL) (string, bool) {
+	if loc.Scheme != "" && !strings.EqualFold(loc.Scheme, u.Scheme) {
+		return "", false
+	}
+
+	if loc.Port() != "" {
+		if !strings.EqualFold(loc.Host, u.Host) && !strings.EqualFold(loc.Host, hostHeader(u)+":"+defaultPort(u.Scheme)) {
+			return "", false
+		}
+	} else if !strings.EqualFold(loc.Hostname(), u.Hostname()) {
+		return "", false
+	}
+
+	prefix := strings.TrimSuffix(loc.Path, "/")
+
+	if prefix == "" {
+		return u.Path, true
+	}
+
+	if u.Path != prefix && !strings.HasPrefix(u.Path, prefix+"/") {
+		return "", false
+	}
+
+	return strings.TrimPrefix(u.Path, prefix), true
+}
+
+// hostHeader returns the host of u as it would be sent in a Host header, excluding the port where it is the default
+// for the scheme of u
+func hostHeader(u *url.URL) string {
+	if h, p, err := net.SplitHostPort(u.Host); err == nil && p == defaultPort(u.Scheme) {
+		return h
+	}
+
+	return u.Host
+}
+
+func defaultPort(scheme string) string {
+	if strings.EqualFold(scheme, "https") {
+		return "443"
+	}
+
+	return "80"
+}
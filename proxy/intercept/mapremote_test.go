@@ -0,0 +1,100 @@
+package intercept
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestMapRemote(t *testing.T) {
+	test := func(t *testing.T, rm Remote, url, expURL, expHost string) {
+		i, err := MapRemote("remote", rm)
+
+		if err != nil {
+			t.Fatalf("expected no error creating map remote intercept, got [%v]", err)
+		}
+
+		rq, _ := http.NewRequest(http.MethodGet, url, nil)
+		rq, _, err = Request(NewExchange("127.0.0.1:50000", 0), rq, map[int]*Intercept{1: i})
+
+		if err != nil {
+			t.Fatalf("expected no error applying intercept, got [%v]", err)
+		}
+
+		if rq.URL.String() != expURL || rq.Host != expHost {
+			t.Fatalf("expected url [%v] and host [%v], got [%v] and [%v]", expURL, expHost, rq.URL.String(), rq.Host)
+		}
+	}
+
+	t.Run("Host", func(t *testing.T) {
+		test(t, Remote{From: "api.prod.test.com", To: "http://localhost:9000"}, "https://api.prod.test.com:443/v1/users?q=1", "http://localhost:9000/v1/users?q=1", "localhost:9000")
+	})
+
+	t.Run("PathPrefix", func(t *testing.T) {
+		test(t, Remote{From: "https://api.prod.test.com/v1", To: "localhost:9000/api/v2/"}, "https://api.prod.test.com/v1/users", "https://localhost:9000/api/v2/users", "localhost:9000")
+	})
+
+	t.Run("PathBoundary", func(t *testing.T) {
+		test(t, Remote{From: "api.prod.test.com/v1", To: "localhost:9000"}, "https://api.prod.test.com/v10/users", "https://api.prod.test.com/v10/users", "api.prod.test.com")
+	})
+
+	t.Run("SchemeMismatch", func(t *testing.T) {
+		test(t, Remote{From: "http://api.prod.test.com", To: "localhost:9000"}, "https://api.prod.test.com/", "https://api.prod.test.com/", "api.prod.test.com")
+	})
+
+	t.Run("Port", func(t *testing.T) {
+		test(t, Remote{From: "https://api.prod.test.com:443", To: "localhost:9000"}, "https://api.prod.test.com/", "https://localhost:9000/", "localhost:9000")
+	})
+
+	t.Run("KeepPort", func(t *testing.T) {
+		test(t, Remote{From: "api.prod.test.com", To: "staging.test.com"}, "https://api.prod.test.com:8443/v1", "https://staging.test.com:8443/v1", "staging.test.com:8443")
+	})
+
+	t.Run("DefaultPort", func(t *testing.T) {
+		test(t, Remote{From: "api.prod.test.com", To: "http://staging.test.com"}, "https://api.prod.test.com:443/v1", "http://staging.test.com/v1", "staging.test.com")
+	})
+
+	t.Run("PreserveHost", func(t *testing.T) {
+		test(t, Remote{From: "api.prod.test.com", To: "http://localhost:9000", PreserveHost: true}, "https://api.prod.test.com:443/", "http://localhost:9000/", "api.prod.test.com")
+	})
+
+	t.Run("OverrideHost", func(t *testing.T) {
+		test(t, Remote{From: "api.prod.test.com", To: "http://localhost:9000", PreserveHost: true, Host: "override.test.com"}, "https://api.prod.test.com/", "http://localhost:9000/", "override.test.com")
+	})
+
+	t.Run("ClientHost", func(t *testing.T) {
+		test := func(t *testing.T, rm Remote, expHost string) {
+			i, _ := MapRemote("remote", rm)
+
+			rq, _ := http.NewRequest(http.MethodGet, "http://10.0.0.1/", nil)
+			rq.Host = "api.prod.test.com"
+
+			if rq, _, _ = Request(NewExchange("127.0.0.1:50000", 0), rq, map[int]*Intercept{1: i}); rq.Host != expHost {
+				t.Fatalf("expected host [%v], got [%v]", expHost, rq.Host)
+			}
+		}
+
+		test(t, Remote{From: "10.0.0.1", To: "localhost:9000"}, "localhost:9000")
+		test(t, Remote{From: "10.0.0.1", To: "localhost:9000", PreserveHost: true}, "api.prod.test.com")
+		test(t, Remote{From: "10.0.0.2", To: "localhost:9000"}, "api.prod.test.com")
+	})
+
+	t.Run("InterceptHost", func(t *testing.T) {
+		i, _ := MapRemote("remote", Remote{From: "api.prod.test.com", To: "localhost:9000"})
+		host := NewIntercept("host", MatchAllRequests, MatchAllResponses,
+			func(r *ProxyRequest) error { r.Host = "override.test.com"; return nil },
+			func(r *ProxyResponse) error { return nil },
+		)
+
+		rq, _ := http.NewRequest(http.MethodGet, "https://api.prod.test.com/", nil)
+
+		if rq, _, _ = Request(NewExchange("127.0.0.1:50000", 0), rq, map[int]*Intercept{1: host, 2: i}); rq.Host != "override.test.com" {
+			t.Fatalf("expected host [override.test.com] set by a prior intercept, got [%v]", rq.Host)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		if _, err := MapRemote("remote", Remote{From: "/path-only", To: "localhost"}); err == nil {
+			t.Fatalf("expected error creating map remote intercept without a from host")
+		}
+	})
+}
@@ -0,0 +1,18 @@
+package intercept
+
+import (
+	"net/http"
+	"time"
+)
+
+// Mock returns an *Intercept that responds to requests matching mrq with a response of the specified status code, header and body,
+// after the specified delay, without contacting the upstream host. The response remains subject to any matching response intercepts
+func Mock(label string, mrq MatchRequestFunc, statusCode int, header http.Header, body []byte, delay time.Duration) *Intercept {
+	return NewIntercept(label, mrq, func(*ProxyRequest, *ProxyResponse) (bool, error) { return false, nil },
+		func(r *ProxyRequest) error {
+			r.Response, r.Delay = NewResponse(statusCode, header.Clone(), append([]byte(nil), body...)), delay
+			return nil
+		},
+		func(r *ProxyResponse) error { return nil },
+	)
+}
@@ -1,48 +1,123 @@
 package intercept
 
 import (
+	"bytes"
 	"comradequinn/hflow/log"
 	"comradequinn/hflow/proxy/internal/codec"
 	"comradequinn/hflow/proxy/internal/copy"
 	"crypto/tls"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // ProxyRequest represents a http.ProxyRequest being currently processed by proxy
 type ProxyRequest struct {
+	Exchange
 	URL    url.URL
 	Method string
 	Header http.Header
 	Body   []byte
+	// Host, where set, is sent as the Host header in place of the host of URL. It is set to the Host header sent by the
+	// client where that differs from the host of URL, such as where the request is sent to the original destination
+	// address of a transparent or SOCKS connection, so that the upstream host receives the Host header the client sent
+	Host string
+	// Response, where set by a RequestFunc, is returned to the client in place of a response from the upstream host,
+	// which is never contacted. It remains subject to any matching response intercepts
+	Response *ProxyResponse
+	// Delay, where set by a RequestFunc, is the duration to wait before the request is sent upstream or Response is returned
+	Delay time.Duration
+
+	stream        io.ReadCloser
+	contentLength int64
+	clientHost    string
 }
 
-func newProxyRequest(hr *http.Request) (*ProxyRequest, error) {
-	r := ProxyRequest{Header: http.Header{}, Method: hr.Method}
+// newProxyRequest returns a *ProxyRequest describing hr. The body is read only where hr provides a copy of it through
+// GetBody, as those returned by Request do where their body was buffered, so that streamed bodies are left unread
+func newProxyRequest(x Exchange, hr *http.Request) (*ProxyRequest, error) {
+	r := requestHead(x, hr)
+
+	if hr.GetBody == nil {
+		return r, nil
+	}
+
+	b, err := hr.GetBody()
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to copy request body: [%v]", err)
+	}
+
+	defer b.Close()
+
+	if r.Body, err = io.ReadAll(b); err != nil {
+		return nil, fmt.Errorf("unable to read request body: [%v]", err)
+	}
+
+	return r, nil
+}
+
+// requestHead returns a *ProxyRequest describing hr, without its body
+func requestHead(x Exchange, hr *http.Request) *ProxyRequest {
+	r := ProxyRequest{Exchange: x, Header: http.Header{}, Method: hr.Method}
 
 	r.URL = *hr.URL
 
+	if hr.Host != "" && hr.Host != hr.URL.Host {
+		r.Host, r.clientHost = hr.Host, hr.Host
+	}
+
 	for k, v := range hr.Header {
 		hv := strings.Join(v, " ")
 		r.Header.Set(k, hv)
 	}
 
-	var err error
+	return &r
+}
 
-	if r.Body, err = copy.CloserToBytes(&hr.Body); err != nil {
-		return nil, fmt.Errorf("unable to read request body: [%v]", err)
+// NewResponse returns a *ProxyResponse with the specified status code, header and body suitable for assignment to
+// ProxyRequest.Response. A nil header is treated as an empty header
+func NewResponse(statusCode int, header http.Header, body []byte) *ProxyResponse {
+	if header == nil {
+		header = http.Header{}
+	}
+
+	return &ProxyResponse{
+		Header:     header,
+		Body:       body,
+		Status:     fmt.Sprintf("%v %v", statusCode, http.StatusText(statusCode)),
+		StatusCode: statusCode,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
 	}
+}
 
-	return &r, nil
+// Clone returns a copy of r that shares no headers or body with it
+func (r *ProxyRequest) Clone() *ProxyRequest {
+	c := *r
+	c.Header, c.Body, c.stream = r.Header.Clone(), append([]byte(nil), r.Body...), nil
+
+	return &c
 }
 
 func (r *ProxyRequest) http() (*http.Request, error) {
-	nr, err := http.NewRequest(r.Method, r.URL.String(), copy.BytesToCloser(r.Body))
+	nr, err := http.NewRequest(r.Method, r.URL.String(), bytes.NewReader(r.Body))
 
 	if err == nil {
 		copy.Header(r.Header, nr.Header)
+
+		if r.Host != "" {
+			nr.Host = r.Host
+		}
+
+		if r.stream != nil {
+			nr.Body, nr.ContentLength, nr.GetBody = r.stream, r.contentLength, nil
+		}
 	}
 
 	return nr, err
@@ -50,6 +125,7 @@ func (r *ProxyRequest) http() (*http.Request, error) {
 
 // ProxyResponse represents a http.Request being currently processed by proxy
 type ProxyResponse struct {
+	Exchange
 	Header     http.Header
 	Body       []byte
 	Status     string
@@ -59,10 +135,18 @@ type ProxyResponse struct {
 	ProtoMinor int
 	Request    *http.Request
 	TLS        *tls.ConnectionState
+	// Trailer holds the trailers of the response. Where the body is streamed, they are set once it has been read in full
+	Trailer http.Header
+
+	encodedSize      int
+	stream           io.ReadCloser
+	contentLength    int64
+	transferEncoding []string
 }
 
-func newProxyResponse(hr *http.Response) (*ProxyResponse, error) {
-	r := ProxyResponse{Header: http.Header{}}
+// newProxyResponse returns a *ProxyResponse describing hr, without its body
+func newProxyResponse(x Exchange, hr *http.Response) *ProxyResponse {
+	r := ProxyResponse{Exchange: x, Header: http.Header{}, Trailer: http.Header{}}
 
 	copy.Header(hr.Header, r.Header)
 
@@ -71,33 +155,58 @@ func newProxyResponse(hr *http.Response) (*ProxyResponse, error) {
 	r.Status, r.StatusCode, r.Proto, r.ProtoMajor, r.ProtoMinor, r.Request, r.TLS =
 		hr.Status, hr.StatusCode, hr.Proto, hr.ProtoMajor, hr.ProtoMinor, hr.Request, hr.TLS
 
-	var err error
+	return &r
+}
 
-	if r.Body, err = copy.CloserToBytes(&hr.Body); err != nil {
-		return nil, fmt.Errorf("unable to read response body: [%v]", err)
-	}
+// decode sets the body of r to b, decoded as per the content-encoding header of r
+func (r *ProxyResponse) decode(b []byte) error {
+	r.Body, r.encodedSize = b, len(b)
 
 	ct := r.Header.Get("Content-Encoding")
 
-	if codec.Supported(ct) {
-		log.Printf(1, "decoding response body from [%v] using scheme from content-encoding header [%v]", ct, hr.Request.URL.String())
+	if !codec.Supported(ct) {
+		return nil
+	}
 
-		if r.Body, err = codec.Decode(ct, r.Body); err != nil {
-			return nil, fmt.Errorf("unable to decode response body using scheme from content-encoding header [%v]: [%v]", ct, err)
-		}
+	log.Printf(1, "decoding response body from [%v] using scheme from content-encoding header [%v]", ct, r.Request.URL.String())
+
+	db, err := codec.Decode(ct, b)
+
+	if err != nil {
+		return fmt.Errorf("unable to decode response body using scheme from content-encoding header [%v]: [%v]", ct, err)
 	}
 
-	return &r, nil
+	r.Body = db
+
+	return nil
+}
+
+// Clone returns a copy of r that shares no headers or body with it
+func (r *ProxyResponse) Clone() *ProxyResponse {
+	c := *r
+	c.Header, c.Body, c.Trailer, c.stream = r.Header.Clone(), append([]byte(nil), r.Body...), r.Trailer.Clone(), nil
+
+	return &c
 }
 
 func (r *ProxyResponse) http() (*http.Response, error) {
-	hr := http.Response{Header: http.Header{}}
+	hr := http.Response{Header: http.Header{}, Trailer: r.Trailer}
 
 	copy.Header(r.Header, hr.Header)
 
 	hr.Status, hr.StatusCode, hr.Proto, hr.ProtoMajor, hr.ProtoMinor, hr.Request, hr.TLS =
 		r.Status, r.StatusCode, r.Proto, r.ProtoMajor, r.ProtoMinor, r.Request, r.TLS
 
+	if r.stream != nil {
+		hr.Body, hr.ContentLength, hr.TransferEncoding = r.stream, r.contentLength, r.transferEncoding
+
+		if hr.ContentLength < 0 {
+			hr.TransferEncoding = []string{"chunked"}
+		}
+
+		return &hr, nil
+	}
+
 	var err error
 	ct := r.Header.Get("Content-Encoding")
 
@@ -111,5 +220,44 @@ func (r *ProxyResponse) http() (*http.Response, error) {
 
 	hr.Body, hr.ContentLength = copy.BytesToCloser(r.Body), int64(len(r.Body))
 
+	if len(r.Trailer) > 0 {
+		hr.ContentLength, hr.TransferEncoding = -1, []string{"chunked"}
+		hr.Header.Del("Content-Length")
+
+		return &hr, nil
+	}
+
+	if cl := hr.Header.Get("Content-Length"); cl != "" && r.bodyAllowed() && cl != strconv.Itoa(len(r.Body)) {
+		hr.Header.Set("Content-Length", strconv.Itoa(len(r.Body)))
+	}
+
 	return &hr, nil
 }
+
+// bodyAllowed returns false where r cannot have a body, being a response to a HEAD request or having a status of 1xx, 204
+// or 304, and so any Content-Length header describes the body that would otherwise have been sent
+func (r *ProxyResponse) bodyAllowed() bool {
+	if r.Request != nil && r.Request.Method == http.MethodHead {
+		return false
+	}
+
+	return r.StatusCode >= 200 && r.StatusCode != http.StatusNoContent && r.StatusCode != http.StatusNotModified
+}
+
+// trailerReader reads the body of hr, setting trailer to its trailers once it has been read in full, as they are only
+// available then
+type trailerReader struct {
+	io.ReadCloser
+	hr      *http.Response
+	trailer http.Header
+}
+
+func (t trailerReader) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+
+	if err == io.EOF {
+		copy.Header(t.hr.Trailer, t.trailer)
+	}
+
+	return n, err
+}
@@ -0,0 +1,193 @@
+package intercept
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Record describes a complete http exchange; a request and the response received to it
+type Record struct {
+	ID       uint64
+	Start    time.Time
+	End      time.Time
+	Request  *ProxyRequest
+	Response *ProxyResponse
+	// Frames are the WebSocket frames of the connection upgraded by the exchange, where it was upgraded
+	Frames []*Frame
+}
+
+const (
+	bodyBase64  = "base64"
+	bodyOmitted = "omitted"
+)
+
+// pendingTTL is the duration after which a request that has not been correlated with a response is discarded, or after
+// which a response that is awaiting its request is passed on without it
+const pendingTTL = time.Minute * 5
+
+// Recorder returns a PhaseObserve *Intercept that correlates each matched request with its response, by their exchange id,
+// and passes the resulting *Record to f. Where the body of a request is streamed, the request is observed once its body
+// has been sent, which may be after its response, so the *Record is passed once both have been observed. Where the
+// response upgrades the connection to a WebSocket, the *Record is passed once the connection closes, with its frames.
+// Frame payloads beyond the buffer limit, in total, are not recorded
+func Recorder(label string, mrq MatchRequestFunc, mrs MatchResponseFunc, f func(*Record)) *Intercept {
+	type socket struct {
+		rec  *Record
+		size int64
+	}
+
+	pending, sockets, mx := map[uint64]*Record{}, map[uint64]*socket{}, sync.Mutex{}
+
+	complete := func(rec *Record) {
+		if rec.Response.StatusCode == http.StatusSwitchingProtocols && strings.EqualFold(rec.Response.Header.Get("Upgrade"), "websocket") {
+			mx.Lock()
+			sockets[rec.ID] = &socket{rec: rec}
+			mx.Unlock()
+
+			return
+		}
+
+		f(rec)
+	}
+
+	return NewIntercept(label, mrq, mrs,
+		func(r *ProxyRequest) error {
+			mx.Lock()
+
+			expired := []*Record{}
+
+			for id, rec := range pending {
+				if time.Since(rec.Start) > pendingTTL {
+					if rec.Response != nil {
+						expired = append(expired, rec)
+					}
+
+					delete(pending, id)
+				}
+			}
+
+			rec := pending[r.ID]
+
+			if rec == nil {
+				pending[r.ID] = &Record{ID: r.ID, Start: r.Start, Request: r.Clone()}
+			} else {
+				rec.Request = r.Clone()
+				delete(pending, r.ID)
+			}
+
+			mx.Unlock()
+
+			for _, e := range expired {
+				complete(e)
+			}
+
+			if rec != nil {
+				complete(rec)
+			}
+
+			return nil
+		},
+		func(rs *ProxyResponse) error {
+			mx.Lock()
+			rec := pending[rs.ID]
+
+			if rec != nil {
+				delete(pending, rs.ID)
+				mx.Unlock()
+
+				rec.End, rec.Response = rs.End, rs.Clone()
+				complete(rec)
+
+				return nil
+			}
+
+			rec = &Record{ID: rs.ID, Start: rs.Start, End: rs.End, Request: &ProxyRequest{Exchange: rs.Exchange, Header: http.Header{}}, Response: rs.Clone()}
+
+			if rs.Request != nil {
+				rec.Request.Method, rec.Request.URL, rec.Request.Header = rs.Request.Method, *rs.Request.URL, rs.Request.Header.Clone()
+
+				if rs.Request.GetBody == nil && hasBody(rs.Request.Body) {
+					pending[rs.ID] = rec
+					mx.Unlock()
+
+					return nil
+				}
+			}
+
+			mx.Unlock()
+
+			complete(rec)
+
+			return nil
+		},
+	).WithPhase(PhaseObserve).WithFrames(
+		func(fr *Frame) error {
+			mx.Lock()
+			defer mx.Unlock()
+
+			if s := sockets[fr.ID]; s != nil {
+				c := fr.Clone()
+
+				if s.size += int64(len(c.Payload)); s.size > BufferLimit() {
+					c.Payload = nil
+				}
+
+				s.rec.Frames = append(s.rec.Frames, c)
+			}
+
+			return nil
+		},
+		func(x Exchange) {
+			mx.Lock()
+			s := sockets[x.ID]
+			delete(sockets, x.ID)
+			mx.Unlock()
+
+			if s != nil {
+				f(s.rec)
+			}
+		},
+	)
+}
+
+// Formats are the names of the formats that records can be written in by WriteRecords
+var Formats = []string{"text", "jsonl", "har"}
+
+// WriteRecords writes recs to w in the specified format, which must be one of Formats. Unless binary is set to true, non-text
+// bodies are omitted. If limit is greater than or equal to 0, text bodies are capped at that number of bytes
+func WriteRecords(w io.Writer, format string, recs []*Record, binary bool, limit int) error {
+	switch format {
+	case "text":
+		return WriteText(w, recs, binary, limit)
+	case "jsonl":
+		return WriteJSONL(w, recs, binary, limit)
+	case "har":
+		return WriteHAR(w, recs, binary, limit)
+	default:
+		return fmt.Errorf("unsupported format [%v]", format)
+	}
+}
+
+// captureBody returns b as it should be written to a capture, the encoding that was applied to it and whether it was truncated.
+// Unless binary is set to true, non-text bodies are omitted. If limit is greater than or equal to 0, text bodies are capped at that
+// number of bytes
+func captureBody(b []byte, text, binary bool, limit int) (string, string, bool) {
+	if !text {
+		if !binary {
+			return "", bodyOmitted, false
+		}
+
+		return base64.StdEncoding.EncodeToString(b), bodyBase64, false
+	}
+
+	if limit >= 0 && len(b) > limit {
+		return string(b[:limit]), "", true
+	}
+
+	return string(b), "", false
+}
@@ -0,0 +1,53 @@
+package intercept
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	t.Run("ResponseBeforeRequest", func(t *testing.T) {
+		recorded := []*Record{}
+		is := map[int]*Intercept{1: Recorder("recorder", MatchAllRequests, MatchAllResponses, func(rec *Record) { recorded = append(recorded, rec) })}
+		x := NewExchange("127.0.0.1:50000", 0)
+
+		rq, _ := http.NewRequest(http.MethodPost, "http://www.test.com/upload", nil)
+		rq.Body, rq.ContentLength = io.NopCloser(strings.NewReader("rq-body")), int64(len("rq-body"))
+
+		rq, _, err := Request(x, rq, is)
+
+		if err != nil {
+			t.Fatalf("expected no error intercepting request, got [%v]", err)
+		}
+
+		hrs := &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Header: http.Header{}, Body: io.NopCloser(strings.NewReader("rs-body")), ContentLength: int64(len("rs-body")), Request: rq}
+
+		rs, err := Response(x, rq, hrs, is)
+
+		if err != nil {
+			t.Fatalf("expected no error intercepting response, got [%v]", err)
+		}
+
+		io.ReadAll(rs.Body)
+		rs.Body.Close()
+
+		if len(recorded) != 0 {
+			t.Fatalf("expected no record before the request body is sent, got [%v]", len(recorded))
+		}
+
+		io.ReadAll(rq.Body)
+		rq.Body.Close()
+
+		if len(recorded) != 1 {
+			t.Fatalf("expected [1] record once the request body is sent, got [%v]", len(recorded))
+		}
+
+		rec := recorded[0]
+
+		if rec.Request == nil || string(rec.Request.Body) != "rq-body" || rec.Response == nil || string(rec.Response.Body) != "rs-body" {
+			t.Fatalf("expected record with request body [rq-body] and response body [rs-body], got [%+v]", rec)
+		}
+	})
+}
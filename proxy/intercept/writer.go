@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
 )
 
 // Writer writes
@@ -15,84 +17,161 @@ import (
 //
 // Unless binary is set to true, only text-based mime-type bodies are written to
 // If limit is greater than or equal to 0, then text response body writes are capped at that number of bytes
+//
+// Each request and response is written with the id of the exchange it belongs to so that concurrent traffic can be reconstructed.
+// The WebSocket frames of connections upgraded by matched requests are written likewise, as they are received.
+// The returned Intercept is applied in PhaseObserve so that it writes traffic as modified by other intercepts
 func Writer(label string, mrq MatchRequestFunc, mrs MatchResponseFunc, binary bool, limit int, w io.Writer) *Intercept {
-	writeHTTP := func(h http.Header, b []byte, sb *strings.Builder) error {
-		const delim = "__________________________________________________________________________________________________________\n\n"
-		contentType, textContentTypes := "", []string{"text/", "/json", "xml", "/javascript", "urlencoded"}
-
-		for k, vs := range h {
-			if k == "Content-Type" {
-				contentType = strings.Split(vs[0], ";")[0]
+	write := func(s string) {
+		go func() {
+			if _, err := w.Write([]byte(s)); err != nil {
+				log.Printf(0, "unable to write to io.Writer during writer intercept labelled [%v]: [%v]", label, err)
 			}
+		}()
+	}
 
-			sb.WriteString(fmt.Sprintf("%v: ", k))
-			sb.WriteString(strings.Join(vs, ","))
+	return NewIntercept(label, mrq, mrs,
+		func(r *ProxyRequest) error {
+			write(textRequest(r, binary, limit))
+			return nil
+		},
+		func(r *ProxyResponse) error {
+			write(textResponse(r, r.Request.Method, r.Request.URL, binary, limit))
+			return nil
+		},
+	).WithPhase(PhaseObserve).WithFrames(
+		func(f *Frame) error {
+			write(textFrame(f, binary, limit))
+			return nil
+		},
+		nil,
+	)
+}
 
-			sb.WriteString("\n")
-		}
+// WriteText writes each of recs to w in the text format, as per Writer
+func WriteText(w io.Writer, recs []*Record, binary bool, limit int) error {
+	for _, rec := range recs {
+		rq, rs := Text(rec, binary, limit)
 
-		if limit >= 0 && len(b) > limit {
-			b = b[:limit]
+		if _, err := io.WriteString(w, rq+rs); err != nil {
+			return fmt.Errorf("unable to write exchange [%v] as text: [%v]", rec.ID, err)
 		}
+	}
 
-		body := string(b)
+	return nil
+}
 
-		if contentType != "" {
-			text := false
+// Text returns the request and response of rec in the text format, as per Writer. Any WebSocket frames are included
+// with the response
+func Text(rec *Record, binary bool, limit int) (string, string) {
+	rs := textResponse(rec.Response, rec.Request.Method, &rec.Request.URL, binary, limit)
 
-			for _, tct := range textContentTypes {
-				if strings.Contains(contentType, tct) {
-					text = true
-					break
-				}
-			}
+	for _, f := range rec.Frames {
+		rs += textFrame(f, binary, limit)
+	}
 
-			if !text && !binary {
-				body = "[binary data]"
-			}
-		}
+	return textRequest(rec.Request, binary, limit), rs
+}
 
-		go func() {
-			if len(body) > 0 {
-				sb.WriteString("\n" + body + "\n")
-			}
+func textRequest(r *ProxyRequest, binary bool, limit int) string {
+	sb := strings.Builder{}
 
-			sb.WriteString(delim)
+	sb.WriteString(fmt.Sprintf(">>> #%v %v %v", r.ID, r.Method, r.URL.String()))
 
-			if _, err := w.Write([]byte(sb.String())); err != nil {
-				log.Printf(0, "unable to write to io.Writer during writer intercept labelled [%v]: [%v]", label, err)
-			}
-		}()
+	if r.Proto != "" {
+		sb.WriteString(" " + r.Proto)
+	}
+
+	sb.WriteString("\n")
+	sb.WriteString(fmt.Sprintf("client %v tunnel %v at %v\n\n", r.ClientAddr, r.TunnelID, r.Start.Format(time.RFC3339Nano)))
+
+	b, text := grpcBody(r.URL.Path, r.Header, r.Body, false)
+	textHTTP(&sb, r.Header, b, text, binary, limit, nil)
+
+	return sb.String()
+}
+
+func textResponse(r *ProxyResponse, method string, u *url.URL, binary bool, limit int) string {
+	sb := strings.Builder{}
 
-		return nil
+	sb.WriteString(fmt.Sprintf("<<< #%v %v from %v %v\n", r.ID, r.Status, method, u.String()))
+	sb.WriteString(fmt.Sprintf("client %v tunnel %v at %v after %v\n\n", r.ClientAddr, r.TunnelID, r.End.Format(time.RFC3339Nano), r.End.Sub(r.Start)))
+
+	b, text := grpcBody(u.Path, r.Header, r.Body, true)
+	textHTTP(&sb, r.Header, b, text, binary, limit, r.Trailer)
+
+	return sb.String()
+}
+
+func textFrame(f *Frame, binary bool, limit int) string {
+	sb, dir := strings.Builder{}, ">>>"
+
+	if f.Direction == FrameReceived {
+		dir = "<<<"
 	}
 
-	return NewIntercept(label, mrq, mrs,
-		func(r *ProxyRequest) error {
-			sb := strings.Builder{}
+	sb.WriteString(fmt.Sprintf("%v #%v websocket %v frame of %v bytes\n", dir, f.ID, f.Type(), f.Size))
+	sb.WriteString(fmt.Sprintf("client %v tunnel %v at %v\n", f.ClientAddr, f.TunnelID, f.Time.Format(time.RFC3339Nano)))
 
-			sb.WriteString(fmt.Sprintf(">>> %v %v\n\n", r.Method, r.URL.String()))
+	textBody(&sb, isText(frameHeader(f)), f.Payload, binary, limit)
+	sb.WriteString(textDelim)
 
-			err := writeHTTP(r.Header, r.Body, &sb)
+	return sb.String()
+}
 
-			if err != nil {
-				return fmt.Errorf("unable to read request for [%v] in writer intercept labelled [%v]: [%v]", r.URL.String(), label, err)
-			}
+const textDelim = "__________________________________________________________________________________________________________\n\n"
 
-			return nil
-		},
-		func(r *ProxyResponse) error {
-			sb := strings.Builder{}
+// textHTTP writes h, b and, where there are any, trailer to sb followed by a delimiter
+func textHTTP(sb *strings.Builder, h http.Header, b []byte, text, binary bool, limit int, trailer http.Header) {
+	textHeader(sb, h)
+	textBody(sb, text, b, binary, limit)
 
-			sb.WriteString(fmt.Sprintf("<<< %v from %v %v\n\n", r.Status, r.Request.Method, r.Request.URL.String()))
+	if len(trailer) > 0 {
+		sb.WriteString("\n")
+		textHeader(sb, trailer)
+	}
 
-			err := writeHTTP(r.Header, r.Body, &sb)
+	sb.WriteString(textDelim)
+}
 
-			if err != nil {
-				return fmt.Errorf("unable to read response to [%v] in writer intercept labelled [%v]: [%v]", r.Request.URL.String(), label, err)
-			}
+func textHeader(sb *strings.Builder, h http.Header) {
+	for k, vs := range h {
+		sb.WriteString(fmt.Sprintf("%v: ", k))
+		sb.WriteString(strings.Join(vs, ","))
 
-			return nil
-		},
-	)
+		sb.WriteString("\n")
+	}
+}
+
+func textBody(sb *strings.Builder, text bool, b []byte, binary bool, limit int) {
+	if limit >= 0 && len(b) > limit {
+		b = b[:limit]
+	}
+
+	body := string(b)
+
+	if !text && !binary {
+		body = "[binary data]"
+	}
+
+	if len(body) > 0 {
+		sb.WriteString("\n" + body + "\n")
+	}
+}
+
+// isText returns true where h specifies no content-type or a content-type that describes a text-based mime-type
+func isText(h http.Header) bool {
+	contentType, textContentTypes := strings.Split(h.Get("Content-Type"), ";")[0], []string{"text/", "/json", "xml", "/javascript", "urlencoded"}
+
+	if contentType == "" {
+		return true
+	}
+
+	for _, tct := range textContentTypes {
+		if strings.Contains(contentType, tct) {
+			return true
+		}
+	}
+
+	return false
 }
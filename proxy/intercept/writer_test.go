@@ -1,6 +1,7 @@
 package intercept
 
 import (
+	"fmt"
 	"net/http"
 	"strings"
 	"testing"
@@ -13,12 +14,12 @@ func TestWriter(t *testing.T) {
 		rq, _ := http.NewRequest(http.MethodPost, "http://www.test.com/echo/?data=some-qs-data", strings.NewReader(rqbody))
 		rq.Header.Set(rqHdrK, rqHdrV)
 
-		prq, _ := newProxyRequest(rq)
+		prq, _ := newProxyRequest(NewExchange("127.0.0.1:50000", 0), rq)
 
 		i, prs := Writer("testwriter",
 			MatchAllRequests,
 			MatchAllResponses,
-			false, -1, tb), &ProxyResponse{Status: "200 OK", Header: http.Header{rsHdrK: []string{rsHdrV}, "Content-Type": []string{rsContentType}}, Body: []byte(rsbody), Request: rq}
+			false, -1, tb), &ProxyResponse{Exchange: prq.Exchange, Status: "200 OK", Header: http.Header{rsHdrK: []string{rsHdrV}, "Content-Type": []string{rsContentType}}, Body: []byte(rsbody), Request: rq}
 
 		if err := i.request(prq); err != nil {
 			t.Fatalf("expected no error processing request, got [%v]", err)
@@ -35,6 +36,10 @@ func TestWriter(t *testing.T) {
 			t.Fatalf("expected output to contain url [%v], got [%v]", rq.URL.String(), tb.Buffer.String())
 		}
 
+		if id := fmt.Sprintf("#%v ", prq.ID); strings.Count(tb.Buffer.String(), id) != 2 {
+			t.Fatalf("expected output to contain exchange id [%v] for both request and response, got [%v]", id, tb.Buffer.String())
+		}
+
 		if !strings.Contains(tb.Buffer.String(), rqHdrK) || !strings.Contains(tb.Buffer.String(), rqHdrV) {
 			t.Fatalf("expected output to contain request header [%v:%v], got [%v]", rqHdrK, rqHdrV, tb.Buffer.String())
 		}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"sync"
 )
 
 // CloserToString returns the contents of the r as a string and resets r so it can be read again
@@ -16,7 +17,7 @@ func CloserToString(r *io.ReadCloser) (string, error) {
 	return string(b), err
 }
 
-// CloserToBytes returns the contents of the r as a []byte and resets r so it can be read again
+// CloserToBytes returns the contents of the r as a []byte, closes r and resets it so it can be read again
 func CloserToBytes(r *io.ReadCloser) ([]byte, error) {
 	b, err := io.ReadAll(*r)
 
@@ -24,16 +25,132 @@ func CloserToBytes(r *io.ReadCloser) ([]byte, error) {
 		return nil, err
 	}
 
+	(*r).Close()
 	*r = BytesToCloser(b)
 
 	return b, nil
 }
 
+// CloserToBytesN returns the contents of r as a []byte and true where they are no longer than n bytes. Where they are
+// longer, nil and false are returned. In either case, r is reset so it can be read again in full, having been closed
+// where its contents were returned
+func CloserToBytesN(r *io.ReadCloser, n int64) ([]byte, bool, error) {
+	b, err := io.ReadAll(io.LimitReader(*r, n+1))
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	if int64(len(b)) <= n {
+		(*r).Close()
+		*r = BytesToCloser(b)
+		return b, true, nil
+	}
+
+	*r = readCloser{Reader: io.MultiReader(bytes.NewReader(b), *r), Closer: *r}
+
+	return nil, false, nil
+}
+
 // BytesToCloser sets b as the contents of the returned io.ReadCloser leaving it ready to be read
 func BytesToCloser(b []byte) io.ReadCloser {
 	return io.NopCloser(bytes.NewReader(b))
 }
 
+// Tee returns an io.ReadCloser that reads from r and captures up to n bytes of what is read. Once r has been read to
+// its end, or closed, done is called with the bytes captured and true if they are all of the contents of r
+func Tee(r io.ReadCloser, n int64, done func(b []byte, complete bool)) io.ReadCloser {
+	return &tee{r: r, n: n, done: done}
+}
+
+type tee struct {
+	mx        sync.Mutex
+	once      sync.Once
+	r         io.ReadCloser
+	n         int64
+	b         bytes.Buffer
+	eof       bool
+	truncated bool
+	done      func([]byte, bool)
+}
+
+func (t *tee) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+
+	t.mx.Lock()
+
+	if space := t.n - int64(t.b.Len()); int64(n) > space {
+		t.b.Write(p[:space])
+		t.truncated = true
+	} else {
+		t.b.Write(p[:n])
+	}
+
+	t.eof = t.eof || err == io.EOF
+
+	t.mx.Unlock()
+
+	if err == io.EOF {
+		t.finish()
+	}
+
+	return n, err
+}
+
+func (t *tee) Close() error {
+	err := t.r.Close()
+	t.finish()
+
+	return err
+}
+
+func (t *tee) finish() {
+	t.once.Do(func() {
+		t.mx.Lock()
+		b, complete := t.b.Bytes(), t.eof && !t.truncated
+		t.mx.Unlock()
+
+		t.done(b, complete)
+	})
+}
+
+// Stream copies r to w, flushing w after each write where it is a http.Flusher, so that w receives the contents of r
+// as they are read
+func Stream(w io.Writer, r io.Reader) (int64, error) {
+	f, _ := w.(http.Flusher)
+	b, written := make([]byte, 32*1024), int64(0)
+
+	for {
+		n, err := r.Read(b)
+
+		if n > 0 {
+			wn, werr := w.Write(b[:n])
+			written += int64(wn)
+
+			if werr != nil {
+				return written, werr
+			}
+
+			if f != nil {
+				f.Flush()
+			}
+		}
+
+		if err == io.EOF {
+			return written, nil
+		}
+
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
 // Header copies the contents of src to dest
 func Header(src http.Header, dest http.Header) {
 	for k, v := range src {
@@ -31,3 +31,65 @@ func TestWriter(t *testing.T) {
 		t.Fatalf("expected [%v] in original closer but got [%v]", data, s)
 	}
 }
+
+type closeRecorder struct{ closed bool }
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestCloserToBytesN(t *testing.T) {
+	test := func(t *testing.T, n int64, expOK bool) {
+		data, c := "some test data", &closeRecorder{}
+		r := io.ReadCloser(readCloser{Reader: strings.NewReader(data), Closer: c})
+
+		b, ok, err := CloserToBytesN(&r, n)
+
+		if err != nil || ok != expOK {
+			t.Fatalf("expected ok of [%v] and no error, got [%v] and [%v]", expOK, ok, err)
+		}
+
+		if c.closed != expOK {
+			t.Fatalf("expected original closer to be closed [%v], got [%v]", expOK, c.closed)
+		}
+
+		if ok && string(b) != data {
+			t.Fatalf("expected [%v] in response but got [%v]", data, string(b))
+		}
+
+		if b, _ := io.ReadAll(r); string(b) != data {
+			t.Fatalf("expected [%v] in original closer but got [%v]", data, string(b))
+		}
+	}
+
+	t.Run("WithinLimit", func(t *testing.T) { test(t, 14, true) })
+	t.Run("ExceedsLimit", func(t *testing.T) { test(t, 13, false) })
+}
+
+func TestTee(t *testing.T) {
+	test := func(t *testing.T, n int64, close bool, expCaptured string, expComplete bool) {
+		data, captured, complete, calls := "some test data", "", false, 0
+
+		r := Tee(io.NopCloser(strings.NewReader(data)), n, func(b []byte, c bool) {
+			captured, complete = string(b), c
+			calls++
+		})
+
+		if close {
+			r.Close()
+		} else if b, _ := io.ReadAll(r); string(b) != data {
+			t.Fatalf("expected [%v] to be read but got [%v]", data, string(b))
+		}
+
+		r.Close()
+
+		if calls != 1 || captured != expCaptured || complete != expComplete {
+			t.Fatalf("expected one call capturing [%v] with complete [%v], got [%v] calls capturing [%v] with complete [%v]", expCaptured, expComplete, calls, captured, complete)
+		}
+	}
+
+	t.Run("Complete", func(t *testing.T) { test(t, 100, false, "some test data", true) })
+	t.Run("Truncated", func(t *testing.T) { test(t, 4, false, "some", false) })
+	t.Run("Closed", func(t *testing.T) { test(t, 100, true, "", false) })
+}
@@ -0,0 +1,540 @@
+package protobuf
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Field types, as per google/protobuf/descriptor.proto
+const (
+	typeDouble   = 1
+	typeFloat    = 2
+	typeInt64    = 3
+	typeUint64   = 4
+	typeInt32    = 5
+	typeFixed64  = 6
+	typeFixed32  = 7
+	typeBool     = 8
+	typeString   = 9
+	typeMessage  = 11
+	typeBytes    = 12
+	typeUint32   = 13
+	typeEnum     = 14
+	typeSfixed32 = 15
+	typeSfixed64 = 16
+	typeSint32   = 17
+	typeSint64   = 18
+
+	labelRepeated = 3
+)
+
+type (
+	message struct {
+		fields   map[int]*fieldDescriptor
+		mapEntry bool
+	}
+
+	fieldDescriptor struct {
+		name     string
+		typ      int
+		typeName string
+		repeated bool
+	}
+
+	method struct {
+		input, output string
+	}
+)
+
+// Descriptors holds the message, enum and gRPC method descriptors of the descriptor sets added to it. It is safe for
+// concurrent use
+type Descriptors struct {
+	mx       sync.RWMutex
+	messages map[string]*message
+	enums    map[string]map[int32]string
+	methods  map[string]method
+}
+
+// NewDescriptors returns an empty *Descriptors
+func NewDescriptors() *Descriptors {
+	return &Descriptors{messages: map[string]*message{}, enums: map[string]map[int32]string{}, methods: map[string]method{}}
+}
+
+// Add adds the descriptors of b, being a serialized google.protobuf.FileDescriptorSet as written by
+// protoc --descriptor_set_out, to d
+func (d *Descriptors) Add(b []byte) error {
+	fs, err := fields(b)
+
+	if err != nil {
+		return fmt.Errorf("invalid descriptor set: [%v]", err)
+	}
+
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	for _, f := range fs {
+		if f.number != 1 || f.wire != wireBytes {
+			continue
+		}
+
+		if err := d.addFile(f.b); err != nil {
+			return fmt.Errorf("invalid file descriptor: [%v]", err)
+		}
+	}
+
+	return nil
+}
+
+func (d *Descriptors) addFile(b []byte) error {
+	fs, err := fields(b)
+
+	if err != nil {
+		return err
+	}
+
+	pkg := ""
+
+	for _, f := range fs {
+		if f.number == 2 && f.wire == wireBytes {
+			pkg = string(f.b)
+		}
+	}
+
+	for _, f := range fs {
+		if f.wire != wireBytes {
+			continue
+		}
+
+		switch f.number {
+		case 4:
+			err = d.addMessage(pkg, f.b)
+		case 5:
+			err = d.addEnum(pkg, f.b)
+		case 6:
+			err = d.addService(pkg, f.b)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *Descriptors) addMessage(scope string, b []byte) error {
+	fs, err := fields(b)
+
+	if err != nil {
+		return err
+	}
+
+	m, name := &message{fields: map[int]*fieldDescriptor{}}, ""
+
+	for _, f := range fs {
+		if f.number == 1 && f.wire == wireBytes {
+			name = qualify(scope, string(f.b))
+		}
+	}
+
+	for _, f := range fs {
+		if f.wire != wireBytes {
+			continue
+		}
+
+		switch f.number {
+		case 2:
+			fd, number, err := fieldDescriptorOf(f.b)
+
+			if err != nil {
+				return err
+			}
+
+			m.fields[number] = fd
+		case 3:
+			err = d.addMessage(name, f.b)
+		case 4:
+			err = d.addEnum(name, f.b)
+		case 7:
+			os, err := fields(f.b)
+
+			if err != nil {
+				return err
+			}
+
+			for _, o := range os {
+				if o.number == 7 && o.wire == wireVarint {
+					m.mapEntry = o.n != 0
+				}
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	d.messages[name] = m
+
+	return nil
+}
+
+func fieldDescriptorOf(b []byte) (*fieldDescriptor, int, error) {
+	fs, err := fields(b)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	fd, number := &fieldDescriptor{}, 0
+
+	for _, f := range fs {
+		switch {
+		case f.number == 1 && f.wire == wireBytes:
+			fd.name = string(f.b)
+		case f.number == 3 && f.wire == wireVarint:
+			number = int(f.n)
+		case f.number == 4 && f.wire == wireVarint:
+			fd.repeated = f.n == labelRepeated
+		case f.number == 5 && f.wire == wireVarint:
+			fd.typ = int(f.n)
+		case f.number == 6 && f.wire == wireBytes:
+			fd.typeName = strings.TrimPrefix(string(f.b), ".")
+		}
+	}
+
+	return fd, number, nil
+}
+
+func (d *Descriptors) addEnum(scope string, b []byte) error {
+	fs, err := fields(b)
+
+	if err != nil {
+		return err
+	}
+
+	name, values := "", map[int32]string{}
+
+	for _, f := range fs {
+		if f.wire != wireBytes {
+			continue
+		}
+
+		switch f.number {
+		case 1:
+			name = qualify(scope, string(f.b))
+		case 2:
+			vs, err := fields(f.b)
+
+			if err != nil {
+				return err
+			}
+
+			vn, vv := "", int32(0)
+
+			for _, v := range vs {
+				switch {
+				case v.number == 1 && v.wire == wireBytes:
+					vn = string(v.b)
+				case v.number == 2 && v.wire == wireVarint:
+					vv = int32(v.n)
+				}
+			}
+
+			values[vv] = vn
+		}
+	}
+
+	d.enums[name] = values
+
+	return nil
+}
+
+func (d *Descriptors) addService(pkg string, b []byte) error {
+	fs, err := fields(b)
+
+	if err != nil {
+		return err
+	}
+
+	name := ""
+
+	for _, f := range fs {
+		if f.number == 1 && f.wire == wireBytes {
+			name = qualify(pkg, string(f.b))
+		}
+	}
+
+	for _, f := range fs {
+		if f.number != 2 || f.wire != wireBytes {
+			continue
+		}
+
+		ms, err := fields(f.b)
+
+		if err != nil {
+			return err
+		}
+
+		mn, m := "", method{}
+
+		for _, mf := range ms {
+			if mf.wire != wireBytes {
+				continue
+			}
+
+			switch mf.number {
+			case 1:
+				mn = string(mf.b)
+			case 2:
+				m.input = strings.TrimPrefix(string(mf.b), ".")
+			case 3:
+				m.output = strings.TrimPrefix(string(mf.b), ".")
+			}
+		}
+
+		d.methods["/"+name+"/"+mn] = m
+	}
+
+	return nil
+}
+
+func qualify(scope, name string) string {
+	if scope == "" {
+		return name
+	}
+
+	return scope + "." + name
+}
+
+// Method returns the full names of the input and output message types of the gRPC method with the path specified, such as
+// /package.Service/Method, and true where d holds a descriptor for it
+func (d *Descriptors) Method(path string) (string, string, bool) {
+	if d == nil {
+		return "", "", false
+	}
+
+	d.mx.RLock()
+	defer d.mx.RUnlock()
+
+	m, ok := d.methods[path]
+
+	return m.input, m.output, ok
+}
+
+// Decode returns message b, of the message type with the full name specified, as an Object with members named as per its
+// descriptor. Fields without a descriptor are decoded as per DecodeRaw. Where d is nil or holds no descriptor for the
+// message type, b is decoded as per DecodeRaw
+func (d *Descriptors) Decode(name string, b []byte) (Object, error) {
+	if d == nil {
+		return DecodeRaw(b)
+	}
+
+	d.mx.RLock()
+	defer d.mx.RUnlock()
+
+	return d.decode(name, b)
+}
+
+func (d *Descriptors) decode(name string, b []byte) (Object, error) {
+	m, ok := d.messages[name]
+
+	if !ok {
+		return DecodeRaw(b)
+	}
+
+	fs, err := fields(b)
+
+	if err != nil {
+		return nil, err
+	}
+
+	o := Object{}
+
+	for _, f := range fs {
+		fd, ok := m.fields[f.number]
+
+		if !ok {
+			o.set(strconv.Itoa(f.number), raw(f), false)
+			continue
+		}
+
+		if fd.typ == typeMessage && d.messages[fd.typeName] != nil && d.messages[fd.typeName].mapEntry && f.wire == wireBytes {
+			if err := d.setMapEntry(&o, fd, f.b); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		if f.wire == wireBytes && fd.repeated && packable(fd.typ) {
+			vs, err := unpack(fd.typ, f.b)
+
+			if err != nil {
+				return nil, fmt.Errorf("invalid packed field [%v]: [%v]", fd.name, err)
+			}
+
+			for _, v := range vs {
+				o.set(fd.name, d.scalar(fd, v), fd.repeated)
+			}
+
+			continue
+		}
+
+		v, err := d.value(fd, f)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid field [%v]: [%v]", fd.name, err)
+		}
+
+		o.set(fd.name, v, fd.repeated)
+	}
+
+	return o, nil
+}
+
+// setMapEntry sets the key and value of the map entry b as a member of the Object of the map field fd within o
+func (d *Descriptors) setMapEntry(o *Object, fd *fieldDescriptor, b []byte) error {
+	e, err := d.decode(fd.typeName, b)
+
+	if err != nil {
+		return err
+	}
+
+	var k, v interface{} = "", nil
+
+	for _, m := range e {
+		switch m.key {
+		case "key":
+			k = m.value
+		case "value":
+			v = m.value
+		}
+	}
+
+	for i := range *o {
+		if mo, ok := (*o)[i].value.(Object); ok && (*o)[i].key == fd.name {
+			mo.set(fmt.Sprint(k), v, false)
+			(*o)[i].value = mo
+
+			return nil
+		}
+	}
+
+	o.set(fd.name, Object{{key: fmt.Sprint(k), value: v}}, false)
+
+	return nil
+}
+
+// value returns the value of f as described by fd
+func (d *Descriptors) value(fd *fieldDescriptor, f field) (interface{}, error) {
+	switch fd.typ {
+	case typeString:
+		if f.wire == wireBytes {
+			return string(f.b), nil
+		}
+	case typeBytes:
+		if f.wire == wireBytes {
+			return base64.StdEncoding.EncodeToString(f.b), nil
+		}
+	case typeMessage:
+		if f.wire == wireBytes {
+			return d.decode(fd.typeName, f.b)
+		}
+	default:
+		if f.wire == wireType(fd.typ) {
+			return d.scalar(fd, f.n), nil
+		}
+	}
+
+	return raw(f), nil
+}
+
+// scalar returns the varint or fixed value n as described by fd
+func (d *Descriptors) scalar(fd *fieldDescriptor, n uint64) interface{} {
+	switch fd.typ {
+	case typeDouble:
+		return float(math.Float64frombits(n))
+	case typeFloat:
+		return float(float64(math.Float32frombits(uint32(n))))
+	case typeInt64, typeSfixed64:
+		return int64(n)
+	case typeInt32, typeSfixed32:
+		return int32(n)
+	case typeUint32, typeFixed32:
+		return uint32(n)
+	case typeBool:
+		return n != 0
+	case typeSint32, typeSint64:
+		return int64(n>>1) ^ -int64(n&1)
+	case typeEnum:
+		if name, ok := d.enums[fd.typeName][int32(n)]; ok {
+			return name
+		}
+
+		return int32(n)
+	default:
+		return n
+	}
+}
+
+func wireType(typ int) int {
+	switch typ {
+	case typeDouble, typeFixed64, typeSfixed64:
+		return wireFixed64
+	case typeFloat, typeFixed32, typeSfixed32:
+		return wireFixed32
+	case typeString, typeBytes, typeMessage:
+		return wireBytes
+	default:
+		return wireVarint
+	}
+}
+
+func packable(typ int) bool {
+	return wireType(typ) != wireBytes
+}
+
+// unpack returns the values of the packed repeated field b of type typ
+func unpack(typ int, b []byte) ([]uint64, error) {
+	vs, size := []uint64{}, 0
+
+	switch wireType(typ) {
+	case wireFixed64:
+		size = 8
+	case wireFixed32:
+		size = 4
+	}
+
+	for len(b) > 0 {
+		switch size {
+		case 0:
+			v, l := binary.Uvarint(b)
+
+			if l <= 0 {
+				return nil, fmt.Errorf("invalid varint")
+			}
+
+			vs, b = append(vs, v), b[l:]
+		default:
+			if len(b) < size {
+				return nil, fmt.Errorf("truncated value")
+			}
+
+			if size == 8 {
+				vs = append(vs, binary.LittleEndian.Uint64(b))
+			} else {
+				vs = append(vs, uint64(binary.LittleEndian.Uint32(b)))
+			}
+
+			b = b[size:]
+		}
+	}
+
+	return vs, nil
+}
@@ -0,0 +1,202 @@
+// Package protobuf provides functions for decoding protocol buffer messages to JSON, with or without the descriptors of
+// their schema, as per https://protobuf.dev/programming-guides/encoding
+package protobuf
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Wire types of encoded fields
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// field is a single encoded field of a message. The value of varint and fixed fields is held in n, that of length
+// delimited fields in b
+type field struct {
+	number int
+	wire   int
+	n      uint64
+	b      []byte
+}
+
+// fields returns the encoded fields of message b in the order they occur
+func fields(b []byte) ([]field, error) {
+	fs := []field{}
+
+	for len(b) > 0 {
+		tag, l := binary.Uvarint(b)
+
+		if l <= 0 {
+			return nil, fmt.Errorf("invalid field tag")
+		}
+
+		f := field{number: int(tag >> 3), wire: int(tag & 0x7)}
+		b = b[l:]
+
+		if f.number <= 0 || f.number > 536870911 {
+			return nil, fmt.Errorf("invalid field number [%v]", f.number)
+		}
+
+		switch f.wire {
+		case wireVarint:
+			if f.n, l = binary.Uvarint(b); l <= 0 {
+				return nil, fmt.Errorf("invalid varint in field [%v]", f.number)
+			}
+
+			b = b[l:]
+		case wireFixed64:
+			if len(b) < 8 {
+				return nil, fmt.Errorf("truncated fixed64 in field [%v]", f.number)
+			}
+
+			f.n, b = binary.LittleEndian.Uint64(b), b[8:]
+		case wireFixed32:
+			if len(b) < 4 {
+				return nil, fmt.Errorf("truncated fixed32 in field [%v]", f.number)
+			}
+
+			f.n, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		case wireBytes:
+			n, l := binary.Uvarint(b)
+
+			if l <= 0 || n > uint64(len(b)-l) {
+				return nil, fmt.Errorf("invalid length in field [%v]", f.number)
+			}
+
+			f.b, b = b[l:l+int(n)], b[l+int(n):]
+		default:
+			return nil, fmt.Errorf("unsupported wire type [%v] in field [%v]", f.wire, f.number)
+		}
+
+		fs = append(fs, f)
+	}
+
+	return fs, nil
+}
+
+// Object is a JSON object that retains the order in which its members were set
+type Object []member
+
+type member struct {
+	key   string
+	value interface{}
+}
+
+// set sets the member key of o to v. Where repeated is true, or key is already set, the member is an array to which v is
+// appended
+func (o *Object) set(key string, v interface{}, repeated bool) {
+	for i := range *o {
+		if m := &(*o)[i]; m.key == key {
+			if a, ok := m.value.([]interface{}); ok {
+				m.value = append(a, v)
+			} else {
+				m.value = []interface{}{m.value, v}
+			}
+
+			return
+		}
+	}
+
+	if repeated {
+		v = []interface{}{v}
+	}
+
+	*o = append(*o, member{key: key, value: v})
+}
+
+// MarshalJSON writes o as a JSON object with its members in the order they were set
+func (o Object) MarshalJSON() ([]byte, error) {
+	buf := bytes.Buffer{}
+	buf.WriteString("{")
+
+	for i, m := range o {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+
+		k, _ := json.Marshal(m.key)
+		v, err := json.Marshal(m.value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(k)
+		buf.WriteString(":")
+		buf.Write(v)
+	}
+
+	buf.WriteString("}")
+
+	return buf.Bytes(), nil
+}
+
+// DecodeRaw returns message b as an Object without reference to its schema. Members are keyed by field number and their
+// values inferred from their wire types; length delimited fields are decoded as strings where they are printable text,
+// otherwise as nested messages where they are valid messages, otherwise as base64 encoded bytes
+func DecodeRaw(b []byte) (Object, error) {
+	fs, err := fields(b)
+
+	if err != nil {
+		return nil, err
+	}
+
+	o := Object{}
+
+	for _, f := range fs {
+		o.set(strconv.Itoa(f.number), raw(f), false)
+	}
+
+	return o, nil
+}
+
+func raw(f field) interface{} {
+	if f.wire != wireBytes {
+		return f.n
+	}
+
+	if printable(f.b) {
+		return string(f.b)
+	}
+
+	if o, err := DecodeRaw(f.b); err == nil {
+		return o
+	}
+
+	return base64.StdEncoding.EncodeToString(f.b)
+}
+
+func printable(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// float returns f as a float64, or as a string where it cannot be represented in JSON
+func float(f float64) interface{} {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+
+	return f
+}
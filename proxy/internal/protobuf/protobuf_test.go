@@ -0,0 +1,131 @@
+package protobuf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func varint(n uint64) []byte {
+	b := make([]byte, binary.MaxVarintLen64)
+	return b[:binary.PutUvarint(b, n)]
+}
+
+func fieldVarint(number int, n uint64) []byte {
+	return append(varint(uint64(number<<3|wireVarint)), varint(n)...)
+}
+
+func fieldFixed64(number int, n uint64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, n)
+
+	return append(varint(uint64(number<<3|wireFixed64)), b...)
+}
+
+func fieldBytes(number int, bs ...[]byte) []byte {
+	b := bytes.Join(bs, nil)
+	return append(append(varint(uint64(number<<3|wireBytes)), varint(uint64(len(b)))...), b...)
+}
+
+func fieldString(number int, s string) []byte {
+	return fieldBytes(number, []byte(s))
+}
+
+func testJSON(t *testing.T, o Object, expected string) {
+	b, err := json.Marshal(o)
+
+	if err != nil {
+		t.Fatalf("expected no error marshalling object, got [%v]", err)
+	}
+
+	if string(b) != expected {
+		t.Fatalf("expected json [%v], got [%v]", expected, string(b))
+	}
+}
+
+func TestDecodeRaw(t *testing.T) {
+	msg := bytes.Join([][]byte{
+		fieldVarint(1, 150),
+		fieldString(2, "some text"),
+		fieldBytes(3, fieldVarint(1, 1), fieldVarint(1, 2)),
+		fieldBytes(4, []byte{0xFF, 0x00}),
+		fieldFixed64(5, 7),
+	}, nil)
+
+	o, err := DecodeRaw(msg)
+
+	if err != nil {
+		t.Fatalf("expected no error decoding message, got [%v]", err)
+	}
+
+	testJSON(t, o, `{"1":150,"2":"some text","3":{"1":[1,2]},"4":"/wA=","5":7}`)
+
+	if _, err := DecodeRaw(msg[:len(msg)-1]); err == nil {
+		t.Fatalf("expected error decoding truncated message")
+	}
+}
+
+func TestDescriptors(t *testing.T) {
+	field := func(name string, number, label, typ int, typeName string) []byte {
+		return fieldBytes(2, fieldString(1, name), fieldVarint(3, uint64(number)), fieldVarint(4, uint64(label)), fieldVarint(5, uint64(typ)), fieldString(6, typeName))
+	}
+
+	file := bytes.Join([][]byte{
+		fieldString(1, "test.proto"),
+		fieldString(2, "test"),
+		fieldBytes(4,
+			fieldString(1, "Request"),
+			field("name", 1, 1, typeString, ""),
+			field("ids", 2, labelRepeated, typeInt32, ""),
+			field("inner", 3, 1, typeMessage, ".test.Request.Inner"),
+			field("colour", 4, 1, typeEnum, ".test.Colour"),
+			field("counts", 5, labelRepeated, typeMessage, ".test.Request.CountsEntry"),
+			field("offset", 6, 1, typeSint32, ""),
+			field("ratio", 7, 1, typeDouble, ""),
+			fieldBytes(3, fieldString(1, "Inner"), field("ok", 1, 1, typeBool, "")),
+			fieldBytes(3, fieldString(1, "CountsEntry"), field("key", 1, 1, typeString, ""), field("value", 2, 1, typeInt32, ""), fieldBytes(7, fieldVarint(7, 1))),
+		),
+		fieldBytes(5, fieldString(1, "Colour"), fieldBytes(2, fieldString(1, "RED"), fieldVarint(2, 0)), fieldBytes(2, fieldString(1, "BLUE"), fieldVarint(2, 1))),
+		fieldBytes(6, fieldString(1, "Service"), fieldBytes(2, fieldString(1, "Call"), fieldString(2, ".test.Request"), fieldString(3, ".test.Request.Inner"))),
+	}, nil)
+
+	d := NewDescriptors()
+
+	if err := d.Add(fieldBytes(1, file)); err != nil {
+		t.Fatalf("expected no error adding descriptor set, got [%v]", err)
+	}
+
+	in, out, ok := d.Method("/test.Service/Call")
+
+	if !ok || in != "test.Request" || out != "test.Request.Inner" {
+		t.Fatalf("expected method with input [test.Request] and output [test.Request.Inner], got [%v] and [%v]", in, out)
+	}
+
+	msg := bytes.Join([][]byte{
+		fieldString(1, "a name"),
+		fieldBytes(2, varint(1), varint(2), varint(3)),
+		fieldBytes(3, fieldVarint(1, 1)),
+		fieldVarint(4, 1),
+		fieldBytes(5, fieldString(1, "x"), fieldVarint(2, 10)),
+		fieldBytes(5, fieldString(1, "y"), fieldVarint(2, 20)),
+		fieldVarint(6, 3),
+		fieldFixed64(7, math.Float64bits(0.5)),
+		fieldVarint(99, 1),
+	}, nil)
+
+	o, err := d.Decode("test.Request", msg)
+
+	if err != nil {
+		t.Fatalf("expected no error decoding message, got [%v]", err)
+	}
+
+	testJSON(t, o, `{"name":"a name","ids":[1,2,3],"inner":{"ok":true},"colour":"BLUE","counts":{"x":10,"y":20},"offset":-2,"ratio":0.5,"99":1}`)
+
+	if o, err = d.Decode("test.Unknown", fieldVarint(1, 1)); err != nil {
+		t.Fatalf("expected no error decoding message of unknown type, got [%v]", err)
+	}
+
+	testJSON(t, o, `{"1":1}`)
+}
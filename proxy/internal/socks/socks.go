@@ -0,0 +1,311 @@
+// Package socks provides functions for connecting through SOCKS5 proxies, as per RFC 1928 and RFC 1929, and for accepting
+// connections from SOCKS5 and SOCKS4a clients
+package socks
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+)
+
+const (
+	version4 = 0x04
+	version5 = 0x05
+
+	authNone         = 0x00
+	authPassword     = 0x02
+	authUnacceptable = 0xFF
+
+	cmdConnect = 0x01
+
+	replySucceeded           = 0x00
+	replyCmdNotSupported     = 0x07
+	replyAddrTypeUnsupported = 0x08
+
+	reply4Granted  = 0x5A
+	reply4Rejected = 0x5B
+
+	addrIPv4   = 0x01
+	addrDomain = 0x03
+	addrIPv6   = 0x04
+)
+
+// Connect requests that the SOCKS5 proxy at the other end of conn connects to addr, authenticating with the username and
+// password of user where it is not nil. Once Connect returns without error, conn is connected to addr
+func Connect(conn net.Conn, addr string, user *url.Userinfo) error {
+	rq, err := request(addr)
+
+	if err != nil {
+		return err
+	}
+
+	methods := []byte{authNone}
+
+	if user != nil {
+		if pwd, _ := user.Password(); len(user.Username()) > 255 || len(pwd) > 255 {
+			return fmt.Errorf("socks username and password must not exceed 255 bytes")
+		}
+
+		methods = []byte{authNone, authPassword}
+	}
+
+	if _, err := conn.Write(append([]byte{version5, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+
+	b := make([]byte, 2)
+
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return err
+	}
+
+	if b[0] != version5 {
+		return fmt.Errorf("unsupported socks version [%v]", b[0])
+	}
+
+	switch b[1] {
+	case authNone:
+	case authPassword:
+		if user == nil {
+			return fmt.Errorf("socks proxy requires authentication")
+		}
+
+		pwd, _ := user.Password()
+
+		if _, err := conn.Write(append(append(append([]byte{0x01, byte(len(user.Username()))}, user.Username()...), byte(len(pwd))), pwd...)); err != nil {
+			return err
+		}
+
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return err
+		}
+
+		if b[1] != 0x00 {
+			return fmt.Errorf("socks proxy authentication failed")
+		}
+	default:
+		return fmt.Errorf("socks proxy accepted none of the offered authentication methods")
+	}
+
+	if _, err := conn.Write(rq); err != nil {
+		return err
+	}
+
+	b = make([]byte, 4)
+
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return err
+	}
+
+	if b[1] != 0x00 {
+		return fmt.Errorf("socks proxy failed to connect to [%v] with reply [%v]", addr, b[1])
+	}
+
+	_, err = readAddr(conn, b[3])
+
+	return err
+}
+
+// Accept reads a SOCKS5 or SOCKS4a connect request from the client at the other end of rw, which may not authenticate, and
+// returns the address it requested a connection to. Once Accept returns without error, the client has been told that the
+// connection succeeded and may send data for addr on rw
+func Accept(rw io.ReadWriter) (string, error) {
+	b := make([]byte, 1)
+
+	if _, err := io.ReadFull(rw, b); err != nil {
+		return "", err
+	}
+
+	switch b[0] {
+	case version5:
+		return accept5(rw)
+	case version4:
+		return accept4(rw)
+	default:
+		return "", fmt.Errorf("unsupported socks version [%v]", b[0])
+	}
+}
+
+// accept5 reads a SOCKS5 connect request, following its version, from rw and returns the address it requests
+func accept5(rw io.ReadWriter) (string, error) {
+	b := make([]byte, 1)
+
+	if _, err := io.ReadFull(rw, b); err != nil {
+		return "", err
+	}
+
+	methods := make([]byte, b[0])
+
+	if _, err := io.ReadFull(rw, methods); err != nil {
+		return "", err
+	}
+
+	if bytes.IndexByte(methods, authNone) < 0 {
+		rw.Write([]byte{version5, authUnacceptable})
+		return "", fmt.Errorf("socks client offered no acceptable authentication methods")
+	}
+
+	if _, err := rw.Write([]byte{version5, authNone}); err != nil {
+		return "", err
+	}
+
+	reply := func(code byte) error {
+		_, err := rw.Write([]byte{version5, code, 0x00, addrIPv4, 0, 0, 0, 0, 0, 0})
+		return err
+	}
+
+	b = make([]byte, 4)
+
+	if _, err := io.ReadFull(rw, b); err != nil {
+		return "", err
+	}
+
+	if b[1] != cmdConnect {
+		reply(replyCmdNotSupported)
+		return "", fmt.Errorf("unsupported socks command [%v]", b[1])
+	}
+
+	addr, err := readAddr(rw, b[3])
+
+	if err != nil {
+		reply(replyAddrTypeUnsupported)
+		return "", err
+	}
+
+	return addr, reply(replySucceeded)
+}
+
+// accept4 reads a SOCKS4 or SOCKS4a connect request, following its version, from rw and returns the address it requests
+func accept4(rw io.ReadWriter) (string, error) {
+	b := make([]byte, 7)
+
+	if _, err := io.ReadFull(rw, b); err != nil {
+		return "", err
+	}
+
+	reply := func(code byte) error {
+		_, err := rw.Write(append([]byte{0x00, code}, b[1:]...))
+		return err
+	}
+
+	if _, err := readString(rw); err != nil {
+		return "", err
+	}
+
+	if b[0] != cmdConnect {
+		reply(reply4Rejected)
+		return "", fmt.Errorf("unsupported socks command [%v]", b[0])
+	}
+
+	host := net.IP(b[3:]).String()
+
+	if b[3] == 0 && b[4] == 0 && b[5] == 0 && b[6] != 0 {
+		var err error
+
+		if host, err = readString(rw); err != nil {
+			return "", err
+		}
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(b[1:3])))), reply(reply4Granted)
+}
+
+// readString reads a null terminated string of up to 255 bytes from r
+func readString(r io.Reader) (string, error) {
+	s, b := []byte{}, make([]byte, 1)
+
+	for {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", err
+		}
+
+		if b[0] == 0x00 {
+			return string(s), nil
+		}
+
+		if len(s) == 255 {
+			return "", fmt.Errorf("socks string exceeds 255 bytes")
+		}
+
+		s = append(s, b[0])
+	}
+}
+
+// request returns a connect request for addr
+func request(addr string) ([]byte, error) {
+	host, p, err := net.SplitHostPort(addr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := strconv.ParseUint(p, 10, 16)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid port [%v]", p)
+	}
+
+	b := []byte{version5, cmdConnect, 0x00}
+
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("socks host [%v] exceeds 255 bytes", host)
+		}
+
+		b = append(append(b, addrDomain, byte(len(host))), host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		b = append(append(b, addrIPv4), ip4...)
+	} else {
+		b = append(append(b, addrIPv6), ip.To16()...)
+	}
+
+	return append(b, byte(port>>8), byte(port)), nil
+}
+
+// readAddr reads an address of type typ, followed by a port, from r and returns it in host:port form
+func readAddr(r io.Reader, typ byte) (string, error) {
+	var host string
+
+	switch typ {
+	case addrIPv4, addrIPv6:
+		b := make([]byte, net.IPv4len)
+
+		if typ == addrIPv6 {
+			b = make([]byte, net.IPv6len)
+		}
+
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", err
+		}
+
+		host = net.IP(b).String()
+	case addrDomain:
+		l := make([]byte, 1)
+
+		if _, err := io.ReadFull(r, l); err != nil {
+			return "", err
+		}
+
+		b := make([]byte, l[0])
+
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", err
+		}
+
+		host = string(b)
+	default:
+		return "", fmt.Errorf("unsupported address type [%v]", typ)
+	}
+
+	p := make([]byte, 2)
+
+	if _, err := io.ReadFull(r, p); err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(p)))), nil
+}
@@ -0,0 +1,151 @@
+package socks
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestConnect(t *testing.T) {
+	test := func(t *testing.T, addr string, user *url.Userinfo, expectedRq []byte) {
+		client, server := net.Pipe()
+		defer client.Close()
+
+		rcvRq := make(chan []byte, 1)
+
+		go func() {
+			defer server.Close()
+
+			b := make([]byte, 2)
+			io.ReadFull(server, b)
+			methods := make([]byte, b[1])
+			io.ReadFull(server, methods)
+
+			if user != nil {
+				server.Write([]byte{version5, authPassword})
+
+				b = make([]byte, 2)
+				io.ReadFull(server, b)
+				u := make([]byte, b[1]+1)
+				io.ReadFull(server, u)
+				p := make([]byte, u[len(u)-1])
+				io.ReadFull(server, p)
+
+				server.Write([]byte{0x01, 0x00})
+			} else {
+				server.Write([]byte{version5, authNone})
+			}
+
+			rq := make([]byte, len(expectedRq))
+			io.ReadFull(server, rq)
+			rcvRq <- rq
+
+			server.Write([]byte{version5, 0x00, 0x00, addrIPv4, 127, 0, 0, 1, 0x1F, 0x90})
+		}()
+
+		if err := Connect(client, addr, user); err != nil {
+			t.Fatalf("expected no error connecting, got [%v]", err)
+		}
+
+		if rq := <-rcvRq; !bytes.Equal(rq, expectedRq) {
+			t.Fatalf("expected connect request [%v], got [%v]", expectedRq, rq)
+		}
+	}
+
+	t.Run("Domain", func(t *testing.T) {
+		test(t, "test.com:443", nil, append(append([]byte{version5, cmdConnect, 0x00, addrDomain, 8}, "test.com"...), 0x01, 0xBB))
+	})
+
+	t.Run("IPv4WithPassword", func(t *testing.T) {
+		test(t, "10.0.0.1:80", url.UserPassword("user", "pass"), []byte{version5, cmdConnect, 0x00, addrIPv4, 10, 0, 0, 1, 0x00, 0x50})
+	})
+
+	t.Run("TooLong", func(t *testing.T) {
+		long := strings.Repeat("a", 256)
+
+		for _, tc := range []struct {
+			addr string
+			user *url.Userinfo
+		}{
+			{addr: long + ":443"},
+			{addr: "test.com:443", user: url.UserPassword(long, "pass")},
+			{addr: "test.com:443", user: url.UserPassword("user", long)},
+		} {
+			client, server := net.Pipe()
+			server.Close()
+
+			if err := Connect(client, tc.addr, tc.user); err == nil || err == io.ErrClosedPipe {
+				t.Fatalf("expected length error connecting to [%v], got [%v]", tc.addr, err)
+			}
+
+			client.Close()
+		}
+	})
+}
+
+func TestAccept(t *testing.T) {
+	test := func(t *testing.T, rq []byte, expectedAddr string, expectedRs []byte) {
+		client, server := net.Pipe()
+		defer client.Close()
+
+		rcvRs := make(chan []byte, 1)
+
+		go client.Write(rq)
+
+		go func() {
+			rs := make([]byte, len(expectedRs))
+			io.ReadFull(client, rs)
+			rcvRs <- rs
+		}()
+
+		addr, err := Accept(server)
+
+		if expectedAddr != "" && err != nil {
+			t.Fatalf("expected no error accepting, got [%v]", err)
+		}
+
+		if expectedAddr == "" && err == nil {
+			t.Fatalf("expected error accepting, got address [%v]", addr)
+		}
+
+		if addr != expectedAddr {
+			t.Fatalf("expected address [%v], got [%v]", expectedAddr, addr)
+		}
+
+		if rs := <-rcvRs; !bytes.Equal(rs, expectedRs) {
+			t.Fatalf("expected response [%v], got [%v]", expectedRs, rs)
+		}
+	}
+
+	greeting, succeeded := []byte{version5, 1, authNone}, []byte{version5, authNone, version5, replySucceeded, 0x00, addrIPv4, 0, 0, 0, 0, 0, 0}
+
+	t.Run("SOCKS5Domain", func(t *testing.T) {
+		rq, _ := request("test.com:443")
+		test(t, append(greeting, rq...), "test.com:443", succeeded)
+	})
+
+	t.Run("SOCKS5IPv6", func(t *testing.T) {
+		rq, _ := request("[::1]:80")
+		test(t, append(greeting, rq...), "[::1]:80", succeeded)
+	})
+
+	t.Run("SOCKS5Unsupported", func(t *testing.T) {
+		test(t, append(greeting, version5, 0x02, 0x00, addrIPv4, 10, 0, 0, 1, 0x00, 0x50), "",
+			[]byte{version5, authNone, version5, replyCmdNotSupported, 0x00, addrIPv4, 0, 0, 0, 0, 0, 0})
+	})
+
+	t.Run("SOCKS5Auth", func(t *testing.T) {
+		test(t, []byte{version5, 1, authPassword}, "", []byte{version5, authUnacceptable})
+	})
+
+	t.Run("SOCKS4", func(t *testing.T) {
+		test(t, []byte{version4, cmdConnect, 0x00, 0x50, 10, 0, 0, 1, 'u', 0x00}, "10.0.0.1:80", []byte{0x00, reply4Granted, 0x00, 0x50, 10, 0, 0, 1})
+	})
+
+	t.Run("SOCKS4a", func(t *testing.T) {
+		test(t, append([]byte{version4, cmdConnect, 0x01, 0xBB, 0, 0, 0, 1, 0x00}, "test.com\x00"...), "test.com:443", []byte{0x00, reply4Granted, 0x01, 0xBB, 0, 0, 0, 1})
+	})
+}
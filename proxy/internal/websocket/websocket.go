@@ -0,0 +1,116 @@
+// Package websocket provides functions for reading and writing websocket frames, as per RFC 6455
+package websocket
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Opcodes of websocket frames
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xA
+)
+
+// Header describes the header of a websocket frame
+type Header struct {
+	Fin    bool
+	RSV    byte
+	Opcode byte
+	Masked bool
+	Key    [4]byte
+	Length int64
+}
+
+// IsUpgrade returns true where hr requests that its connection be upgraded to a websocket
+func IsUpgrade(hr *http.Request) bool {
+	return strings.EqualFold(hr.Header.Get("Upgrade"), "websocket") && strings.Contains(strings.ToLower(hr.Header.Get("Connection")), "upgrade")
+}
+
+// ReadHeader reads the header of the next frame from r, leaving r ready to read its payload
+func ReadHeader(r io.Reader) (Header, error) {
+	h, b := Header{}, make([]byte, 8)
+
+	if _, err := io.ReadFull(r, b[:2]); err != nil {
+		return h, err
+	}
+
+	h.Fin, h.RSV, h.Opcode, h.Masked = b[0]&0x80 != 0, (b[0]>>4)&0x7, b[0]&0xF, b[1]&0x80 != 0
+
+	switch l := b[1] & 0x7F; l {
+	case 126:
+		if _, err := io.ReadFull(r, b[:2]); err != nil {
+			return h, err
+		}
+
+		h.Length = int64(binary.BigEndian.Uint16(b[:2]))
+	case 127:
+		if _, err := io.ReadFull(r, b); err != nil {
+			return h, err
+		}
+
+		if h.Length = int64(binary.BigEndian.Uint64(b)); h.Length < 0 {
+			return h, fmt.Errorf("invalid frame length [%v]", uint64(h.Length))
+		}
+	default:
+		h.Length = int64(l)
+	}
+
+	if h.Masked {
+		if _, err := io.ReadFull(r, h.Key[:]); err != nil {
+			return h, err
+		}
+	}
+
+	return h, nil
+}
+
+// WriteHeader writes h to w
+func WriteHeader(w io.Writer, h Header) error {
+	b := make([]byte, 2, 14)
+
+	if h.Fin {
+		b[0] |= 0x80
+	}
+
+	b[0] |= (h.RSV&0x7)<<4 | h.Opcode&0xF
+
+	if h.Masked {
+		b[1] |= 0x80
+	}
+
+	switch {
+	case h.Length < 126:
+		b[1] |= byte(h.Length)
+	case h.Length <= 0xFFFF:
+		b[1] |= 126
+		b = append(b, 0, 0)
+		binary.BigEndian.PutUint16(b[2:], uint16(h.Length))
+	default:
+		b[1] |= 127
+		b = append(b, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(b[2:], uint64(h.Length))
+	}
+
+	if h.Masked {
+		b = append(b, h.Key[:]...)
+	}
+
+	_, err := w.Write(b)
+
+	return err
+}
+
+// Mask applies key to b, masking it where it is unmasked or unmasking it where it is masked
+func Mask(key [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= key[i%4]
+	}
+}
@@ -0,0 +1,71 @@
+package websocket
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+)
+
+func TestHeader(t *testing.T) {
+	test := func(t *testing.T, h Header) {
+		b := bytes.Buffer{}
+
+		if err := WriteHeader(&b, h); err != nil {
+			t.Fatalf("expected no error writing header, got [%v]", err)
+		}
+
+		got, err := ReadHeader(&b)
+
+		if err != nil {
+			t.Fatalf("expected no error reading header, got [%v]", err)
+		}
+
+		if got != h {
+			t.Fatalf("expected header [%+v], got [%+v]", h, got)
+		}
+
+		if b.Len() != 0 {
+			t.Fatalf("expected the whole header to be read, [%v] bytes remain", b.Len())
+		}
+	}
+
+	t.Run("Short", func(t *testing.T) { test(t, Header{Fin: true, Opcode: OpText, Length: 125}) })
+	t.Run("Medium", func(t *testing.T) { test(t, Header{Opcode: OpBinary, Length: 65535}) })
+	t.Run("Long", func(t *testing.T) { test(t, Header{Fin: true, Opcode: OpBinary, Length: 65536}) })
+	t.Run("Masked", func(t *testing.T) {
+		test(t, Header{Fin: true, RSV: 4, Opcode: OpClose, Masked: true, Key: [4]byte{1, 2, 3, 4}, Length: 2})
+	})
+}
+
+func TestMask(t *testing.T) {
+	key, data := [4]byte{0x12, 0x34, 0x56, 0x78}, []byte("some test data")
+	b := append([]byte(nil), data...)
+
+	Mask(key, b)
+
+	if bytes.Equal(b, data) {
+		t.Fatalf("expected data to be masked")
+	}
+
+	Mask(key, b)
+
+	if !bytes.Equal(b, data) {
+		t.Fatalf("expected data to be unmasked to [%v], got [%v]", string(data), string(b))
+	}
+}
+
+func TestIsUpgrade(t *testing.T) {
+	test := func(t *testing.T, upgrade, connection string, expected bool) {
+		rq, _ := http.NewRequest(http.MethodGet, "http://www.test.com/", nil)
+		rq.Header.Set("Upgrade", upgrade)
+		rq.Header.Set("Connection", connection)
+
+		if got := IsUpgrade(rq); got != expected {
+			t.Fatalf("expected upgrade of [%v], got [%v]", expected, got)
+		}
+	}
+
+	t.Run("Upgrade", func(t *testing.T) { test(t, "websocket", "keep-alive, Upgrade", true) })
+	t.Run("NoConnection", func(t *testing.T) { test(t, "websocket", "keep-alive", false) })
+	t.Run("OtherProtocol", func(t *testing.T) { test(t, "h2c", "Upgrade", false) })
+}
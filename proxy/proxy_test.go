@@ -1,20 +1,32 @@
 package proxy
 
 import (
+	"bufio"
+	"bytes"
+	"comradequinn/hflow/cert"
 	"comradequinn/hflow/proxy/intercept"
+	"comradequinn/hflow/proxy/internal/socks"
+	"comradequinn/hflow/proxy/internal/websocket"
+	"context"
 	"crypto/tls"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestProxy(t *testing.T) {
 	test := func(t *testing.T, icpt bool, clientTLS *tls.Config, proxyHandler http.HandlerFunc, newStubSvrFunc func(http.Handler) *httptest.Server) {
 		var rcvMethod, rcvPath, rcvQSV, rcvHdrV, rcvBdy, rcvIntHdrV string
+		var rqExchangeID, rsExchangeID uint64
 
 		rsCode, rsHdrK, rsHdrV, rsBdy := http.StatusOK, "rsqHdrK", "rsHdrV", "rsBdy"
 
@@ -48,10 +60,12 @@ func TestProxy(t *testing.T) {
 				intercept.MatchAllResponses,
 				func(r *intercept.ProxyRequest) error {
 					r.Header.Add(intRqHdrK, intRqHdrV)
+					rqExchangeID = r.ID
 					return nil
 				},
 				func(r *intercept.ProxyResponse) error {
 					r.Header.Add(intRsHdrK, intRsHdrV)
+					rsExchangeID = r.ID
 					return nil
 				},
 			))
@@ -84,6 +98,10 @@ func TestProxy(t *testing.T) {
 		if icpt {
 			assert("receive intercepted request header value of", intRqHdrV, rcvIntHdrV)
 			assert("receive intercepted response header value of", intRsHdrV, rs.Header.Get(intRsHdrK))
+
+			if rqExchangeID == 0 || rqExchangeID != rsExchangeID {
+				t.Fatalf("expected request and response to share an exchange id, got [%v] and [%v]", rqExchangeID, rsExchangeID)
+			}
 		}
 	}
 
@@ -93,4 +111,1162 @@ func TestProxy(t *testing.T) {
 	t.Run("InterceptedHTTP", func(t *testing.T) { test(t, true, nil, HTTPHandler(), httptest.NewServer) })
 	t.Run("HTTPS", func(t *testing.T) { test(t, false, tlsCfg, HTTPSHandler(), httptest.NewTLSServer) })
 	t.Run("InterceptedHTTPS", func(t *testing.T) { test(t, true, tlsCfg, HTTPSHandler(), httptest.NewTLSServer) })
+	t.Run("SinglePortHTTP", func(t *testing.T) { test(t, true, nil, Handler(), httptest.NewServer) })
+	t.Run("SinglePortHTTPS", func(t *testing.T) { test(t, true, tlsCfg, Handler(), httptest.NewTLSServer) })
+}
+
+func TestProxyMock(t *testing.T) {
+	test := func(t *testing.T, clientTLS *tls.Config, proxyHandler http.HandlerFunc, newStubSvrFunc func(http.Handler) *httptest.Server) {
+		mockBdy, intRsHdrK, intRsHdrV, contacted := "mockBdy", "intRsHdrK", "intRsHdrV", false
+
+		proxy, client := httptest.NewServer(proxyHandler), http.Client{}
+		proxyURL, _ := url.Parse(proxy.URL)
+
+		client.Transport = &http.Transport{Proxy: http.ProxyURL(proxyURL), TLSClientConfig: clientTLS}
+
+		defer proxy.Close()
+
+		stub := newStubSvrFunc(http.HandlerFunc(func(rs http.ResponseWriter, rcvRq *http.Request) { contacted = true }))
+
+		defer stub.Close()
+
+		mid := SetIntercept(intercept.Mock("test-mock", intercept.MatchAllRequests, http.StatusTeapot, nil, []byte(mockBdy), 0))
+		defer UnsetIntercept(mid)
+
+		rid := SetIntercept(intercept.NewIntercept("test-intercept", intercept.MatchAllRequests, intercept.MatchAllResponses,
+			func(r *intercept.ProxyRequest) error { return nil },
+			func(r *intercept.ProxyResponse) error {
+				r.Header.Add(intRsHdrK, intRsHdrV)
+				return nil
+			},
+		))
+		defer UnsetIntercept(rid)
+
+		rs, err := client.Get(stub.URL + "/mocked/")
+
+		if err != nil {
+			t.Fatalf("expected no error proxying request, got [%v]", err)
+		}
+
+		b, _ := io.ReadAll(rs.Body)
+
+		if contacted {
+			t.Fatalf("expected upstream not to be contacted when response is mocked")
+		}
+
+		if rs.StatusCode != http.StatusTeapot || string(b) != mockBdy {
+			t.Fatalf("expected mock response with status [%v] and body [%v], got [%v] and [%v]", http.StatusTeapot, mockBdy, rs.StatusCode, string(b))
+		}
+
+		if rs.Header.Get(intRsHdrK) != intRsHdrV {
+			t.Fatalf("expected mock response to pass through response intercepts")
+		}
+	}
+
+	t.Run("HTTP", func(t *testing.T) { test(t, nil, HTTPHandler(), httptest.NewServer) })
+	t.Run("HTTPS", func(t *testing.T) { test(t, &tls.Config{InsecureSkipVerify: true}, HTTPSHandler(), httptest.NewTLSServer) })
+}
+
+func TestProxyMapRemote(t *testing.T) {
+	test := func(t *testing.T, clientTLS *tls.Config, proxyHandler http.HandlerFunc, newStubSvrFunc func(http.Handler) *httptest.Server) {
+		var rcvPath, rcvHost string
+		contacted := false
+
+		proxy, client := httptest.NewServer(proxyHandler), http.Client{}
+		proxyURL, _ := url.Parse(proxy.URL)
+
+		client.Transport = &http.Transport{Proxy: http.ProxyURL(proxyURL), TLSClientConfig: clientTLS}
+
+		defer proxy.Close()
+
+		from := newStubSvrFunc(http.HandlerFunc(func(rs http.ResponseWriter, rcvRq *http.Request) { contacted = true }))
+		defer from.Close()
+
+		to := newStubSvrFunc(http.HandlerFunc(func(rs http.ResponseWriter, rcvRq *http.Request) {
+			rcvPath, rcvHost = rcvRq.URL.Path, rcvRq.Host
+		}))
+		defer to.Close()
+
+		fromURL, _ := url.Parse(from.URL)
+
+		icpt, err := intercept.MapRemote("test-map-remote", intercept.Remote{From: fromURL.Host + "/api", To: to.URL + "/v2", PreserveHost: true})
+
+		if err != nil {
+			t.Fatalf("expected no error creating map remote intercept, got [%v]", err)
+		}
+
+		id := SetIntercept(icpt)
+		defer UnsetIntercept(id)
+
+		rs, err := client.Get(from.URL + "/api/users")
+
+		if err != nil || rs.StatusCode != http.StatusOK {
+			t.Fatalf("expected no error proxying request, got [%v]", err)
+		}
+
+		if contacted {
+			t.Fatalf("expected original host not to be contacted when the request is mapped")
+		}
+
+		if rcvPath != "/v2/users" || rcvHost != fromURL.Host {
+			t.Fatalf("expected mapped host to receive path [/v2/users] with host [%v], got [%v] and [%v]", fromURL.Host, rcvPath, rcvHost)
+		}
+	}
+
+	t.Run("HTTP", func(t *testing.T) { test(t, nil, HTTPHandler(), httptest.NewServer) })
+	t.Run("HTTPS", func(t *testing.T) { test(t, &tls.Config{InsecureSkipVerify: true}, HTTPSHandler(), httptest.NewTLSServer) })
+}
+
+func TestProxyMapLocal(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "app.js"), []byte("local-body"), 0644)
+
+	test := func(t *testing.T, clientTLS *tls.Config, proxyHandler http.HandlerFunc, newStubSvrFunc func(http.Handler) *httptest.Server) {
+		proxy, client := httptest.NewServer(proxyHandler), http.Client{Timeout: time.Second * 5}
+		proxyURL, _ := url.Parse(proxy.URL)
+
+		client.Transport = &http.Transport{Proxy: http.ProxyURL(proxyURL), TLSClientConfig: clientTLS}
+
+		defer proxy.Close()
+
+		stub := newStubSvrFunc(http.HandlerFunc(func(rs http.ResponseWriter, rcvRq *http.Request) { rs.Write([]byte("stub-body")) }))
+		defer stub.Close()
+
+		stubURL, _ := url.Parse(stub.URL)
+
+		id := SetIntercept(intercept.MapLocal("test-map-local", stubURL.Scheme+"://"+stubURL.Hostname()+"/static/", dir))
+		defer UnsetIntercept(id)
+
+		get := func(path, expectedBody string) {
+			rs, err := client.Get(stub.URL + path)
+
+			if err != nil || rs.StatusCode != http.StatusOK {
+				t.Fatalf("expected no error proxying request for [%v], got [%v]", path, err)
+			}
+
+			defer rs.Body.Close()
+
+			if b, _ := io.ReadAll(rs.Body); string(b) != expectedBody {
+				t.Fatalf("expected body [%v] for [%v], got [%v]", expectedBody, path, string(b))
+			}
+		}
+
+		get("/static/app.js", "local-body")
+		get("/staticfiles/app.js", "stub-body")
+	}
+
+	t.Run("HTTP", func(t *testing.T) { test(t, nil, HTTPHandler(), httptest.NewServer) })
+	t.Run("HTTPS", func(t *testing.T) { test(t, &tls.Config{InsecureSkipVerify: true}, HTTPSHandler(), httptest.NewTLSServer) })
+}
+
+func TestProxyHTTP2(t *testing.T) {
+	test := func(t *testing.T, h2 bool, expectedProto string) {
+		var rcvBody string
+		recorded := make(chan *intercept.Record, 1)
+
+		proxy, client := httptest.NewServer(HTTPSHandler()), http.Client{Timeout: time.Second * 5}
+		proxyURL, _ := url.Parse(proxy.URL)
+
+		transport := &http.Transport{Proxy: http.ProxyURL(proxyURL), TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, ForceAttemptHTTP2: h2}
+
+		if !h2 {
+			transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		}
+
+		client.Transport = transport
+
+		defer proxy.Close()
+
+		stub := httptest.NewUnstartedServer(http.HandlerFunc(func(rs http.ResponseWriter, rcvRq *http.Request) {
+			b, _ := io.ReadAll(rcvRq.Body)
+			rcvBody = string(b)
+			rs.Write([]byte("response-body"))
+		}))
+		stub.EnableHTTP2 = true
+		stub.StartTLS()
+
+		defer stub.Close()
+
+		id := SetIntercept(intercept.Recorder("test-recorder", intercept.MatchAllRequests, intercept.MatchAllResponses, func(rec *intercept.Record) { recorded <- rec }))
+		defer UnsetIntercept(id)
+
+		rs, err := client.Post(stub.URL, "text/plain", strings.NewReader("request-body"))
+
+		if err != nil || rs.StatusCode != http.StatusOK {
+			t.Fatalf("expected no error proxying request, got [%v]", err)
+		}
+
+		defer rs.Body.Close()
+
+		if rs.Proto != expectedProto {
+			t.Fatalf("expected client to negotiate [%v] with proxy, got [%v]", expectedProto, rs.Proto)
+		}
+
+		if b, _ := io.ReadAll(rs.Body); string(b) != "response-body" || rcvBody != "request-body" {
+			t.Fatalf("expected bodies to be proxied, got request body [%v] and response body [%v]", rcvBody, string(b))
+		}
+
+		if rec := <-recorded; rec.Request.Proto != expectedProto || rec.Request.TunnelID == 0 {
+			t.Fatalf("expected [%v] tunnelled request to be recorded, got [%v] in tunnel [%v]", expectedProto, rec.Request.Proto, rec.Request.TunnelID)
+		}
+	}
+
+	t.Run("HTTP2", func(t *testing.T) { test(t, true, "HTTP/2.0") })
+	t.Run("HTTP1", func(t *testing.T) { test(t, false, "HTTP/1.1") })
+}
+
+func TestProxyGRPC(t *testing.T) {
+	test := func(t *testing.T, h2 bool) {
+		recorded, msg := make(chan *intercept.Record, 1), []byte{0, 0, 0, 0, 3, 0x08, 0x96, 0x01}
+
+		proxy, client := httptest.NewServer(HTTPSHandler()), http.Client{Timeout: time.Second * 5}
+		proxyURL, _ := url.Parse(proxy.URL)
+
+		transport := &http.Transport{Proxy: http.ProxyURL(proxyURL), TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, ForceAttemptHTTP2: h2}
+
+		if !h2 {
+			transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		}
+
+		client.Transport = transport
+
+		defer proxy.Close()
+
+		stub := httptest.NewUnstartedServer(http.HandlerFunc(func(rs http.ResponseWriter, rcvRq *http.Request) {
+			io.Copy(io.Discard, rcvRq.Body)
+			rs.Header().Set("Content-Type", "application/grpc")
+			rs.Write(msg)
+			rs.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+			rs.Header().Set(http.TrailerPrefix+"Grpc-Message", "ok")
+		}))
+		stub.EnableHTTP2 = true
+		stub.StartTLS()
+
+		defer stub.Close()
+
+		id := SetIntercept(intercept.Recorder("test-recorder", intercept.MatchAllRequests, intercept.MatchAllResponses, func(rec *intercept.Record) { recorded <- rec }))
+		defer UnsetIntercept(id)
+
+		rq, _ := http.NewRequest(http.MethodPost, stub.URL+"/test.Service/Call", bytes.NewReader(msg))
+		rq.Header.Set("Content-Type", "application/grpc")
+		rq.Header.Set("Te", "trailers")
+
+		rs, err := client.Do(rq)
+
+		if err != nil || rs.StatusCode != http.StatusOK {
+			t.Fatalf("expected no error proxying grpc request, got [%v]", err)
+		}
+
+		b, _ := io.ReadAll(rs.Body)
+		rs.Body.Close()
+
+		if !bytes.Equal(b, msg) || rs.Trailer.Get("Grpc-Status") != "0" || rs.Trailer.Get("Grpc-Message") != "ok" {
+			t.Fatalf("expected grpc message and trailers to be proxied, got [%v] and [%v]", b, rs.Trailer)
+		}
+
+		rec := <-recorded
+
+		if rec.Response.Trailer.Get("Grpc-Status") != "0" {
+			t.Fatalf("expected trailers to be recorded, got [%v]", rec.Response.Trailer)
+		}
+
+		if _, text := intercept.Text(rec, false, -1); !strings.Contains(text, "\"1\": 150") || !strings.Contains(text, "Grpc-Message: ok") {
+			t.Fatalf("expected grpc message to be decoded and trailers written, got [%v]", text)
+		}
+	}
+
+	t.Run("HTTP2", func(t *testing.T) { test(t, true) })
+	t.Run("HTTP1", func(t *testing.T) { test(t, false) })
+}
+
+func TestProxyStream(t *testing.T) {
+	test := func(t *testing.T, clientTLS *tls.Config, proxyHandler http.HandlerFunc, newStubSvrFunc func(http.Handler) *httptest.Server) {
+		first, second, release, recorded := "data: first\n\n", "data: second\n\n", make(chan struct{}), make(chan *intercept.Record, 1)
+
+		proxy, client := httptest.NewServer(proxyHandler), http.Client{Timeout: time.Second * 5}
+		proxyURL, _ := url.Parse(proxy.URL)
+
+		client.Transport = &http.Transport{Proxy: http.ProxyURL(proxyURL), TLSClientConfig: clientTLS}
+
+		defer proxy.Close()
+
+		stub := newStubSvrFunc(http.HandlerFunc(func(rs http.ResponseWriter, rcvRq *http.Request) {
+			rs.Header().Set("Content-Type", "text/event-stream")
+			rs.Write([]byte(first))
+			rs.(http.Flusher).Flush()
+			<-release
+			rs.Write([]byte(second))
+		}))
+
+		defer stub.Close()
+
+		id := SetIntercept(intercept.Recorder("test-recorder", intercept.MatchAllRequests, intercept.MatchAllResponses, func(rec *intercept.Record) { recorded <- rec }))
+		defer UnsetIntercept(id)
+
+		rs, err := client.Get(stub.URL + "/events")
+
+		if err != nil {
+			t.Fatalf("expected no error proxying request, got [%v]", err)
+		}
+
+		defer rs.Body.Close()
+
+		br := bufio.NewReader(rs.Body)
+
+		if l, err := br.ReadString('\n'); err != nil || l != "data: first\n" {
+			t.Fatalf("expected first event to be received before the response completed, got [%v] and [%v]", l, err)
+		}
+
+		if len(recorded) > 0 {
+			t.Fatalf("expected exchange not to be recorded before the response completed")
+		}
+
+		close(release)
+
+		if b, err := io.ReadAll(br); err != nil || string(b) != "\n"+second {
+			t.Fatalf("expected remainder of event stream, got [%v] and [%v]", string(b), err)
+		}
+
+		if rec := <-recorded; string(rec.Response.Body) != first+second {
+			t.Fatalf("expected recorded response body [%v], got [%v]", first+second, string(rec.Response.Body))
+		}
+	}
+
+	t.Run("HTTP", func(t *testing.T) { test(t, nil, HTTPHandler(), httptest.NewServer) })
+	t.Run("HTTPS", func(t *testing.T) { test(t, &tls.Config{InsecureSkipVerify: true}, HTTPSHandler(), httptest.NewTLSServer) })
+}
+
+func TestProxyWebSocket(t *testing.T) {
+	readFrame := func(r io.Reader) (websocket.Header, []byte, error) {
+		h, err := websocket.ReadHeader(r)
+
+		if err != nil {
+			return h, nil, err
+		}
+
+		p := make([]byte, h.Length)
+
+		if _, err = io.ReadFull(r, p); err == nil && h.Masked {
+			websocket.Mask(h.Key, p)
+		}
+
+		return h, p, err
+	}
+
+	writeFrame := func(w io.Writer, opcode byte, masked bool, p []byte) {
+		h := websocket.Header{Fin: true, Opcode: opcode, Masked: masked, Key: [4]byte{1, 2, 3, 4}, Length: int64(len(p))}
+		p = append([]byte(nil), p...)
+
+		if masked {
+			websocket.Mask(h.Key, p)
+		}
+
+		websocket.WriteHeader(w, h)
+		w.Write(p)
+	}
+
+	test := func(t *testing.T, clientTLS bool, proxyHandler http.HandlerFunc, newStubSvrFunc func(http.Handler) *httptest.Server) {
+		var rcvExtensions string
+		recorded := make(chan *intercept.Record, 1)
+
+		proxy := httptest.NewServer(proxyHandler)
+		defer proxy.Close()
+
+		stub := newStubSvrFunc(http.HandlerFunc(func(rs http.ResponseWriter, rcvRq *http.Request) {
+			rcvExtensions = rcvRq.Header.Get("Sec-WebSocket-Extensions")
+			conn, brw, _ := rs.(http.Hijacker).Hijack()
+			defer conn.Close()
+
+			conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n"))
+
+			for {
+				h, p, err := readFrame(brw)
+
+				if err != nil {
+					return
+				}
+
+				if h.Opcode == websocket.OpClose {
+					writeFrame(conn, websocket.OpClose, false, p)
+					return
+				}
+
+				writeFrame(conn, h.Opcode, false, append([]byte("echo: "), p...))
+			}
+		}))
+		defer stub.Close()
+
+		rid := SetIntercept(intercept.Recorder("test-recorder", intercept.MatchAllRequests, intercept.MatchAllResponses, func(rec *intercept.Record) { recorded <- rec }))
+		defer UnsetIntercept(rid)
+
+		fid := SetIntercept(intercept.NewIntercept("test-frames", intercept.MatchAllRequests, intercept.MatchAllResponses,
+			func(r *intercept.ProxyRequest) error { return nil },
+			func(r *intercept.ProxyResponse) error { return nil },
+		).WithFrames(func(f *intercept.Frame) error {
+			if f.Direction == intercept.FrameSent && f.Type() == "text" {
+				f.Payload = append(f.Payload, "-modified"...)
+			}
+
+			return nil
+		}, nil))
+		defer UnsetIntercept(fid)
+
+		proxyURL, _ := url.Parse(proxy.URL)
+		stubURL, _ := url.Parse(stub.URL)
+
+		conn, err := net.Dial("tcp", proxyURL.Host)
+
+		if err != nil {
+			t.Fatalf("expected no error connecting to proxy, got [%v]", err)
+		}
+
+		defer conn.Close()
+
+		conn.SetDeadline(time.Now().Add(time.Second * 5))
+
+		rq, _ := http.NewRequest(http.MethodGet, stub.URL+"/ws", nil)
+		rq.Header.Set("Upgrade", "websocket")
+		rq.Header.Set("Connection", "Upgrade")
+		rq.Header.Set("Sec-WebSocket-Version", "13")
+		rq.Header.Set("Sec-WebSocket-Extensions", "permessage-deflate")
+
+		var rw io.ReadWriter = conn
+
+		if clientTLS {
+			fmt.Fprintf(conn, "CONNECT %v HTTP/1.1\r\nHost: %v\r\n\r\n", stubURL.Host, stubURL.Host)
+
+			if rs, err := http.ReadResponse(bufio.NewReader(conn), nil); err != nil || rs.StatusCode != http.StatusOK {
+				t.Fatalf("expected tunnel to be established, got [%v]", err)
+			}
+
+			tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true, ServerName: stubURL.Hostname()})
+			rw, err = tlsConn, rq.Write(tlsConn)
+		} else {
+			err = rq.WriteProxy(conn)
+		}
+
+		if err != nil {
+			t.Fatalf("expected no error writing upgrade request, got [%v]", err)
+		}
+
+		br := bufio.NewReader(rw)
+		rs, err := http.ReadResponse(br, rq)
+
+		if err != nil || rs.StatusCode != http.StatusSwitchingProtocols {
+			t.Fatalf("expected connection to be upgraded, got [%v] and [%v]", rs, err)
+		}
+
+		if rcvExtensions != "" {
+			t.Fatalf("expected websocket extensions not to be negotiated, got [%v]", rcvExtensions)
+		}
+
+		writeFrame(rw, websocket.OpText, true, []byte("hello"))
+
+		if h, p, err := readFrame(br); err != nil || h.Opcode != websocket.OpText || string(p) != "echo: hello-modified" {
+			t.Fatalf("expected echo of modified frame, got [%v] and [%v]", string(p), err)
+		}
+
+		writeFrame(rw, websocket.OpClose, true, []byte{0x03, 0xE8})
+
+		if h, _, err := readFrame(br); err != nil || h.Opcode != websocket.OpClose {
+			t.Fatalf("expected close frame, got [%v] and [%v]", h.Opcode, err)
+		}
+
+		rec := <-recorded
+
+		if rec.Response.StatusCode != http.StatusSwitchingProtocols || len(rec.Frames) != 4 {
+			t.Fatalf("expected websocket exchange to be recorded with [4] frames, got status [%v] with [%v] frames", rec.Response.StatusCode, len(rec.Frames))
+		}
+
+		if f := rec.Frames[1]; f.Direction != intercept.FrameReceived || string(f.Payload) != "echo: hello-modified" || f.Time.IsZero() {
+			t.Fatalf("expected recorded received frame [echo: hello-modified], got [%v] frame [%v]", f.Direction, string(f.Payload))
+		}
+	}
+
+	t.Run("HTTP", func(t *testing.T) { test(t, false, HTTPHandler(), httptest.NewServer) })
+	t.Run("HTTPS", func(t *testing.T) { test(t, true, HTTPSHandler(), httptest.NewTLSServer) })
+}
+
+func TestProxyUpstream(t *testing.T) {
+	test := func(t *testing.T, clientTLS *tls.Config, proxyHandler http.HandlerFunc, newStubSvrFunc func(http.Handler) *httptest.Server, bypass string, expectedVia int32) {
+		var via int32
+		var rcvAuth string
+
+		upstreamProxy := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&via, 1)
+			rcvAuth = r.Header.Get("Proxy-Authorization")
+
+			if r.Method != http.MethodConnect {
+				r.RequestURI = ""
+				rs, err := http.DefaultTransport.RoundTrip(r)
+
+				if err != nil {
+					rw.WriteHeader(http.StatusBadGateway)
+					return
+				}
+
+				defer rs.Body.Close()
+
+				rw.WriteHeader(rs.StatusCode)
+				io.Copy(rw, rs.Body)
+
+				return
+			}
+
+			upstream, err := net.Dial("tcp", r.Host)
+
+			if err != nil {
+				rw.WriteHeader(http.StatusBadGateway)
+				return
+			}
+
+			conn, _, _ := rw.(http.Hijacker).Hijack()
+			conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+
+			go func() { io.Copy(upstream, conn); upstream.Close() }()
+			io.Copy(conn, upstream)
+			conn.Close()
+		}))
+		defer upstreamProxy.Close()
+
+		proxy, client := httptest.NewServer(proxyHandler), http.Client{Timeout: time.Second * 5}
+		proxyURL, _ := url.Parse(proxy.URL)
+
+		client.Transport = &http.Transport{Proxy: http.ProxyURL(proxyURL), TLSClientConfig: clientTLS}
+
+		defer proxy.Close()
+
+		stub := newStubSvrFunc(http.HandlerFunc(func(rs http.ResponseWriter, rcvRq *http.Request) { rs.Write([]byte("stub-body")) }))
+		defer stub.Close()
+
+		if err := SetUpstreamProxy(strings.Replace(upstreamProxy.URL, "http://", "http://user:pass@", 1), bypass); err != nil {
+			t.Fatalf("expected no error setting upstream proxy, got [%v]", err)
+		}
+
+		defer SetUpstreamProxy("", "")
+
+		rs, err := client.Get(stub.URL)
+
+		if err != nil || rs.StatusCode != http.StatusOK {
+			t.Fatalf("expected no error proxying request, got [%v]", err)
+		}
+
+		defer rs.Body.Close()
+
+		if b, _ := io.ReadAll(rs.Body); string(b) != "stub-body" {
+			t.Fatalf("expected body [stub-body], got [%v]", string(b))
+		}
+
+		if atomic.LoadInt32(&via) != expectedVia {
+			t.Fatalf("expected [%v] requests via the upstream proxy, got [%v]", expectedVia, via)
+		}
+
+		if expectedAuth := "Basic dXNlcjpwYXNz"; expectedVia > 0 && rcvAuth != expectedAuth {
+			t.Fatalf("expected proxy authorization [%v], got [%v]", expectedAuth, rcvAuth)
+		}
+	}
+
+	tlsCfg := &tls.Config{InsecureSkipVerify: true}
+
+	t.Run("HTTP", func(t *testing.T) { test(t, nil, HTTPHandler(), httptest.NewServer, "", 1) })
+	t.Run("HTTPS", func(t *testing.T) { test(t, tlsCfg, HTTPSHandler(), httptest.NewTLSServer, "", 1) })
+	t.Run("Bypass", func(t *testing.T) { test(t, nil, HTTPHandler(), httptest.NewServer, "example.com,127.0.0.0/8", 0) })
+	t.Run("BypassHTTPS", func(t *testing.T) { test(t, tlsCfg, HTTPSHandler(), httptest.NewTLSServer, "127.0.0.1", 0) })
+}
+
+func TestDialUpstream(t *testing.T) {
+	test := func(t *testing.T, connectRs string, expectErr bool) {
+		upstreamProxy, err := net.Listen("tcp", "127.0.0.1:0")
+
+		if err != nil {
+			t.Fatalf("expected no error listening for upstream proxy connections, got [%v]", err)
+		}
+
+		defer upstreamProxy.Close()
+
+		go func() {
+			conn, err := upstreamProxy.Accept()
+
+			if err != nil {
+				return
+			}
+
+			defer conn.Close()
+
+			http.ReadRequest(bufio.NewReader(conn))
+
+			if connectRs == "" {
+				time.Sleep(time.Second)
+				return
+			}
+
+			conn.Write([]byte(connectRs))
+		}()
+
+		SetUpstreamProxy("http://"+upstreamProxy.Addr().String(), "")
+		defer SetUpstreamProxy("", "")
+
+		SetTimeouts(Timeouts{Dial: time.Millisecond * 100})
+		defer SetTimeouts(DefaultTimeouts)
+
+		conn, err := dialUpstream(&url.URL{Scheme: "tcp", Host: "www.test.com:22"}, "www.test.com:22")
+
+		if expectErr {
+			if err == nil {
+				conn.Close()
+				t.Fatalf("expected error connecting via a stalled upstream proxy")
+			}
+
+			return
+		}
+
+		if err != nil {
+			t.Fatalf("expected no error connecting via upstream proxy, got [%v]", err)
+		}
+
+		defer conn.Close()
+
+		b := make([]byte, len("banner"))
+
+		if _, err := io.ReadFull(conn, b); err != nil || string(b) != "banner" {
+			t.Fatalf("expected to receive [banner], got [%v] with error [%v]", string(b), err)
+		}
+	}
+
+	t.Run("BufferedData", func(t *testing.T) { test(t, "HTTP/1.1 200 Connection Established\r\n\r\nbanner", false) })
+	t.Run("Stalled", func(t *testing.T) { test(t, "", true) })
+}
+
+func TestProxySOCKS(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("expected no error listening for socks connections, got [%v]", err)
+	}
+
+	defer l.Close()
+
+	go ServeSOCKS(l)
+
+	test := func(t *testing.T, newStubSvrFunc func(http.Handler) *httptest.Server, hostName string) {
+		var rcvHost string
+		intRsHdrK, intRsHdrV := "intRsHdrK", "intRsHdrV"
+
+		client := http.Client{Timeout: time.Second * 5}
+		client.Transport = &http.Transport{Proxy: http.ProxyURL(&url.URL{Scheme: "socks5", Host: l.Addr().String()}), TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+
+		stub := newStubSvrFunc(http.HandlerFunc(func(rs http.ResponseWriter, rcvRq *http.Request) {
+			rcvHost = rcvRq.Host
+			rs.Write([]byte("stub-body"))
+		}))
+		defer stub.Close()
+
+		rq, _ := http.NewRequest(http.MethodGet, stub.URL, nil)
+
+		if hostName != "" {
+			rq.Host = net.JoinHostPort(hostName, rq.URL.Port())
+		}
+
+		id := SetIntercept(intercept.NewIntercept("test-intercept", intercept.MatchAllRequests, intercept.MatchAllResponses,
+			func(r *intercept.ProxyRequest) error { return nil },
+			func(r *intercept.ProxyResponse) error {
+				r.Header.Add(intRsHdrK, intRsHdrV)
+				return nil
+			},
+		))
+
+		defer UnsetIntercept(id)
+
+		rs, err := client.Do(rq)
+
+		if err != nil || rs.StatusCode != http.StatusOK {
+			t.Fatalf("expected no error proxying request, got [%v]", err)
+		}
+
+		defer rs.Body.Close()
+
+		if b, _ := io.ReadAll(rs.Body); string(b) != "stub-body" {
+			t.Fatalf("expected body [stub-body], got [%v]", string(b))
+		}
+
+		if rs.Header.Get(intRsHdrK) != intRsHdrV {
+			t.Fatalf("expected intercepted response header value of [%v], got [%v]", intRsHdrV, rs.Header.Get(intRsHdrK))
+		}
+
+		if rcvHost != rq.Host {
+			t.Fatalf("expected host [%v], got [%v]", rq.Host, rcvHost)
+		}
+	}
+
+	t.Run("HTTP", func(t *testing.T) { test(t, httptest.NewServer, "") })
+	t.Run("HTTPS", func(t *testing.T) { test(t, httptest.NewTLSServer, "") })
+	t.Run("IPHostHeader", func(t *testing.T) { test(t, httptest.NewServer, "www.test.com") })
+	t.Run("TCP", func(t *testing.T) {
+		stub, err := net.Listen("tcp", "127.0.0.1:0")
+
+		if err != nil {
+			t.Fatalf("expected no error listening for tcp connections, got [%v]", err)
+		}
+
+		defer stub.Close()
+
+		go func() {
+			conn, err := stub.Accept()
+
+			if err != nil {
+				return
+			}
+
+			defer conn.Close()
+
+			conn.Write([]byte("greeting"))
+			io.Copy(conn, conn)
+		}()
+
+		conn, err := net.Dial("tcp", l.Addr().String())
+
+		if err != nil {
+			t.Fatalf("expected no error connecting to socks listener, got [%v]", err)
+		}
+
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(time.Second * 5))
+
+		if err := socks.Connect(conn, stub.Addr().String(), nil); err != nil {
+			t.Fatalf("expected no error connecting via socks listener, got [%v]", err)
+		}
+
+		b := make([]byte, len("greeting"))
+
+		if _, err := io.ReadFull(conn, b); err != nil || string(b) != "greeting" {
+			t.Fatalf("expected to receive [greeting], got [%v] with error [%v]", string(b), err)
+		}
+
+		conn.Write([]byte("echo"))
+		b = b[:len("echo")]
+
+		if _, err := io.ReadFull(conn, b); err != nil || string(b) != "echo" {
+			t.Fatalf("expected to receive [echo], got [%v] with error [%v]", string(b), err)
+		}
+	})
+}
+
+func TestProxyTransparent(t *testing.T) {
+	test := func(t *testing.T, serve func(net.Listener, *httptest.Server) error, newStubSvrFunc func(http.Handler) *httptest.Server, serverName, hostName string) {
+		var rcvHost string
+
+		stub := newStubSvrFunc(http.HandlerFunc(func(rs http.ResponseWriter, rcvRq *http.Request) {
+			rcvHost = rcvRq.Host
+			rs.Write([]byte("stub-body"))
+		}))
+		defer stub.Close()
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+
+		if err != nil {
+			t.Fatalf("expected no error listening for transparent connections, got [%v]", err)
+		}
+
+		defer l.Close()
+
+		go serve(l, stub)
+
+		client := http.Client{Timeout: time.Second * 5}
+		client.Transport = &http.Transport{
+			DialContext:     func(_ context.Context, _, _ string) (net.Conn, error) { return net.Dial("tcp", l.Addr().String()) },
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true, ServerName: serverName},
+		}
+
+		rqURL, _ := url.Parse(stub.URL)
+
+		if hostName != "" {
+			rqURL.Host = net.JoinHostPort(hostName, rqURL.Port())
+		}
+
+		rs, err := client.Get(rqURL.String())
+
+		if err != nil || rs.StatusCode != http.StatusOK {
+			t.Fatalf("expected no error proxying request, got [%v]", err)
+		}
+
+		defer rs.Body.Close()
+
+		if b, _ := io.ReadAll(rs.Body); string(b) != "stub-body" {
+			t.Fatalf("expected body [stub-body], got [%v]", string(b))
+		}
+
+		if rcvHost != rqURL.Host {
+			t.Fatalf("expected host [%v], got [%v]", rqURL.Host, rcvHost)
+		}
+	}
+
+	originalDst := func(l net.Listener, stub *httptest.Server) error {
+		return serveTransparent(l, func(net.Conn) (string, bool) { return stub.Listener.Addr().String(), true })
+	}
+
+	t.Run("HostHeader", func(t *testing.T) {
+		test(t, func(l net.Listener, _ *httptest.Server) error { return ServeTransparent(l) }, httptest.NewServer, "", "")
+	})
+
+	t.Run("OriginalDst", func(t *testing.T) { test(t, originalDst, httptest.NewTLSServer, "localhost", "") })
+	t.Run("OriginalDstHTTP", func(t *testing.T) { test(t, originalDst, httptest.NewServer, "", "www.test.com") })
+}
+
+func TestProxyReverse(t *testing.T) {
+	test := func(t *testing.T, tlsTerminated bool, upstreamPath, rqPath, expectedPath string) {
+		var rcvPath, rcvFwdHost, rcvFwdProto, rcvIntHdrV string
+
+		stub := httptest.NewServer(http.HandlerFunc(func(rs http.ResponseWriter, rcvRq *http.Request) {
+			rcvPath, rcvFwdHost, rcvFwdProto, rcvIntHdrV = rcvRq.URL.Path, rcvRq.Header.Get("X-Forwarded-Host"), rcvRq.Header.Get("X-Forwarded-Proto"), rcvRq.Header.Get("intRqHdrK")
+			rs.Write([]byte("stub-body"))
+		}))
+		defer stub.Close()
+
+		handler, err := ReverseHandler("/other=http://localhost:1", "/api="+stub.URL+upstreamPath)
+
+		if err != nil {
+			t.Fatalf("expected no error creating reverse handler, got [%v]", err)
+		}
+
+		id := SetIntercept(intercept.NewIntercept("test-intercept", intercept.MatchAllRequests, intercept.MatchAllResponses,
+			func(r *intercept.ProxyRequest) error {
+				r.Header.Add("intRqHdrK", "intRqHdrV")
+				return nil
+			},
+			func(r *intercept.ProxyResponse) error { return nil },
+		))
+
+		defer UnsetIntercept(id)
+
+		reverse, client, expectedProto := httptest.NewUnstartedServer(handler), http.Client{Timeout: time.Second * 5}, "http"
+
+		if tlsTerminated {
+			reverse.TLS, expectedProto = &tls.Config{GetCertificate: cert.Get}, "https"
+			reverse.StartTLS()
+			client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+		} else {
+			reverse.Start()
+		}
+
+		defer reverse.Close()
+
+		rs, err := client.Get(reverse.URL + rqPath)
+
+		if err != nil || rs.StatusCode != http.StatusOK {
+			t.Fatalf("expected no error proxying request, got [%v]", err)
+		}
+
+		defer rs.Body.Close()
+
+		if b, _ := io.ReadAll(rs.Body); string(b) != "stub-body" {
+			t.Fatalf("expected body [stub-body], got [%v]", string(b))
+		}
+
+		assert := func(attr, exp, got string) {
+			if got != exp {
+				t.Fatalf("expected to %v [%v], got [%v]", attr, exp, got)
+			}
+		}
+
+		assert("receive request path of", expectedPath, rcvPath)
+		assert("receive forwarded host of", strings.TrimPrefix(strings.TrimPrefix(reverse.URL, "http://"), "https://"), rcvFwdHost)
+		assert("receive forwarded proto of", expectedProto, rcvFwdProto)
+		assert("receive intercepted request header value of", "intRqHdrV", rcvIntHdrV)
+	}
+
+	t.Run("HTTP", func(t *testing.T) { test(t, false, "", "/api/test", "/api/test") })
+	t.Run("HTTPS", func(t *testing.T) { test(t, true, "", "/api/test", "/api/test") })
+	t.Run("UpstreamPath", func(t *testing.T) { test(t, false, "/v1/", "/api/test", "/v1/test") })
+	t.Run("Unmatched", func(t *testing.T) {
+		handler, _ := ReverseHandler("/api=http://localhost:1")
+		rec := httptest.NewRecorder()
+
+		handler(rec, httptest.NewRequest(http.MethodGet, "/apix", nil))
+
+		if rec.Code != http.StatusBadGateway {
+			t.Fatalf("expected status [%v], got [%v]", http.StatusBadGateway, rec.Code)
+		}
+	})
+	t.Run("InvalidUpstream", func(t *testing.T) {
+		if _, err := ReverseHandler("api=http://localhost:9000"); err == nil {
+			t.Fatalf("expected error creating reverse handler with an invalid upstream")
+		}
+	})
+}
+
+func TestProxyConnectionReuse(t *testing.T) {
+	test := func(t *testing.T, clientTLS *tls.Config, proxyHandler http.HandlerFunc) {
+		var proxyConns, stubConns int32
+
+		countNew := func(n *int32) func(net.Conn, http.ConnState) {
+			return func(_ net.Conn, s http.ConnState) {
+				if s == http.StateNew {
+					atomic.AddInt32(n, 1)
+				}
+			}
+		}
+
+		proxy := httptest.NewUnstartedServer(proxyHandler)
+		proxy.Config.ConnState = countNew(&proxyConns)
+		proxy.Start()
+
+		defer proxy.Close()
+
+		stub := httptest.NewUnstartedServer(http.HandlerFunc(func(rs http.ResponseWriter, rcvRq *http.Request) {
+			if rcvRq.Header.Get("Proxy-Connection") != "" || rcvRq.Header.Get("X-Hop") != "" {
+				rs.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			rs.Header().Set("Keep-Alive", "timeout=5")
+			rs.Write([]byte("stub-body"))
+		}))
+		stub.Config.ConnState = countNew(&stubConns)
+
+		if clientTLS != nil {
+			stub.StartTLS()
+		} else {
+			stub.Start()
+		}
+
+		defer stub.Close()
+
+		proxyURL, _ := url.Parse(proxy.URL)
+		client := http.Client{Timeout: time.Second * 5, Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL), TLSClientConfig: clientTLS}}
+
+		before := Connections()
+
+		for i := 0; i < 3; i++ {
+			rq, _ := http.NewRequest(http.MethodGet, stub.URL, nil)
+			rq.Header.Set("Proxy-Connection", "keep-alive")
+			rq.Header.Set("Connection", "X-Hop")
+			rq.Header.Set("X-Hop", "hop-value")
+
+			rs, err := client.Do(rq)
+
+			if err != nil || rs.StatusCode != http.StatusOK {
+				t.Fatalf("expected no error and status [%v] proxying request, got [%v] and [%v]", http.StatusOK, err, rs.StatusCode)
+			}
+
+			if b, _ := io.ReadAll(rs.Body); string(b) != "stub-body" || rs.Header.Get("Keep-Alive") != "" {
+				t.Fatalf("expected body [stub-body] without a keep-alive header, got [%v] and [%v]", string(b), rs.Header.Get("Keep-Alive"))
+			}
+
+			rs.Body.Close()
+		}
+
+		if n := atomic.LoadInt32(&proxyConns); n != 1 {
+			t.Fatalf("expected [1] connection to the proxy, got [%v]", n)
+		}
+
+		if n := atomic.LoadInt32(&stubConns); n != 1 {
+			t.Fatalf("expected [1] connection to the upstream host, got [%v]", n)
+		}
+
+		if after := Connections(); after.Requests-before.Requests != 3 || after.Reused-before.Reused != 2 {
+			t.Fatalf("expected [3] requests with [2] reusing a connection, got [%v] and [%v]", after.Requests-before.Requests, after.Reused-before.Reused)
+		}
+	}
+
+	t.Run("HTTP", func(t *testing.T) { test(t, nil, HTTPHandler()) })
+	t.Run("HTTPS", func(t *testing.T) { test(t, &tls.Config{InsecureSkipVerify: true}, HTTPSHandler()) })
+}
+
+func TestProxyTimeouts(t *testing.T) {
+	test := func(t *testing.T, clientTLS *tls.Config, proxyHandler http.HandlerFunc, newStubSvrFunc func(http.Handler) *httptest.Server, closeStub bool, expectedStatus int) {
+		SetTimeouts(Timeouts{ResponseHeader: time.Millisecond * 100, ClientIdle: time.Second})
+		defer SetTimeouts(DefaultTimeouts)
+
+		proxy, client := httptest.NewServer(proxyHandler), http.Client{Timeout: time.Second * 5}
+		proxyURL, _ := url.Parse(proxy.URL)
+
+		client.Transport = &http.Transport{Proxy: http.ProxyURL(proxyURL), TLSClientConfig: clientTLS}
+
+		defer proxy.Close()
+
+		release := make(chan struct{})
+
+		stub := newStubSvrFunc(http.HandlerFunc(func(rs http.ResponseWriter, rcvRq *http.Request) {
+			select {
+			case <-release:
+			case <-time.After(time.Second * 2):
+			}
+		}))
+
+		defer stub.Close()
+		defer close(release)
+
+		if closeStub {
+			stub.Close()
+		}
+
+		rs, err := client.Get(stub.URL)
+
+		if err != nil {
+			t.Fatalf("expected no error proxying request, got [%v]", err)
+		}
+
+		rs.Body.Close()
+
+		if rs.StatusCode != expectedStatus {
+			t.Fatalf("expected status [%v], got [%v]", expectedStatus, rs.StatusCode)
+		}
+	}
+
+	tlsCfg := &tls.Config{InsecureSkipVerify: true}
+
+	t.Run("HTTP", func(t *testing.T) { test(t, nil, HTTPHandler(), httptest.NewServer, false, http.StatusGatewayTimeout) })
+	t.Run("HTTPS", func(t *testing.T) { test(t, tlsCfg, HTTPSHandler(), httptest.NewTLSServer, false, http.StatusGatewayTimeout) })
+	t.Run("Unreachable", func(t *testing.T) { test(t, nil, HTTPHandler(), httptest.NewServer, true, http.StatusBadGateway) })
+	t.Run("TunnelIdle", func(t *testing.T) {
+		SetTimeouts(Timeouts{TunnelIdle: time.Millisecond * 200})
+		defer SetTimeouts(DefaultTimeouts)
+
+		l, _ := net.Listen("tcp", "127.0.0.1:0")
+		defer l.Close()
+
+		go ServeSOCKS(l)
+
+		stub, _ := net.Listen("tcp", "127.0.0.1:0")
+		defer stub.Close()
+
+		go func() {
+			if conn, err := stub.Accept(); err == nil {
+				defer conn.Close()
+				io.Copy(io.Discard, conn)
+			}
+		}()
+
+		conn, err := net.Dial("tcp", l.Addr().String())
+
+		if err != nil {
+			t.Fatalf("expected no error connecting to socks listener, got [%v]", err)
+		}
+
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(time.Second * 5))
+
+		if err := socks.Connect(conn, stub.Addr().String(), nil); err != nil {
+			t.Fatalf("expected no error connecting via socks listener, got [%v]", err)
+		}
+
+		if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("expected idle tunnel to be closed, got [%v]", err)
+		}
+	})
+}
+
+func TestTimedOut(t *testing.T) {
+	test := func(t *testing.T, err error, expectedKind string, expectedOK bool) {
+		if kind, ok := timedOut(err); kind != expectedKind || ok != expectedOK {
+			t.Fatalf("expected timeout [%v] and [%v], got [%v] and [%v]", expectedKind, expectedOK, kind, ok)
+		}
+	}
+
+	dialErr := &net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded}
+
+	t.Run("Request", func(t *testing.T) { test(t, &url.Error{Op: "Get", Err: context.DeadlineExceeded}, "request", true) })
+	t.Run("Dial", func(t *testing.T) { test(t, &url.Error{Op: "Get", Err: dialErr}, "dial", true) })
+	t.Run("Read", func(t *testing.T) { test(t, &net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}, "read", true) })
+	t.Run("NotTimeout", func(t *testing.T) { test(t, &url.Error{Op: "Get", Err: io.ErrUnexpectedEOF}, "", false) })
+}
+
+func TestBypassed(t *testing.T) {
+	test := func(t *testing.T, bypass, rawURL string, expected bool) {
+		u, _ := url.Parse(rawURL)
+
+		if got := bypassed(strings.Split(bypass, ","), u); got != expected {
+			t.Fatalf("expected bypass of [%v] by [%v] to be [%v], got [%v]", rawURL, bypass, expected, got)
+		}
+	}
+
+	t.Run("Domain", func(t *testing.T) { test(t, "example.com", "http://example.com/", true) })
+	t.Run("Subdomain", func(t *testing.T) { test(t, "example.com", "https://api.example.com/", true) })
+	t.Run("SuffixOnly", func(t *testing.T) { test(t, "example.com", "https://notexample.com/", false) })
+	t.Run("DotDomain", func(t *testing.T) { test(t, ".example.com", "https://example.com/", false) })
+	t.Run("DotSubdomain", func(t *testing.T) { test(t, ".example.com", "https://api.example.com/", true) })
+	t.Run("HostPort", func(t *testing.T) { test(t, "example.com:8443", "https://example.com:8443/", true) })
+	t.Run("HostDefaultPort", func(t *testing.T) { test(t, "example.com:443", "https://example.com/", true) })
+	t.Run("HostOtherPort", func(t *testing.T) { test(t, "example.com:8443", "https://example.com/", false) })
+	t.Run("IP", func(t *testing.T) { test(t, "10.0.0.1", "http://10.0.0.1:8080/", true) })
+	t.Run("CIDR", func(t *testing.T) { test(t, "10.0.0.0/8", "http://10.1.2.3/", true) })
+	t.Run("Wildcard", func(t *testing.T) { test(t, "*", "http://www.test.com/", true) })
+	t.Run("NoMatch", func(t *testing.T) { test(t, "example.com,10.0.0.0/8", "http://www.test.com/", false) })
+}
+
+func TestReplaceIntercepts(t *testing.T) {
+	noop := func(label string) *intercept.Intercept {
+		return intercept.NewIntercept(label, intercept.MatchAllRequests, intercept.MatchAllResponses,
+			func(r *intercept.ProxyRequest) error { return nil },
+			func(r *intercept.ProxyResponse) error { return nil },
+		)
+	}
+
+	ids := ReplaceIntercepts(nil, []*intercept.Intercept{noop("a"), noop("b")})
+	nids := ReplaceIntercepts(ids, []*intercept.Intercept{noop("c")})
+
+	defer ReplaceIntercepts(nids, nil)
+
+	is := Intercepts()
+
+	if _, ok := is[ids[1]]; ok {
+		t.Fatalf("expected replaced intercept [%v] to be removed", ids[1])
+	}
+
+	if nids[0] != ids[0] || is[nids[0]] == nil || is[nids[0]].Label() != "c" {
+		t.Fatalf("expected replacement intercept to be added with id [%v], got ids [%v]", ids[0], nids[:1])
+	}
+
+	t.Run("KeepsOrder", func(t *testing.T) {
+		ids := ReplaceIntercepts(nil, []*intercept.Intercept{noop("g")})
+		later := SetIntercept(noop("h"))
+
+		defer UnsetIntercept(later)
+
+		ids = ReplaceIntercepts(ids, []*intercept.Intercept{noop("g"), noop("i")})
+
+		defer ReplaceIntercepts(ids, nil)
+
+		if ids[0] >= ids[1] || ids[1] >= later {
+			t.Fatalf("expected replacement intercepts [%v] to be applied before intercept [%v] set since", ids[:2], later)
+		}
+	})
+
+	t.Run("KeepsEnabled", func(t *testing.T) {
+		ids := ReplaceIntercepts(nil, []*intercept.Intercept{noop("d"), noop("e")})
+		EnableIntercept(ids[0], false)
+
+		ids = ReplaceIntercepts(ids, []*intercept.Intercept{noop("d"), noop("e"), noop("f")})
+
+		defer ReplaceIntercepts(ids, nil)
+
+		is := Intercepts()
+
+		for i, exp := range []bool{false, true, true} {
+			if is[ids[i]].Enabled() != exp {
+				t.Fatalf("expected intercept [%v] to have enabled of [%v], got [%v]", is[ids[i]].Label(), exp, is[ids[i]].Enabled())
+			}
+		}
+	})
+}
+
+func TestEnableIntercept(t *testing.T) {
+	id := SetIntercept(intercept.NewIntercept("enable", intercept.MatchAllRequests, intercept.MatchAllResponses,
+		func(r *intercept.ProxyRequest) error { return nil },
+		func(r *intercept.ProxyResponse) error { return nil },
+	))
+
+	defer UnsetIntercept(id)
+
+	if !EnableIntercept(id, false) || Intercepts()[id].Enabled() {
+		t.Fatalf("expected intercept [%v] to be disabled", id)
+	}
+
+	if !EnableIntercept(id, true) || !Intercepts()[id].Enabled() {
+		t.Fatalf("expected intercept [%v] to be enabled", id)
+	}
+
+	if EnableIntercept(-1, true) {
+		t.Fatalf("expected enabling a non-existent intercept to return false")
+	}
 }
@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"comradequinn/hflow/log"
+	"comradequinn/hflow/proxy/intercept"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// reverseUpstream is an upstream of ReverseHandler that receives requests with paths starting with prefix
+type reverseUpstream struct {
+	prefix string
+	url    *url.URL
+}
+
+// ReverseHandler is a http.HandlerFunc that acts as a reverse proxy, sending the origin-form requests it receives to
+// upstreams and applying any intercepts, so that clients can use hflow without being configured to use a proxy.
+//
+// Each upstream is a url, such as http://localhost:9000, optionally preceded by a path prefix, such as
+// /api=http://localhost:9001, that requests must have to be sent to it. Requests are sent to the upstream with the
+// longest matching prefix, with an upstream without a prefix receiving all requests not matched by another. Where the
+// url of the upstream has a path, it replaces the prefix in the path of the request
+func ReverseHandler(upstreams ...string) (http.HandlerFunc, error) {
+	us := []reverseUpstream{}
+
+	for _, u := range upstreams {
+		ru := reverseUpstream{prefix: "/"}
+
+		if i := strings.Index(u, "="); i > 0 && strings.HasPrefix(u, "/") {
+			ru.prefix, u = u[:i], u[i+1:]
+		}
+
+		var err error
+
+		if ru.url, err = url.Parse(u); err != nil {
+			return nil, fmt.Errorf("invalid reverse proxy upstream url [%v]: [%v]", u, err)
+		}
+
+		if (ru.url.Scheme != "http" && ru.url.Scheme != "https") || ru.url.Host == "" {
+			return nil, fmt.Errorf("invalid reverse proxy upstream url [%v]: a scheme of http or https and a host are required", u)
+		}
+
+		us = append(us, ru)
+	}
+
+	if len(us) == 0 {
+		return nil, fmt.Errorf("no reverse proxy upstreams specified")
+	}
+
+	sort.SliceStable(us, func(i, j int) bool { return len(us[i].prefix) > len(us[j].prefix) })
+
+	client := upstreamClient()
+
+	return func(rw http.ResponseWriter, r *http.Request) {
+		x := intercept.NewExchange(r.RemoteAddr, 0)
+		x.Proto = r.Proto
+
+		log.Printf(1, "<<< received reverse proxy request for [%v] on host [%v] as exchange [%v]", r.URL.String(), r.Host, x.ID)
+
+		var upstream *reverseUpstream
+
+		for i := range us {
+			if p := strings.TrimSuffix(us[i].prefix, "/"); r.URL.Path == p || strings.HasPrefix(r.URL.Path, p+"/") {
+				upstream = &us[i]
+				break
+			}
+		}
+
+		if upstream == nil {
+			rw.WriteHeader(http.StatusBadGateway)
+			log.Printf(0, "no reverse proxy upstream matches [%v] on host [%v]", r.URL.String(), r.Host)
+			return
+		}
+
+		scheme := "http"
+
+		if r.TLS != nil {
+			scheme = "https"
+		}
+
+		if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			if prior := r.Header.Get("X-Forwarded-For"); prior != "" {
+				ip = prior + ", " + ip
+			}
+
+			r.Header.Set("X-Forwarded-For", ip)
+		}
+
+		r.Header.Set("X-Forwarded-Host", r.Host)
+		r.Header.Set("X-Forwarded-Proto", scheme)
+
+		r.URL.Scheme, r.URL.Host, r.Host = upstream.url.Scheme, upstream.url.Host, upstream.url.Host
+
+		if upstream.url.Path != "" {
+			r.URL.Path = strings.TrimSuffix(upstream.url.Path, "/") + strings.TrimPrefix(r.URL.Path, strings.TrimSuffix(upstream.prefix, "/"))
+			r.URL.RawPath = ""
+		}
+
+		proxyExchange(client, x, rw, r)
+	}, nil
+}
@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"bufio"
+	"comradequinn/hflow/log"
+	"comradequinn/hflow/proxy/internal/socks"
+	"io"
+	"net"
+)
+
+// ServeSOCKS accepts SOCKS5 and SOCKS4a connections on l and serves the tunnels they request. TLS and plaintext HTTP
+// streams are proxied, with any intercepts applied, as per HTTPSHandler; any other stream is passed through as raw tcp.
+// The SOCKS handshake must complete within the ClientIdle timeout. ServeSOCKS blocks until accepting a connection fails,
+// such as when l is closed
+func ServeSOCKS(l net.Listener) error {
+	client := upstreamClient()
+
+	for {
+		conn, err := l.Accept()
+
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			br := bufio.NewReader(conn)
+
+			if err := conn.SetReadDeadline(deadline(timeouts().ClientIdle)); err != nil {
+				log.Printf(0, "error setting read deadline on connection with socks client [%v]. [%v]", conn.RemoteAddr(), err)
+				conn.Close()
+				return
+			}
+
+			addr, err := socks.Accept(struct {
+				io.Reader
+				io.Writer
+			}{br, conn})
+
+			if err != nil {
+				log.Printf(0, "error accepting socks connection from remote client [%v]. [%v]", conn.RemoteAddr(), err)
+				conn.Close()
+				return
+			}
+
+			log.Printf(3, "accepted socks connection to [%v] from remote client [%v]", addr, conn.RemoteAddr())
+
+			serveTunnel(client, conn, br, addr, conn.RemoteAddr().String())
+		}()
+	}
+}
@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"comradequinn/hflow/log"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Timeouts configures how long hflow waits on clients and upstream hosts. A timeout of 0 is no limit
+type Timeouts struct {
+	// Dial is how long connecting to an upstream host, or to an upstream proxy and having it connect to the host, may take
+	Dial time.Duration
+	// TLSHandshake is how long a TLS handshake with a client or upstream host may take
+	TLSHandshake time.Duration
+	// ResponseHeader is how long an upstream host may take to send the headers of a response once sent a request
+	ResponseHeader time.Duration
+	// Request is how long a request to an upstream host may take in total, including reading the body of its response
+	Request time.Duration
+	// ClientIdle is how long a client connection may be idle between requests, including while sending request headers or
+	// a SOCKS handshake
+	ClientIdle time.Duration
+	// TunnelIdle is how long a websocket or raw tcp tunnel may pass no data in either direction before it is closed
+	TunnelIdle time.Duration
+}
+
+// DefaultTimeouts are the Timeouts used until SetTimeouts is called
+var DefaultTimeouts = Timeouts{Dial: time.Second * 30, TLSHandshake: time.Second * 10, ClientIdle: time.Second * 60}
+
+// SetTimeouts sets the Timeouts applied to subsequent connections and requests. Connections to upstream hosts that are
+// idle are closed, so that new connections are subject to t
+func SetTimeouts(t Timeouts) {
+	transport.mx.Lock()
+	defer transport.mx.Unlock()
+
+	transport.timeouts = t
+	replaceTransport()
+}
+
+// timeouts returns the current Timeouts
+func timeouts() Timeouts {
+	transport.mx.RLock()
+	defer transport.mx.RUnlock()
+
+	return transport.timeouts
+}
+
+// deadline returns the time d from now, or the zero time, which is no deadline, where d is 0
+func deadline(d time.Duration) time.Time {
+	if d == 0 {
+		return time.Time{}
+	}
+
+	return time.Now().Add(d)
+}
+
+// timedOut returns a description of the timeout that caused err, and true, where err was caused by a timeout
+func timedOut(err error) (string, bool) {
+	var ne net.Error
+
+	if !errors.Is(err, context.DeadlineExceeded) && !(errors.As(err, &ne) && ne.Timeout()) {
+		return "", false
+	}
+
+	var oe *net.OpError
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "request", true
+	case errors.As(err, &oe) && oe.Op == "dial":
+		return "dial", true
+	case strings.Contains(err.Error(), "TLS handshake timeout"):
+		return "tls handshake", true
+	case strings.Contains(err.Error(), "timeout awaiting response headers"):
+		return "response header", true
+	default:
+		return "read", true
+	}
+}
+
+// upstreamError logs err, which occurred requesting rq from its upstream host, and returns the status code to respond to
+// the client with; 504 where a timeout caused err, otherwise 502
+func upstreamError(rq *http.Request, err error) int {
+	if kind, ok := timedOut(err); ok {
+		log.Printf(0, "%v timeout proxying request for [%v] on host [%v]: [%v]", kind, rq.URL.String(), rq.Host, err)
+		return http.StatusGatewayTimeout
+	}
+
+	log.Printf(0, "error proxying request for [%v] on host [%v]: [%v]", rq.URL.String(), rq.Host, err)
+
+	return http.StatusBadGateway
+}
+
+// errorResponse returns a response to rq with statusCode and no body, that closes the client connection
+func errorResponse(rq *http.Request, statusCode int) *http.Response {
+	return &http.Response{
+		Status:     fmt.Sprintf("%v %v", statusCode, http.StatusText(statusCode)),
+		StatusCode: statusCode,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Body:       http.NoBody,
+		Close:      true,
+		Request:    rq,
+	}
+}
+
+// watchIdle calls closeFunc once d passes without a read from any of the readers returned by the returned func, unless
+// stop is called first. Where d is 0, closeFunc is never called
+func watchIdle(d time.Duration, closeFunc func()) (watch func(io.Reader) io.Reader, stop func()) {
+	last, done := time.Now().UnixNano(), make(chan struct{})
+
+	watch = func(r io.Reader) io.Reader { return activityReader{r: r, last: &last} }
+
+	if d == 0 {
+		return watch, func() {}
+	}
+
+	go func() {
+		t := time.NewTicker(d / 4)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				if time.Since(time.Unix(0, atomic.LoadInt64(&last))) >= d {
+					closeFunc()
+					return
+				}
+			}
+		}
+	}()
+
+	return watch, func() { close(done) }
+}
+
+// activityReader is an io.Reader that records the time of each read that returns data in last
+type activityReader struct {
+	r    io.Reader
+	last *int64
+}
+
+func (a activityReader) Read(b []byte) (int, error) {
+	n, err := a.r.Read(b)
+
+	if n > 0 {
+		atomic.StoreInt64(a.last, time.Now().UnixNano())
+	}
+
+	return n, err
+}
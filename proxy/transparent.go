@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"bufio"
+	"comradequinn/hflow/log"
+	"net"
+)
+
+// ServeTransparent accepts connections on l that were redirected to it rather than sent to it as a proxy, such as by an
+// iptables REDIRECT rule, and serves them as per the tunnels of HTTPSHandler. The host of each connection is its original
+// destination where it can be read, as on Linux, or otherwise the SNI of TLS streams and the Host header of plaintext HTTP
+// requests. ServeTransparent blocks until accepting a connection fails, such as when l is closed
+func ServeTransparent(l net.Listener) error {
+	return serveTransparent(l, originalDst)
+}
+
+// serveTransparent serves the connections accepted on l, reading the original destination of each using dst
+func serveTransparent(l net.Listener, dst func(net.Conn) (string, bool)) error {
+	client := upstreamClient()
+
+	for {
+		conn, err := l.Accept()
+
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			host, ok := dst(conn)
+
+			if !ok || host == conn.LocalAddr().String() {
+				host = ""
+			}
+
+			log.Printf(3, "accepted transparent connection to [%v] from remote client [%v]", host, conn.RemoteAddr())
+
+			serveTunnel(client, conn, bufio.NewReader(conn), host, conn.RemoteAddr().String())
+		}()
+	}
+}
@@ -0,0 +1,45 @@
+//go:build linux
+// +build linux
+
+package proxy
+
+import (
+	"net"
+	"strconv"
+	"syscall"
+)
+
+// soOriginalDst is the socket option that netfilter exposes the pre-nat destination of a connection on
+const soOriginalDst = 80
+
+// originalDst returns the destination that conn was addressed to before it was redirected to hflow, where it is an IPv4
+// connection and netfilter has a record of it
+func originalDst(conn net.Conn) (string, bool) {
+	tc, ok := conn.(*net.TCPConn)
+
+	if !ok {
+		return "", false
+	}
+
+	rc, err := tc.SyscallConn()
+
+	if err != nil {
+		return "", false
+	}
+
+	var addr string
+
+	rc.Control(func(fd uintptr) {
+		// the sockaddr_in of the destination is written over the ipv6_mreq, so its port and ip follow its 2 byte family
+		mreq, err := syscall.GetsockoptIPv6Mreq(int(fd), syscall.SOL_IP, soOriginalDst)
+
+		if err != nil {
+			return
+		}
+
+		a := mreq.Multiaddr
+		addr = net.JoinHostPort(net.IPv4(a[4], a[5], a[6], a[7]).String(), strconv.Itoa(int(a[2])<<8|int(a[3])))
+	})
+
+	return addr, addr != ""
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package proxy
+
+import "net"
+
+// originalDst returns false as the original destination of redirected connections is only read on Linux
+func originalDst(conn net.Conn) (string, bool) {
+	return "", false
+}
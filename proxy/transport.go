@@ -0,0 +1,210 @@
+package proxy
+
+import (
+	"comradequinn/hflow/log"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TransportOptions configures the pool of connections to upstream hosts that is shared by all handlers
+type TransportOptions struct {
+	// MaxIdleConns is the maximum number of idle connections held open across all hosts, 0 is no limit
+	MaxIdleConns int
+	// MaxIdleConnsPerHost is the maximum number of idle connections held open to each host
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost is the maximum number of connections, in use or idle, open to each host, 0 is no limit
+	MaxConnsPerHost int
+	// IdleConnTimeout is how long a connection may be idle before it is closed, 0 is no limit
+	IdleConnTimeout time.Duration
+}
+
+// DefaultTransportOptions are the TransportOptions used until SetTransportOptions is called
+var DefaultTransportOptions = TransportOptions{MaxIdleConns: 100, MaxIdleConnsPerHost: 8, IdleConnTimeout: time.Second * 90}
+
+// ConnectionStats describes the use of connections to upstream hosts, other than those of websockets and raw tcp tunnels
+type ConnectionStats struct {
+	// Open is the number of connections currently open, whether in use or idle
+	Open int64 `json:"open"`
+	// Opened is the total number of connections opened
+	Opened int64 `json:"opened"`
+	// Requests is the total number of requests sent
+	Requests int64 `json:"requests"`
+	// Reused is the number of requests sent on a connection that was opened for an earlier request
+	Reused int64 `json:"reused"`
+}
+
+var (
+	transport = struct {
+		mx       sync.RWMutex
+		t        *http.Transport
+		options  TransportOptions
+		timeouts Timeouts
+	}{t: newTransport(DefaultTransportOptions, DefaultTimeouts), options: DefaultTransportOptions, timeouts: DefaultTimeouts}
+
+	connections ConnectionStats
+)
+
+// SetTransportOptions replaces the pool of connections to upstream hosts with one configured by o. Idle connections of the
+// previous pool are closed, those in use are closed once their requests complete
+func SetTransportOptions(o TransportOptions) {
+	transport.mx.Lock()
+	defer transport.mx.Unlock()
+
+	transport.options = o
+	replaceTransport()
+}
+
+// replaceTransport replaces the shared transport with one configured by the current options and timeouts, closing the
+// idle connections of the previous one. transport.mx must be held by the caller
+func replaceTransport() {
+	prev := transport.t
+	transport.t = newTransport(transport.options, transport.timeouts)
+
+	prev.CloseIdleConnections()
+}
+
+// Connections returns the current ConnectionStats
+func Connections() ConnectionStats {
+	return ConnectionStats{
+		Open:     atomic.LoadInt64(&connections.Open),
+		Opened:   atomic.LoadInt64(&connections.Opened),
+		Requests: atomic.LoadInt64(&connections.Requests),
+		Reused:   atomic.LoadInt64(&connections.Reused),
+	}
+}
+
+func newTransport(o TransportOptions, to Timeouts) *http.Transport {
+	dialer := net.Dialer{Timeout: to.Dial, KeepAlive: time.Second * 30}
+
+	return &http.Transport{
+		Proxy: upstreamProxy,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, addr)
+
+			if err != nil {
+				return nil, err
+			}
+
+			atomic.AddInt64(&connections.Opened, 1)
+			atomic.AddInt64(&connections.Open, 1)
+
+			return &countedConn{Conn: conn}, nil
+		},
+		TLSClientConfig:       &tls.Config{InsecureSkipVerify: true},
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          o.MaxIdleConns,
+		MaxIdleConnsPerHost:   o.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       o.MaxConnsPerHost,
+		IdleConnTimeout:       o.IdleConnTimeout,
+		TLSHandshakeTimeout:   to.TLSHandshake,
+		ResponseHeaderTimeout: to.ResponseHeader,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+// upstreamClient returns the client used to send requests to upstream hosts, via the shared pool of connections
+func upstreamClient() *http.Client {
+	return &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse },
+		Transport:     pooledTransport{},
+	}
+}
+
+// pooledTransport is a http.RoundTripper that sends requests using the current shared transport, recording the use of
+// its connections
+type pooledTransport struct{}
+
+func (pooledTransport) RoundTrip(rq *http.Request) (*http.Response, error) {
+	transport.mx.RLock()
+	t, timeout := transport.t, transport.timeouts.Request
+	transport.mx.RUnlock()
+
+	trace := httptrace.ClientTrace{
+		GotConn: func(i httptrace.GotConnInfo) {
+			atomic.AddInt64(&connections.Requests, 1)
+
+			if i.Reused {
+				atomic.AddInt64(&connections.Reused, 1)
+				log.Printf(3, "reusing connection to [%v] for [%v], idle for [%v]", i.Conn.RemoteAddr(), rq.URL.String(), i.IdleTime)
+				return
+			}
+
+			log.Printf(3, "using new connection to [%v] for [%v]", i.Conn.RemoteAddr(), rq.URL.String())
+		},
+	}
+
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(rq.Context(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(rq.Context())
+	}
+
+	rs, err := t.RoundTrip(rq.WithContext(httptrace.WithClientTrace(ctx, &trace)))
+
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	rs.Body = cancelBody{ReadCloser: rs.Body, cancel: cancel}
+
+	return rs, nil
+}
+
+// cancelBody is a response body that cancels the context of its request once closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// countedConn is a net.Conn that is removed from the count of open connections when closed
+type countedConn struct {
+	net.Conn
+	once sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() { atomic.AddInt64(&connections.Open, -1) })
+	return c.Conn.Close()
+}
+
+// hopHeaders are the headers that describe a single connection and so are not forwarded by proxies, as per RFC 7230
+var hopHeaders = []string{"Connection", "Proxy-Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization", "Upgrade"}
+
+// removeHopHeaders removes hopHeaders, and any headers listed in the Connection header, from h. Where upgrade is true,
+// the Connection and Upgrade headers are retained so that the connection can be upgraded
+func removeHopHeaders(h http.Header, upgrade bool) {
+	for _, v := range h.Values("Connection") {
+		for _, k := range strings.Split(v, ",") {
+			if k = strings.TrimSpace(k); k != "" && !(upgrade && strings.EqualFold(k, "Upgrade")) {
+				h.Del(k)
+			}
+		}
+	}
+
+	for _, k := range hopHeaders {
+		if upgrade && (k == "Connection" || k == "Upgrade") {
+			continue
+		}
+
+		h.Del(k)
+	}
+}
@@ -0,0 +1,354 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"comradequinn/hflow/cert"
+	"comradequinn/hflow/log"
+	"comradequinn/hflow/proxy/intercept"
+	"comradequinn/hflow/proxy/internal/websocket"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+var tunnelID uint64
+
+// sniffTimeout is how long a tunnel waits for the hflow client to send data before treating the stream as raw tcp, so
+// that protocols in which the server speaks first are passed through
+const sniffTimeout = time.Second
+
+// serveTunnel serves the tunnel to host established by the hflow client at clientAddr on conn, with any data already
+// buffered from conn read from br. The stream is sniffed; TLS is terminated and, as with plaintext HTTP, the requests
+// in it are proxied using client, applying any intercepts. Any other stream is passed through to host as raw tcp.
+// Where host is empty, the host is read from the SNI of TLS streams and the Host header of plaintext HTTP requests
+func serveTunnel(client *http.Client, conn net.Conn, br *bufio.Reader, host, clientAddr string) {
+	tid := atomic.AddUint64(&tunnelID, 1)
+
+	log.Printf(3, "tunneling to [%v] on behalf of [%v] as tunnel [%v]", host, clientAddr, tid)
+
+	defer func() {
+		conn.Close()
+		log.Printf(3, "closed tunnel to [%v] on behalf of [%v]", host, clientAddr)
+
+		if err := recover(); err != nil {
+			log.Printf(0, "panic while tunneling from remote client [%v] to remote host [%v]. [%+v]", clientAddr, host, err)
+		}
+	}()
+
+	if err := conn.SetReadDeadline(time.Now().Add(sniffTimeout)); err != nil {
+		log.Printf(0, "error setting read deadline on connection with remote client [%v]. [%v]", clientAddr, err)
+		return
+	}
+
+	b, _ := br.Peek(1)
+
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		log.Printf(0, "error clearing read deadline on connection with remote client [%v]. [%v]", clientAddr, err)
+		return
+	}
+
+	switch {
+	case len(b) == 1 && b[0] == 0x16:
+		serveTLS(client, &bufferedConn{Conn: conn, r: br}, host, clientAddr, tid)
+	case len(b) == 1 && isHTTP(br):
+		serveHTTP(client, conn, br, "http", host, clientAddr, tid)
+	default:
+		relay(conn, br, host, clientAddr, tid)
+	}
+}
+
+// serveTLS terminates the TLS stream on conn, presenting a certificate for the server name requested by the hflow client,
+// and proxies the HTTP/1.1 or HTTP/2 requests in it
+func serveTLS(client *http.Client, conn net.Conn, host, clientAddr string, tid uint64) {
+	tlsConn := tls.Server(conn, &tls.Config{GetCertificate: cert.Get, NextProtos: []string{"h2", "http/1.1"}})
+
+	if err := conn.SetDeadline(deadline(timeouts().TLSHandshake)); err != nil {
+		log.Printf(0, "error setting deadline on connection with remote client [%v]. [%v]", clientAddr, err)
+		return
+	}
+
+	if err := tlsConn.Handshake(); err != nil {
+		if _, ok := timedOut(err); ok {
+			log.Printf(0, "tls handshake timeout with remote client [%v]. [%v]", clientAddr, err)
+			return
+		}
+
+		log.Printf(0, "tls handshake with remote client [%v] failed. [%v]", clientAddr, err)
+		return
+	}
+
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		log.Printf(0, "error clearing deadline on connection with remote client [%v]. [%v]", clientAddr, err)
+		return
+	}
+
+	sni := tlsConn.ConnectionState().ServerName
+
+	if host == "" {
+		if sni == "" {
+			log.Printf(0, "unable to determine the host requested by remote client [%v]; no server name was sent", clientAddr)
+			return
+		}
+
+		host = net.JoinHostPort(sni, "443")
+	}
+
+	if h, p, err := net.SplitHostPort(host); err == nil && net.ParseIP(h) != nil && sni != "" {
+		host = net.JoinHostPort(sni, p)
+	}
+
+	if tlsConn.ConnectionState().NegotiatedProtocol == "h2" {
+		log.Printf(3, "serving http/2 in tunnel [%v] to [%v] on behalf of [%v]", tid, host, clientAddr)
+
+		serveHTTP2(tlsConn, http.HandlerFunc(func(rw http.ResponseWriter, rq *http.Request) {
+			x := intercept.NewExchange(clientAddr, tid)
+			x.Proto = rq.Proto
+
+			log.Printf(1, "<<< received http/2 proxy request for [%v] on host [%v] as exchange [%v] in tunnel [%v]", rq.URL.String(), rq.Host, x.ID, tid)
+
+			rq.URL.Scheme, rq.URL.Host = "https", host
+
+			proxyExchange(client, x, rw, rq)
+		}))
+
+		return
+	}
+
+	serveHTTP(client, tlsConn, bufio.NewReader(tlsConn), "https", host, clientAddr, tid)
+}
+
+// serveHTTP proxies the HTTP/1.1 requests read from br, being a reader of conn, to host, or the host of each request
+// where host is empty, using scheme until conn closes or is idle for longer than the client idle timeout
+func serveHTTP(client *http.Client, conn net.Conn, br *bufio.Reader, scheme, host, clientAddr string, tid uint64) {
+	eof := func(br *bufio.Reader) bool {
+		log.Printf(3, "waiting to receive from remote client [%v]", clientAddr)
+
+		idle := timeouts().ClientIdle
+
+		if err := conn.SetReadDeadline(deadline(idle)); err != nil {
+			log.Printf(0, "error setting read deadline on connection with remote client [%v]. [%v]", clientAddr, err)
+			return true
+		}
+
+		if _, err := br.Peek(1); err != nil {
+			if _, ok := timedOut(err); ok {
+				log.Printf(2, "client idle timeout; closing connection with remote client [%v] after [%v]", clientAddr, idle)
+				return true
+			}
+
+			log.Printf(3, "unable to read from connection with remote client [%v]. [%v]", clientAddr, err)
+			return true
+		}
+
+		log.Printf(3, "receiving from remote client [%v]", clientAddr)
+
+		return false
+	}
+
+	for !eof(br) {
+		rq, err := http.ReadRequest(br)
+
+		if err != nil {
+			log.Printf(0, "error reading %v request from remote client [%v]. [%v]", scheme, clientAddr, err)
+			return
+		}
+
+		x := intercept.NewExchange(clientAddr, tid)
+		x.Proto = rq.Proto
+
+		log.Printf(1, "<<< received proxy request for [%v] on host [%v] as exchange [%v] in tunnel [%v]", rq.URL.String(), rq.Host, x.ID, tid)
+
+		rq.RequestURI, rq.URL.Scheme, rq.URL.Host = "", scheme, host
+
+		if host == "" {
+			if rq.Host == "" {
+				log.Printf(0, "unable to determine the host requested by remote client [%v]; no host header was sent", clientAddr)
+				return
+			}
+
+			rq.URL.Host = rq.Host
+		}
+
+		ws := websocket.IsUpgrade(rq)
+
+		if ws {
+			rq.Header.Del("Sec-WebSocket-Extensions")
+		}
+
+		removeHopHeaders(rq.Header, ws)
+
+		var (
+			rs       *http.Response
+			upstream net.Conn
+			ur       *bufio.Reader
+		)
+
+		rq, rs, err = intercept.Request(x, rq, Intercepts())
+
+		if err == intercept.ErrDrop {
+			log.Printf(2, "dropped %v request from remote client [%v]", scheme, clientAddr)
+			return
+		}
+
+		if err != nil {
+			log.Printf(0, "error intercepting %v request from remote client [%v]. [%v]", scheme, clientAddr, err)
+			return
+		}
+
+		if rs == nil {
+			log.Printf(3, ">>> requesting [%v] from host [%v]", rq.URL.String(), rq.Host)
+
+			if ws {
+				upstream, ur, rs, err = dialWebSocket(rq)
+			} else {
+				rs, err = client.Do(rq)
+			}
+
+			if err != nil {
+				if err := errorResponse(rq, upstreamError(rq, err)).Write(conn); err != nil {
+					log.Printf(0, "error writing error response for [%v] on [%v] to remote client [%v]. [%v]", rq.URL.String(), rq.Host, clientAddr, err)
+				}
+
+				return
+			}
+
+			if upstream != nil {
+				defer upstream.Close()
+			}
+
+			log.Printf(3, "<<< received [%v] in response to [%v] on [%v]", rs.StatusCode, rq.URL.String(), rq.Host)
+
+			removeHopHeaders(rs.Header, rs.StatusCode == http.StatusSwitchingProtocols)
+		}
+
+		rs, err = intercept.Response(x, rq, rs, Intercepts())
+
+		if err == intercept.ErrDrop {
+			log.Printf(2, "dropped response to [%v] on host [%v]", rq.URL.String(), rq.Host)
+			return
+		}
+
+		if err != nil {
+			log.Printf(0, "error intercepting response to [%v] on host [%v]: [%v]", rq.URL.String(), rq.Host, err)
+			return
+		}
+
+		rs.Proto, rs.ProtoMajor, rs.ProtoMinor = "HTTP/1.1", 1, 1
+
+		if err := rs.Write(conn); err != nil {
+			log.Printf(0, "error writing response for [%v] on [%v] to remote client [%v]. [%v]", rq.URL.String(), rq.Host, clientAddr, err)
+			return
+		}
+
+		if upstream != nil {
+			if rs.StatusCode == http.StatusSwitchingProtocols {
+				if err := conn.SetReadDeadline(time.Time{}); err != nil {
+					log.Printf(0, "error clearing read deadline on connection with remote client [%v]. [%v]", clientAddr, err)
+					return
+				}
+
+				spliceWebSocket(x, rq, conn, br, upstream, ur)
+			}
+
+			return
+		}
+
+		log.Printf(2, ">>> wrote proxy response for [%v] on [%v]", rq.URL.String(), rq.Host)
+	}
+}
+
+// relay passes the stream on conn, with any data already buffered from conn read from br, through to host unchanged
+// until either side closes, then logs the bytes sent and received
+func relay(conn net.Conn, br *bufio.Reader, host, clientAddr string, tid uint64) {
+	if host == "" {
+		log.Printf(0, "unable to relay tcp on behalf of remote client [%v]; the host is unknown", clientAddr)
+		return
+	}
+
+	upstream, err := dialUpstream(&url.URL{Scheme: "tcp", Host: host}, host)
+
+	if err != nil {
+		log.Printf(0, "error connecting to [%v] on behalf of remote client [%v]. [%v]", host, clientAddr, err)
+		return
+	}
+
+	defer upstream.Close()
+
+	log.Printf(2, "relaying tcp in tunnel [%v] to [%v] on behalf of [%v]", tid, host, clientAddr)
+
+	var sent, received int64
+
+	idle := timeouts().TunnelIdle
+
+	watch, stop := watchIdle(idle, func() {
+		log.Printf(1, "tunnel idle timeout; closing tunnel [%v] to [%v] on behalf of [%v] after [%v]", tid, host, clientAddr, idle)
+		conn.Close()
+		upstream.Close()
+	})
+
+	defer stop()
+
+	done := make(chan struct{}, 2)
+
+	forward := func(n *int64, src io.Reader, dst net.Conn) {
+		defer func() { done <- struct{}{} }()
+
+		*n, _ = io.Copy(dst, src)
+
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+			return
+		}
+
+		dst.Close()
+	}
+
+	go forward(&sent, watch(br), upstream)
+	go forward(&received, watch(upstream), conn)
+
+	<-done
+	<-done
+
+	log.Printf(1, "relayed tcp in tunnel [%v] to [%v] on behalf of [%v]; sent [%v] bytes, received [%v] bytes", tid, host, clientAddr, sent, received)
+}
+
+// isHTTP returns true where the data buffered in br starts with a HTTP/1.x request line
+func isHTTP(br *bufio.Reader) bool {
+	b, _ := br.Peek(br.Buffered())
+
+	i := bytes.IndexByte(b, ' ')
+
+	if i < 1 {
+		return false
+	}
+
+	switch string(b[:i]) {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+		http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+
+	return false
+}
+
+// bufferedConn is a net.Conn that reads from r, being a reader of the embedded net.Conn that may hold buffered data
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) { return c.r.Read(b) }
+
+// CloseWrite closes the writing side of the embedded net.Conn where it supports doing so, otherwise it closes it
+func (c *bufferedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+
+	return c.Conn.Close()
+}
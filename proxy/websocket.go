@@ -0,0 +1,191 @@
+package proxy
+
+import (
+	"bufio"
+	"comradequinn/hflow/log"
+	"comradequinn/hflow/proxy/intercept"
+	"comradequinn/hflow/proxy/internal/websocket"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// dialWebSocket sends the websocket upgrade request rq to the upstream host over a new connection and returns the
+// connection, a reader of it and the response of the upstream host
+func dialWebSocket(rq *http.Request) (net.Conn, *bufio.Reader, *http.Response, error) {
+	addr, secure := rq.URL.Host, rq.URL.Scheme == "https" || rq.URL.Scheme == "wss"
+
+	if rq.URL.Port() == "" {
+		port := "80"
+
+		if secure {
+			port = "443"
+		}
+
+		addr = net.JoinHostPort(rq.URL.Hostname(), port)
+	}
+
+	conn, err := dialUpstream(rq.URL, addr)
+
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	to := timeouts()
+
+	if secure {
+		tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true, ServerName: rq.URL.Hostname(), NextProtos: []string{"http/1.1"}})
+
+		if err = conn.SetDeadline(deadline(to.TLSHandshake)); err == nil {
+			err = tlsConn.Handshake()
+		}
+
+		if err != nil {
+			conn.Close()
+			return nil, nil, nil, err
+		}
+
+		conn = tlsConn
+	}
+
+	if err = rq.Write(conn); err != nil {
+		conn.Close()
+		return nil, nil, nil, err
+	}
+
+	var rs *http.Response
+
+	br := bufio.NewReader(conn)
+
+	if err = conn.SetDeadline(deadline(to.ResponseHeader)); err == nil {
+		if rs, err = http.ReadResponse(br, rq); err == nil {
+			err = conn.SetDeadline(time.Time{})
+		}
+	}
+
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, err
+	}
+
+	return conn, br, rs, nil
+}
+
+// spliceWebSocket forwards frames between the client and upstream connections of the websocket upgraded by rq in exchange x,
+// applying any frame intercepts to them, until either connection closes. Data already buffered from either connection is
+// read from cr and ur respectively
+func spliceWebSocket(x intercept.Exchange, rq *http.Request, client net.Conn, cr io.Reader, upstream net.Conn, ur io.Reader) {
+	apply, closed := intercept.Frames(x, rq, Intercepts())
+	defer closed()
+
+	log.Printf(2, "splicing websocket for [%v] in exchange [%v]", rq.URL.String(), x.ID)
+
+	idle := timeouts().TunnelIdle
+
+	watch, stop := watchIdle(idle, func() {
+		log.Printf(1, "tunnel idle timeout; closing websocket for [%v] in exchange [%v] after [%v]", rq.URL.String(), x.ID, idle)
+		client.Close()
+		upstream.Close()
+	})
+
+	defer stop()
+
+	done := make(chan struct{}, 2)
+
+	forward := func(direction string, src io.Reader, dst io.Writer) {
+		defer func() { done <- struct{}{} }()
+
+		if err := forwardFrames(x, direction, src, dst, apply); err != nil {
+			log.Printf(3, "stopped forwarding %v websocket frames for [%v]: [%v]", direction, rq.URL.String(), err)
+		}
+	}
+
+	go forward(intercept.FrameSent, watch(cr), upstream)
+	go forward(intercept.FrameReceived, watch(ur), client)
+
+	<-done
+
+	client.Close()
+	upstream.Close()
+
+	<-done
+
+	log.Printf(2, "closed websocket for [%v] in exchange [%v]", rq.URL.String(), x.ID)
+}
+
+// forwardFrames reads frames from src, applies apply to each, and writes them to dst until an error occurs. Frames with
+// payloads larger than the buffer limit are forwarded unchanged, with apply passed them without their payload
+func forwardFrames(x intercept.Exchange, direction string, src io.Reader, dst io.Writer, apply func(*intercept.Frame) error) error {
+	for {
+		h, err := websocket.ReadHeader(src)
+
+		if err != nil {
+			return err
+		}
+
+		f := &intercept.Frame{Exchange: x, Direction: direction, Time: time.Now(), Opcode: int(h.Opcode), Fin: h.Fin, Size: h.Length}
+
+		if h.Length > intercept.BufferLimit() {
+			log.Printf(1, "%v websocket frame of [%v] bytes exceeds the buffer limit, forwarding it unchanged", direction, h.Length)
+
+			if err = apply(f); err == intercept.ErrDrop {
+				if _, err = io.CopyN(io.Discard, src, h.Length); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			if err != nil {
+				return err
+			}
+
+			if err = websocket.WriteHeader(dst, h); err != nil {
+				return err
+			}
+
+			if _, err = io.CopyN(dst, src, h.Length); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		p := make([]byte, h.Length)
+
+		if _, err = io.ReadFull(src, p); err != nil {
+			return err
+		}
+
+		if h.Masked {
+			websocket.Mask(h.Key, p)
+		}
+
+		f.Payload = p
+
+		if err = apply(f); err == intercept.ErrDrop {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		p = append([]byte(nil), f.Payload...)
+		h.Length = int64(len(p))
+
+		if h.Masked {
+			websocket.Mask(h.Key, p)
+		}
+
+		if err = websocket.WriteHeader(dst, h); err != nil {
+			return err
+		}
+
+		if _, err = dst.Write(p); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package rules
+
+import (
+	"comradequinn/hflow/proxy/intercept"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+const (
+	onRequest  = "request"
+	onResponse = "response"
+)
+
+// funcs returns the intercept.RequestFunc and intercept.ResponseFunc described by a, either of which may be nil
+// where a does not apply to requests or responses
+func (a Action) funcs() (intercept.RequestFunc, intercept.ResponseFunc, error) {
+	on := a.On
+
+	if on == "" {
+		on = onRequest
+	}
+
+	if on != onRequest && on != onResponse {
+		return nil, nil, fmt.Errorf("invalid value for on [%v], expected [%v] or [%v]", a.On, onRequest, onResponse)
+	}
+
+	var (
+		rx  *regexp.Regexp
+		err error
+	)
+
+	if a.Find != "" {
+		if rx, err = regexp.Compile(a.Find); err != nil {
+			return nil, nil, fmt.Errorf("invalid find regex [%v]: [%v]", a.Find, err)
+		}
+	}
+
+	switch a.Type {
+	case "set_header", "remove_header":
+		if a.Name == "" {
+			return nil, nil, fmt.Errorf("%v action requires a name", a.Type)
+		}
+
+		f := func(h http.Header) { h.Set(a.Name, a.Value) }
+
+		if a.Type == "remove_header" {
+			f = func(h http.Header) { h.Del(a.Name) }
+		}
+
+		if on == onResponse {
+			return nil, func(rs *intercept.ProxyResponse) error { f(rs.Header); return nil }, nil
+		}
+
+		return func(r *intercept.ProxyRequest) error { f(r.Header); return nil }, nil, nil
+	case "rewrite_url":
+		if rx == nil {
+			return nil, nil, fmt.Errorf("rewrite_url action requires a find regex")
+		}
+
+		return func(r *intercept.ProxyRequest) error {
+			u, err := url.Parse(rx.ReplaceAllString(r.URL.String(), a.Replace))
+
+			if err != nil {
+				return fmt.Errorf("rewritten url is invalid: [%v]", err)
+			}
+
+			r.URL = *u
+
+			return nil
+		}, nil, nil
+	case "replace_body":
+		f := func(b []byte) []byte {
+			if rx == nil {
+				return []byte(a.Replace)
+			}
+
+			return rx.ReplaceAll(b, []byte(a.Replace))
+		}
+
+		if on == onResponse {
+			return nil, func(rs *intercept.ProxyResponse) error { rs.Body = f(rs.Body); return nil }, nil
+		}
+
+		return func(r *intercept.ProxyRequest) error { r.Body = f(r.Body); return nil }, nil, nil
+	case "mock":
+		if http.StatusText(a.Status) == "" {
+			return nil, nil, fmt.Errorf("mock action requires a valid status, got [%v]", a.Status)
+		}
+
+		return func(r *intercept.ProxyRequest) error {
+			h := http.Header{}
+
+			for k, v := range a.Headers {
+				h.Set(k, v)
+			}
+
+			r.Response = intercept.NewResponse(a.Status, h, []byte(a.Body))
+
+			return nil
+		}, nil, nil
+	case "delay":
+		d, err := time.ParseDuration(a.Duration)
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("delay action requires a valid duration, got [%v]: [%v]", a.Duration, err)
+		}
+
+		if on == onResponse {
+			return nil, func(*intercept.ProxyResponse) error { time.Sleep(d); return nil }, nil
+		}
+
+		return func(r *intercept.ProxyRequest) error { r.Delay += d; return nil }, nil, nil
+	case "drop":
+		if on == onResponse {
+			return nil, func(*intercept.ProxyResponse) error { return intercept.ErrDrop }, nil
+		}
+
+		return func(*intercept.ProxyRequest) error { return intercept.ErrDrop }, nil, nil
+	case "map_local":
+		if a.URL == "" || a.Local == "" {
+			return nil, nil, fmt.Errorf("map_local action requires a url and a local path")
+		}
+
+		return intercept.ServeLocal(a.URL, a.Local), nil, nil
+	case "map_remote":
+		if a.URL == "" || a.To == "" {
+			return nil, nil, fmt.Errorf("map_remote action requires a url and a to location")
+		}
+
+		rqf, err := intercept.Remote{From: a.URL, To: a.To, PreserveHost: a.PreserveHost, Host: a.Host}.RequestFunc()
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return rqf, nil, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported action type [%v]", a.Type)
+	}
+}
@@ -0,0 +1,91 @@
+package rules
+
+import (
+	"bytes"
+	"comradequinn/hflow/proxy/intercept"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Funcs returns the intercept.MatchRequestFunc and intercept.MatchResponseFunc described by m
+func (m Match) Funcs() (intercept.MatchRequestFunc, intercept.MatchResponseFunc, error) {
+	for _, p := range []string{m.Host, m.Path} {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, nil, fmt.Errorf("invalid glob pattern [%v]: [%v]", p, err)
+		}
+	}
+
+	var (
+		rx  *regexp.Regexp
+		err error
+	)
+
+	if m.PathRegex != "" {
+		if rx, err = regexp.Compile(m.PathRegex); err != nil {
+			return nil, nil, fmt.Errorf("invalid path regex [%v]: [%v]", m.PathRegex, err)
+		}
+	}
+
+	headers := http.Header{}
+
+	for k, v := range m.Headers {
+		headers.Set(k, v)
+	}
+
+	mrq := func(r *intercept.ProxyRequest) (bool, error) {
+		if m.Host != "" {
+			if ok, _ := path.Match(strings.ToLower(m.Host), strings.ToLower(r.URL.Hostname())); !ok {
+				return false, nil
+			}
+		}
+
+		if m.Path != "" {
+			if ok, _ := path.Match(m.Path, r.URL.Path); !ok {
+				return false, nil
+			}
+		}
+
+		if rx != nil && !rx.MatchString(r.URL.Path) {
+			return false, nil
+		}
+
+		if m.Method != "" && !strings.EqualFold(m.Method, r.Method) {
+			return false, nil
+		}
+
+		for k := range headers {
+			vs, ok := r.Header[k]
+
+			if !ok || !strings.Contains(strings.Join(vs, " "), headers.Get(k)) {
+				return false, nil
+			}
+		}
+
+		if m.Body != "" && !bytes.Contains(r.Body, []byte(m.Body)) {
+			return false, nil
+		}
+
+		return true, nil
+	}
+
+	mrs := func(r *intercept.ProxyRequest, rs *intercept.ProxyResponse) (bool, error) {
+		if ok, err := mrq(r); !ok || err != nil {
+			return ok, err
+		}
+
+		if m.Status != "" && !strings.Contains(rs.Status, m.Status) {
+			return false, nil
+		}
+
+		if m.ResponseBody != "" && !bytes.Contains(rs.Body, []byte(m.ResponseBody)) {
+			return false, nil
+		}
+
+		return true, nil
+	}
+
+	return mrq, mrs, nil
+}
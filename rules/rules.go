@@ -0,0 +1,217 @@
+// Package rules provides the loading of declarative intercept rules from JSON or YAML files
+package rules
+
+import (
+	"bytes"
+	"comradequinn/hflow/log"
+	"comradequinn/hflow/proxy/intercept"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// File describes the contents of a rules file
+type File struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+// Rule describes an intercept as the conditions that an exchange must match and the actions to apply to it when it does
+type Rule struct {
+	Name     string   `json:"name" yaml:"name"`
+	Priority int      `json:"priority,omitempty" yaml:"priority,omitempty"`
+	Match    Match    `json:"match" yaml:"match"`
+	Actions  []Action `json:"actions" yaml:"actions"`
+}
+
+// Match describes the conditions an exchange must meet for a Rule's actions to be applied. Unset conditions match all
+// exchanges. Status and ResponseBody only constrain actions applied to responses
+type Match struct {
+	// Host is a glob pattern matched against the request host, excluding any port
+	Host string `json:"host,omitempty" yaml:"host,omitempty"`
+	// Path is a glob pattern matched against the request path
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+	// PathRegex is a regular expression matched against the request path
+	PathRegex string `json:"path_regex,omitempty" yaml:"path_regex,omitempty"`
+	// Method is matched, case insensitively, against the request method
+	Method string `json:"method,omitempty" yaml:"method,omitempty"`
+	// Headers are request headers that must be present and contain the specified value, an empty value matches any value
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	// Body is text that the request body must contain
+	Body string `json:"body,omitempty" yaml:"body,omitempty"`
+	// Status is text that the response status must contain
+	Status string `json:"status,omitempty" yaml:"status,omitempty"`
+	// ResponseBody is text that the response body must contain
+	ResponseBody string `json:"response_body,omitempty" yaml:"response_body,omitempty"`
+}
+
+// Action describes a change to apply to an exchange. The fields used depend on the Type of the action
+//
+// * set_header: sets the header Name to Value on the request or response specified by On
+// * remove_header: removes the header Name from the request or response specified by On
+// * rewrite_url: replaces matches of the regular expression Find in the request url with Replace
+// * replace_body: replaces matches of the regular expression Find in the request or response body specified by On with
+//   Replace, or the whole body with Replace when Find is empty
+// * mock: responds with Status, Headers and Body without contacting the upstream host
+// * delay: delays the request or response specified by On by Duration
+// * drop: closes the client connection without responding
+// * map_local: responds to requests with a url starting with URL using the local file or directory at Local, as per
+//   intercept.MapLocal
+// * map_remote: routes requests within the location URL to the location To, sending Host as the Host header where set,
+//   or the original host where PreserveHost is true, as per intercept.MapRemote
+type Action struct {
+	Type         string            `json:"type" yaml:"type"`
+	On           string            `json:"on,omitempty" yaml:"on,omitempty"`
+	Name         string            `json:"name,omitempty" yaml:"name,omitempty"`
+	Value        string            `json:"value,omitempty" yaml:"value,omitempty"`
+	Find         string            `json:"find,omitempty" yaml:"find,omitempty"`
+	Replace      string            `json:"replace,omitempty" yaml:"replace,omitempty"`
+	Status       int               `json:"status,omitempty" yaml:"status,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	Body         string            `json:"body,omitempty" yaml:"body,omitempty"`
+	Duration     string            `json:"duration,omitempty" yaml:"duration,omitempty"`
+	URL          string            `json:"url,omitempty" yaml:"url,omitempty"`
+	Local        string            `json:"local,omitempty" yaml:"local,omitempty"`
+	To           string            `json:"to,omitempty" yaml:"to,omitempty"`
+	Host         string            `json:"host,omitempty" yaml:"host,omitempty"`
+	PreserveHost bool              `json:"preserve_host,omitempty" yaml:"preserve_host,omitempty"`
+}
+
+// Load reads the rules file at path. Files with a .json extension are read as JSON, all others as YAML
+func Load(path string) ([]Rule, error) {
+	b, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read rules file [%v]: [%v]", path, err)
+	}
+
+	format := "yaml"
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		format = "json"
+	}
+
+	rs, err := Parse(b, format)
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse rules file [%v]: [%v]", path, err)
+	}
+
+	log.Printf(1, "loaded [%v] rules from [%v]", len(rs), path)
+
+	return rs, nil
+}
+
+// Parse returns the rules described by b in the specified format, either json or yaml
+func Parse(b []byte, format string) ([]Rule, error) {
+	f := File{}
+
+	switch format {
+	case "json":
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.DisallowUnknownFields()
+
+		if err := d.Decode(&f); err != nil {
+			return nil, fmt.Errorf("invalid json: [%v]", err)
+		}
+	case "yaml":
+		d := yaml.NewDecoder(bytes.NewReader(b))
+		d.KnownFields(true)
+
+		if err := d.Decode(&f); err != nil {
+			return nil, fmt.Errorf("invalid yaml: [%v]", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported rules format [%v]", format)
+	}
+
+	names := map[string]bool{}
+
+	for i, r := range f.Rules {
+		if r.Name == "" {
+			return nil, fmt.Errorf("rule at index [%v] has no name", i)
+		}
+
+		if names[r.Name] {
+			return nil, fmt.Errorf("rule name [%v] is not unique", r.Name)
+		}
+
+		names[r.Name] = true
+
+		if _, err := r.Intercept(); err != nil {
+			return nil, err
+		}
+	}
+
+	return f.Rules, nil
+}
+
+// Intercepts returns an *intercept.Intercept for each of rs
+func Intercepts(rs []Rule) ([]*intercept.Intercept, error) {
+	is := make([]*intercept.Intercept, 0, len(rs))
+
+	for _, r := range rs {
+		i, err := r.Intercept()
+
+		if err != nil {
+			return nil, err
+		}
+
+		is = append(is, i)
+	}
+
+	return is, nil
+}
+
+// Intercept returns an *intercept.Intercept that applies r. It requires buffering where r matches or replaces a body
+func (r Rule) Intercept() (*intercept.Intercept, error) {
+	mrq, mrs, err := r.Match.Funcs()
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid match in rule [%v]: [%v]", r.Name, err)
+	}
+
+	rqfs, rsfs, buffered := []intercept.RequestFunc{}, []intercept.ResponseFunc{}, r.Match.Body != "" || r.Match.ResponseBody != ""
+
+	for i, a := range r.Actions {
+		rqf, rsf, err := a.funcs()
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid action at index [%v] in rule [%v]: [%v]", i, r.Name, err)
+		}
+
+		if rqf != nil {
+			rqfs = append(rqfs, rqf)
+		}
+
+		if rsf != nil {
+			rsfs = append(rsfs, rsf)
+		}
+
+		buffered = buffered || a.Type == "replace_body"
+	}
+
+	return intercept.NewIntercept("rule "+r.Name, mrq, mrs,
+		func(pr *intercept.ProxyRequest) error {
+			for _, f := range rqfs {
+				if err := f(pr); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+		func(pr *intercept.ProxyResponse) error {
+			for _, f := range rsfs {
+				if err := f(pr); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	).WithPriority(r.Priority).WithBuffering(buffered), nil
+}
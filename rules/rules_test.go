@@ -0,0 +1,211 @@
+package rules
+
+import (
+	"comradequinn/hflow/proxy/intercept"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const yamlRules = `
+rules:
+  - name: rewrite
+    match:
+      host: "*.test.com"
+      path: /api/*
+      method: post
+      headers:
+        Rq-Hk: Rq-Hv
+    actions:
+      - type: set_header
+        name: Set-Hk
+        value: Set-Hv
+      - type: remove_header
+        name: Rq-Hk
+      - type: rewrite_url
+        find: www\.test\.com
+        replace: localhost:9000
+      - type: replace_body
+        find: rq
+        replace: replaced
+  - name: mock
+    match:
+      path_regex: ^/mock/\d+$
+    actions:
+      - type: mock
+        status: 404
+        headers:
+          Mock-Hk: Mock-Hv
+        body: mock-body
+  - name: drop
+    match:
+      body: drop-me
+    actions:
+      - type: drop
+`
+
+const jsonRules = `{ "rules": [ { "name": "mock", "match": { "path": "/mock/*" }, "actions": [ { "type": "mock", "status": 200, "body": "mock-body" } ] } ] }`
+
+func TestParse(t *testing.T) {
+	test := func(t *testing.T, data, format string, expectErr bool) {
+		_, err := Parse([]byte(data), format)
+
+		if expectErr != (err != nil) {
+			t.Fatalf("expected error to be [%v] parsing [%v] rules, got [%v]", expectErr, format, err)
+		}
+	}
+
+	t.Run("YAML", func(t *testing.T) { test(t, yamlRules, "yaml", false) })
+	t.Run("JSON", func(t *testing.T) { test(t, jsonRules, "json", false) })
+	t.Run("UnknownField", func(t *testing.T) { test(t, `{ "rules": [ { "name": "x", "unknown": 1 } ] }`, "json", true) })
+	t.Run("MissingName", func(t *testing.T) { test(t, `{ "rules": [ { "actions": [] } ] }`, "json", true) })
+	t.Run("DuplicateName", func(t *testing.T) { test(t, `{ "rules": [ { "name": "x" }, { "name": "x" } ] }`, "json", true) })
+	t.Run("UnknownAction", func(t *testing.T) { test(t, `{ "rules": [ { "name": "x", "actions": [ { "type": "unknown" } ] } ] }`, "json", true) })
+	t.Run("InvalidRegex", func(t *testing.T) { test(t, `{ "rules": [ { "name": "x", "match": { "path_regex": "(" } } ] }`, "json", true) })
+	t.Run("InvalidMapLocal", func(t *testing.T) { test(t, `{ "rules": [ { "name": "x", "actions": [ { "type": "map_local", "url": "http://x/" } ] } ] }`, "json", true) })
+	t.Run("InvalidMapRemote", func(t *testing.T) { test(t, `{ "rules": [ { "name": "x", "actions": [ { "type": "map_remote", "url": "x", "to": "http://" } ] } ] }`, "json", true) })
+	t.Run("InvalidDelay", func(t *testing.T) { test(t, `{ "rules": [ { "name": "x", "actions": [ { "type": "delay", "duration": "x" } ] } ] }`, "json", true) })
+}
+
+func TestIntercepts(t *testing.T) {
+	rs, err := Parse([]byte(yamlRules), "yaml")
+
+	if err != nil {
+		t.Fatalf("expected no error parsing rules, got [%v]", err)
+	}
+
+	is, err := Intercepts(rs)
+
+	if err != nil {
+		t.Fatalf("expected no error creating intercepts, got [%v]", err)
+	}
+
+	intercepts := map[int]*intercept.Intercept{}
+
+	for i, icpt := range is {
+		intercepts[i+1] = icpt
+	}
+
+	request := func(method, url, body string, h http.Header) (*http.Request, *http.Response, error) {
+		rq, _ := http.NewRequest(method, url, strings.NewReader(body))
+
+		for k, v := range h {
+			rq.Header[k] = v
+		}
+
+		return intercept.Request(intercept.NewExchange("127.0.0.1:50000", 0), rq, intercepts)
+	}
+
+	t.Run("Rewrite", func(t *testing.T) {
+		rq, rs, err := request(http.MethodPost, "http://www.test.com/api/users", "rq-body", http.Header{"Rq-Hk": []string{"Rq-Hv"}})
+
+		if err != nil || rs != nil {
+			t.Fatalf("expected request with no error or response, got [%v] and [%v]", err, rs)
+		}
+
+		b, _ := io.ReadAll(rq.Body)
+
+		if rq.URL.String() != "http://localhost:9000/api/users" || rq.Header.Get("Set-Hk") != "Set-Hv" || rq.Header.Get("Rq-Hk") != "" || string(b) != "replaced-body" {
+			t.Fatalf("expected rewritten request, got url [%v], headers [%v] and body [%v]", rq.URL.String(), rq.Header, string(b))
+		}
+	})
+
+	t.Run("NoMatch", func(t *testing.T) {
+		rq, _, _ := request(http.MethodGet, "http://www.test.com/api/users", "rq-body", http.Header{"Rq-Hk": []string{"Rq-Hv"}})
+
+		if rq.Header.Get("Set-Hk") != "" {
+			t.Fatalf("expected request not to match rule due to method")
+		}
+	})
+
+	t.Run("Mock", func(t *testing.T) {
+		_, rs, err := request(http.MethodGet, "http://www.test.com/mock/1", "", nil)
+
+		if err != nil || rs == nil {
+			t.Fatalf("expected mock response with no error, got [%v] and [%v]", rs, err)
+		}
+
+		b, _ := io.ReadAll(rs.Body)
+
+		if rs.StatusCode != http.StatusNotFound || rs.Header.Get("Mock-Hk") != "Mock-Hv" || string(b) != "mock-body" {
+			t.Fatalf("expected mock response, got status [%v], headers [%v] and body [%v]", rs.StatusCode, rs.Header, string(b))
+		}
+	})
+
+	t.Run("MapLocal", func(t *testing.T) {
+		dir := t.TempDir()
+		os.WriteFile(filepath.Join(dir, "local.txt"), []byte("local-body"), 0644)
+
+		i, err := Rule{Name: "local", Actions: []Action{{Type: "map_local", URL: "http://www.test.com/local/", Local: dir}}}.Intercept()
+
+		if err != nil {
+			t.Fatalf("expected no error creating map_local intercept, got [%v]", err)
+		}
+
+		rq, _ := http.NewRequest(http.MethodGet, "http://www.test.com/local/local.txt", nil)
+		_, rs, err := intercept.Request(intercept.NewExchange("127.0.0.1:50000", 0), rq, map[int]*intercept.Intercept{1: i})
+
+		if err != nil || rs == nil {
+			t.Fatalf("expected local response with no error, got [%v] and [%v]", rs, err)
+		}
+
+		if b, _ := io.ReadAll(rs.Body); string(b) != "local-body" {
+			t.Fatalf("expected local file content, got [%v]", string(b))
+		}
+	})
+
+	t.Run("MapRemote", func(t *testing.T) {
+		i, err := Rule{Name: "remote", Actions: []Action{{Type: "map_remote", URL: "www.test.com/api", To: "http://localhost:9000/", PreserveHost: true}}}.Intercept()
+
+		if err != nil {
+			t.Fatalf("expected no error creating map_remote intercept, got [%v]", err)
+		}
+
+		rq, _ := http.NewRequest(http.MethodGet, "https://www.test.com/api/users?id=1", nil)
+		rq, _, err = intercept.Request(intercept.NewExchange("127.0.0.1:50000", 0), rq, map[int]*intercept.Intercept{1: i})
+
+		if err != nil {
+			t.Fatalf("expected no error mapping request, got [%v]", err)
+		}
+
+		if rq.URL.String() != "http://localhost:9000/users?id=1" || rq.Host != "www.test.com" {
+			t.Fatalf("expected request to be mapped to [http://localhost:9000/users?id=1] with host [www.test.com], got [%v] and [%v]", rq.URL.String(), rq.Host)
+		}
+	})
+
+	t.Run("EmptyBody", func(t *testing.T) {
+		test := func(t *testing.T, method string, statusCode int, expContentLength string) {
+			i, err := Rule{Name: "empty", Actions: []Action{{Type: "replace_body", On: "response", Replace: ""}}}.Intercept()
+
+			if err != nil {
+				t.Fatalf("expected no error creating replace_body intercept, got [%v]", err)
+			}
+
+			rq, _ := http.NewRequest(method, "http://www.test.com/", nil)
+			hrs := &http.Response{StatusCode: statusCode, Header: http.Header{"Content-Length": []string{"9"}}, Body: io.NopCloser(strings.NewReader("rs-body-1")), ContentLength: 9, Request: rq}
+
+			rs, err := intercept.Response(intercept.NewExchange("127.0.0.1:50000", 0), rq, hrs, map[int]*intercept.Intercept{1: i})
+
+			if err != nil {
+				t.Fatalf("expected no error replacing response body, got [%v]", err)
+			}
+
+			if b, _ := io.ReadAll(rs.Body); len(b) != 0 || rs.Header.Get("Content-Length") != expContentLength {
+				t.Fatalf("expected empty body with content length [%v], got [%v] and [%v]", expContentLength, string(b), rs.Header.Get("Content-Length"))
+			}
+		}
+
+		t.Run("OK", func(t *testing.T) { test(t, http.MethodGet, http.StatusOK, "0") })
+		t.Run("Head", func(t *testing.T) { test(t, http.MethodHead, http.StatusOK, "9") })
+		t.Run("NotModified", func(t *testing.T) { test(t, http.MethodGet, http.StatusNotModified, "9") })
+	})
+
+	t.Run("Drop", func(t *testing.T) {
+		if _, _, err := request(http.MethodPost, "http://www.test.com/", "please drop-me", nil); err != intercept.ErrDrop {
+			t.Fatalf("expected request to be dropped, got [%v]", err)
+		}
+	})
+}
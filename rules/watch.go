@@ -0,0 +1,84 @@
+package rules
+
+import (
+	"comradequinn/hflow/log"
+	"os"
+	"reflect"
+	"time"
+)
+
+// Diff returns the names of the rules that were added, changed or removed in next when compared with prev
+func Diff(prev, next []Rule) (added, changed, removed []string) {
+	pm := map[string]Rule{}
+
+	for _, r := range prev {
+		pm[r.Name] = r
+	}
+
+	for _, r := range next {
+		p, ok := pm[r.Name]
+
+		switch {
+		case !ok:
+			added = append(added, r.Name)
+		case !reflect.DeepEqual(p, r):
+			changed = append(changed, r.Name)
+		}
+
+		delete(pm, r.Name)
+	}
+
+	for _, r := range prev {
+		if _, ok := pm[r.Name]; ok {
+			removed = append(removed, r.Name)
+		}
+	}
+
+	return added, changed, removed
+}
+
+// Watch checks the file at path every interval and calls f whenever its modification time or size has changed since
+// the last check. Calling the returned func stops the watch
+func Watch(path string, interval time.Duration, f func()) func() {
+	stat := func() (time.Time, int64) {
+		fi, err := os.Stat(path)
+
+		if err != nil {
+			log.Printf(3, "unable to stat watched rules file [%v]: [%v]", path, err)
+			return time.Time{}, -1
+		}
+
+		return fi.ModTime(), fi.Size()
+	}
+
+	modTime, size := stat()
+	stop := make(chan struct{})
+
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				mt, sz := stat()
+
+				if sz < 0 || (mt.Equal(modTime) && sz == size) {
+					continue
+				}
+
+				modTime, size = mt, sz
+
+				log.Printf(2, "detected change to watched rules file [%v]", path)
+
+				f()
+			}
+		}
+	}()
+
+	log.Printf(1, "watching rules file [%v] for changes every [%v]", path, interval)
+
+	return func() { close(stop) }
+}
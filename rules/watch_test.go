@@ -0,0 +1,49 @@
+package rules
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	prev := []Rule{{Name: "same"}, {Name: "changed"}, {Name: "removed"}}
+	next := []Rule{{Name: "same"}, {Name: "changed", Priority: 1}, {Name: "added"}}
+
+	added, changed, removed := Diff(prev, next)
+
+	if !reflect.DeepEqual(added, []string{"added"}) || !reflect.DeepEqual(changed, []string{"changed"}) || !reflect.DeepEqual(removed, []string{"removed"}) {
+		t.Fatalf("expected added [added], changed [changed] and removed [removed], got [%v], [%v] and [%v]", added, changed, removed)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+
+	if err := os.WriteFile(path, []byte(jsonRules), 0644); err != nil {
+		t.Fatalf("expected no error writing rules file, got [%v]", err)
+	}
+
+	changed := make(chan struct{}, 1)
+
+	stop := Watch(path, time.Millisecond*10, func() { changed <- struct{}{} })
+	defer stop()
+
+	select {
+	case <-changed:
+		t.Fatalf("expected no change to be signalled for an unmodified file")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	if err := os.WriteFile(path, []byte(jsonRules+"\n"), 0644); err != nil {
+		t.Fatalf("expected no error writing rules file, got [%v]", err)
+	}
+
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatalf("expected a change to be signalled for a modified file")
+	}
+}
@@ -0,0 +1,217 @@
+package tui
+
+import (
+	"comradequinn/hflow/breakpoint"
+	"comradequinn/hflow/proxy/intercept"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// refreshHeld updates the requests and responses held at breakpoints, keeping the selection where it is still held
+func (m *model) refreshHeld() {
+	if m.breakpoints == nil {
+		return
+	}
+
+	m.held = m.breakpoints.List()
+
+	if m.heldIndex() < 0 {
+		m.heldID = 0
+
+		if len(m.held) > 0 {
+			m.heldID = m.held[0].ID
+		}
+	}
+
+	if m.editing && m.heldIndex() < 0 {
+		m.editing, m.edit = false, ""
+		m.message = "the held exchange being edited was released"
+	}
+}
+
+func (m *model) heldIndex() int {
+	for i, h := range m.held {
+		if h.ID == m.heldID {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (m *model) currentHeld() *breakpoint.Held {
+	if i := m.heldIndex(); i >= 0 {
+		return &m.held[i]
+	}
+
+	return nil
+}
+
+func (m *model) heldRecord() *intercept.Record {
+	h := m.currentHeld()
+
+	if h == nil {
+		return nil
+	}
+
+	return &intercept.Record{ID: h.Request.ID, Start: h.Request.Start, End: h.Request.End, Request: h.Request, Response: h.Response}
+}
+
+func (m *model) heldRows() ([]string, int) {
+	rows := make([]string, 0, len(m.held))
+
+	for _, h := range m.held {
+		remaining := "no timeout"
+
+		if !h.Deadline.IsZero() {
+			remaining = time.Until(h.Deadline).Round(time.Second).String() + " left"
+		}
+
+		rows = append(rows, fmt.Sprintf("[%-4v] %-8v #%-6v %-7v %v  (%v)", h.ID, h.On, h.Request.ID, h.Request.Method, h.Request.URL.String(), remaining))
+	}
+
+	return rows, m.heldIndex()
+}
+
+// heldKey applies the action bound to k in held mode and returns true if k was handled
+func (m *model) heldKey(k string) bool {
+	move := func(d int) {
+		if i := m.heldIndex() + d; i >= 0 && i < len(m.held) {
+			m.heldID, m.scroll = m.held[i].ID, 0
+		}
+	}
+
+	release := func(f func(int) error, action string) {
+		h := m.currentHeld()
+
+		if h == nil {
+			m.message = "nothing is held"
+			return
+		}
+
+		if err := f(h.ID); err != nil {
+			m.message = err.Error()
+			return
+		}
+
+		m.message = fmt.Sprintf("%v held %v [%v]", action, h.On, h.ID)
+		m.refreshHeld()
+	}
+
+	switch k {
+	case keyUp, "k":
+		move(-1)
+	case keyDown, "j":
+		move(1)
+	case "f":
+		release(func(id int) error { return m.breakpoints.Forward(id, breakpoint.Edit{}) }, "forwarded")
+	case "x":
+		release(m.breakpoints.Drop, "dropped")
+	case "e":
+		if m.currentHeld() == nil {
+			m.message = "nothing is held"
+			break
+		}
+
+		m.editing, m.edit = true, ""
+	default:
+		return false
+	}
+
+	return true
+}
+
+// editKey handles k while an edit command is being entered
+func (m *model) editKey(k string) {
+	switch k {
+	case keyEscape:
+		m.editing, m.edit = false, ""
+	case keyBack:
+		if r := []rune(m.edit); len(r) > 0 {
+			m.edit = string(r[:len(r)-1])
+		}
+	case keyEnter:
+		h := m.currentHeld()
+		m.editing = false
+
+		if h == nil {
+			m.message = "the held exchange being edited was released"
+			break
+		}
+
+		e, err := parseEdit(m.edit, h)
+
+		if err == nil {
+			err = m.breakpoints.Edit(h.ID, e)
+		}
+
+		if err != nil {
+			m.message = fmt.Sprintf("invalid edit: %v. expected method|url|status|body <value>, header <name>: <value> or unset <name>", err)
+			break
+		}
+
+		m.message = fmt.Sprintf("edited held %v [%v], press f to forward it", h.On, h.ID)
+		m.edit = ""
+		m.refreshHeld()
+	default:
+		if r := []rune(k); len(r) == 1 && unicode.IsPrint(r[0]) {
+			m.edit += k
+		}
+	}
+}
+
+// parseEdit returns the breakpoint.Edit described by the edit command s, applied to h
+func parseEdit(s string, h *breakpoint.Held) (breakpoint.Edit, error) {
+	e := breakpoint.Edit{}
+	cmd, arg := s, ""
+
+	if i := strings.Index(s, " "); i >= 0 {
+		cmd, arg = s[:i], strings.TrimSpace(s[i+1:])
+	}
+
+	header := h.Request.Header
+
+	if h.On == breakpoint.OnResponse {
+		header = h.Response.Header
+	}
+
+	switch cmd {
+	case "method":
+		e.Method = strings.ToUpper(arg)
+	case "url":
+		e.URL = arg
+	case "status":
+		code, err := strconv.Atoi(arg)
+
+		if err != nil {
+			return e, fmt.Errorf("status [%v] is not a number", arg)
+		}
+
+		e.StatusCode = code
+	case "body":
+		e.Body = &arg
+	case "header":
+		kv := strings.SplitN(arg, ":", 2)
+
+		if len(kv) != 2 {
+			return e, fmt.Errorf("header [%v] is not of the form name: value", arg)
+		}
+
+		e.Header = header.Clone()
+		e.Header.Set(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	case "unset":
+		e.Header = header.Clone()
+		e.Header.Del(arg)
+	default:
+		return e, fmt.Errorf("unknown edit [%v]", cmd)
+	}
+
+	if arg == "" && cmd != "body" {
+		return e, fmt.Errorf("%v requires a value", cmd)
+	}
+
+	return e, nil
+}
@@ -0,0 +1,96 @@
+package tui
+
+import (
+	"comradequinn/hflow/breakpoint"
+	"comradequinn/hflow/proxy/intercept"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeld(t *testing.T) {
+	r := breakpoint.NewRegistry(0)
+	i, _ := r.Intercept("test", intercept.MatchAllRequests, intercept.MatchAllResponses, breakpoint.OnRequest)
+	rqs := make(chan *http.Request, 1)
+
+	go func() {
+		rq, _ := http.NewRequest(http.MethodGet, "http://www.test.com/", nil)
+		rq, _, _ = intercept.Request(intercept.NewExchange("127.0.0.1:50000", 0), rq, map[int]*intercept.Intercept{1: i})
+		rqs <- rq
+	}()
+
+	m := newModel(10, false, -1, r)
+
+	for n := 0; n < 100 && len(m.held) == 0; n++ {
+		time.Sleep(time.Millisecond * 10)
+		m.refreshHeld()
+	}
+
+	m.key("H")
+
+	if !m.heldMode || m.currentHeld() == nil {
+		t.Fatalf("expected held request to be selected in held mode")
+	}
+
+	if out := strings.Join(m.render(100, 20), "\n"); !strings.Contains(out, "http://www.test.com/") || !strings.Contains(out, "1 HELD") {
+		t.Fatalf("expected held request to be rendered, got [%v]", out)
+	}
+
+	for _, k := range append(append([]string{"e"}, strings.Split("header X-Hk: x-hv", "")...), keyEnter) {
+		m.key(k)
+	}
+
+	for _, k := range append(append([]string{"e"}, strings.Split("method post", "")...), keyEnter, "f") {
+		m.key(k)
+	}
+
+	rq := <-rqs
+
+	if rq.Method != http.MethodPost || rq.Header.Get("X-Hk") != "x-hv" {
+		t.Fatalf("expected edited request to be forwarded, got method [%v] and headers [%v]", rq.Method, rq.Header)
+	}
+
+	if len(m.held) != 0 {
+		t.Fatalf("expected forwarded request to no longer be held")
+	}
+}
+
+func TestParseEdit(t *testing.T) {
+	h := &breakpoint.Held{On: breakpoint.OnRequest, Request: &intercept.ProxyRequest{Header: http.Header{"A": []string{"a"}}}}
+
+	test := func(t *testing.T, s string, expectErr bool) breakpoint.Edit {
+		e, err := parseEdit(s, h)
+
+		if expectErr != (err != nil) {
+			t.Fatalf("expected error to be [%v] parsing edit [%v], got [%v]", expectErr, s, err)
+		}
+
+		return e
+	}
+
+	t.Run("Status", func(t *testing.T) {
+		if e := test(t, "status 500", false); e.StatusCode != 500 {
+			t.Fatalf("expected status code [500], got [%v]", e.StatusCode)
+		}
+	})
+
+	t.Run("Body", func(t *testing.T) {
+		if e := test(t, "body", false); e.Body == nil || *e.Body != "" {
+			t.Fatalf("expected empty body")
+		}
+	})
+
+	t.Run("Unset", func(t *testing.T) {
+		if e := test(t, "unset A", false); e.Header == nil || e.Header.Get("A") != "" {
+			t.Fatalf("expected header [A] to be removed, got [%v]", e.Header)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		test(t, "status x", true)
+		test(t, "header x", true)
+		test(t, "method", true)
+		test(t, "unknown x", true)
+	})
+}
@@ -0,0 +1,430 @@
+package tui
+
+import (
+	"comradequinn/hflow/breakpoint"
+	"comradequinn/hflow/proxy/intercept"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// keys are the names given to non-printable keys read from the terminal
+const (
+	keyUp       = "up"
+	keyDown     = "down"
+	keyLeft     = "left"
+	keyRight    = "right"
+	keyPageUp   = "pgup"
+	keyPageDown = "pgdn"
+	keyHome     = "home"
+	keyEnd      = "end"
+	keyEnter    = "enter"
+	keyEscape   = "esc"
+	keyTab      = "tab"
+	keyBack     = "backspace"
+	keyCtrlC    = "ctrl+c"
+)
+
+const (
+	tabRequest = iota
+	tabResponse
+)
+
+// defaultLimit is the body limit toggled to when hflow was started without one
+const defaultLimit = 1024
+
+const (
+	help     = "q quit  / search  p pause  c clear  y copy as curl  b binary  l limit  tab request/response  pgup/pgdn scroll  H held"
+	heldHelp = "f forward  x drop  e edit  H exchanges  tab request/response  pgup/pgdn scroll"
+)
+
+// model holds the state of the terminal ui and renders it. It is only accessed from the ui's event loop
+type model struct {
+	recs       []*intercept.Record
+	max        int
+	view       []*intercept.Record
+	selected   uint64
+	follow     bool
+	offset     int
+	tab        int
+	scroll     int
+	search     string
+	searching  bool
+	paused     bool
+	skipped    int
+	binary     bool
+	limit      int
+	otherLimit int
+	message    string
+	clipboard  string
+	height     int
+
+	breakpoints *breakpoint.Registry
+	held        []breakpoint.Held
+	heldMode    bool
+	heldID      int
+	editing     bool
+	edit        string
+}
+
+func newModel(max int, binary bool, limit int, breakpoints *breakpoint.Registry) *model {
+	m := &model{max: max, follow: true, binary: binary, limit: limit, otherLimit: -1, breakpoints: breakpoints}
+
+	if limit < 0 {
+		m.otherLimit = defaultLimit
+	}
+
+	return m
+}
+
+// add appends rec to the exchanges held, unless capture is paused. The oldest exchanges are discarded beyond max
+func (m *model) add(rec *intercept.Record) {
+	if m.paused {
+		m.skipped++
+		return
+	}
+
+	if m.recs = append(m.recs, rec); len(m.recs) > m.max {
+		m.recs = append(m.recs[:0:0], m.recs[len(m.recs)-m.max:]...)
+	}
+
+	m.filter()
+}
+
+// filter rebuilds the view of exchanges that match the search, keeping the selection where it remains in the view
+func (m *model) filter() {
+	m.view = m.view[:0]
+	search := strings.ToLower(m.search)
+
+	for _, rec := range m.recs {
+		if search == "" || strings.Contains(strings.ToLower(summary(rec)), search) {
+			m.view = append(m.view, rec)
+		}
+	}
+
+	if m.follow || m.index() < 0 {
+		m.selectIndex(len(m.view) - 1)
+	}
+}
+
+// index returns the index of the selected exchange in the view or -1 if it is not in the view
+func (m *model) index() int {
+	for i, rec := range m.view {
+		if rec.ID == m.selected {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (m *model) selectIndex(i int) {
+	if i >= len(m.view) {
+		i = len(m.view) - 1
+	}
+
+	if i < 0 {
+		m.selected, m.follow = 0, true
+		return
+	}
+
+	if id := m.view[i].ID; id != m.selected {
+		m.selected, m.scroll = id, 0
+	}
+
+	m.follow = i == len(m.view)-1
+}
+
+func (m *model) current() *intercept.Record {
+	if i := m.index(); i >= 0 {
+		return m.view[i]
+	}
+
+	return nil
+}
+
+// key applies the action bound to k and returns true if the ui should exit
+func (m *model) key(k string) bool {
+	m.message = ""
+
+	if k == keyCtrlC {
+		return true
+	}
+
+	if m.editing {
+		m.editKey(k)
+		return false
+	}
+
+	if m.heldMode && m.heldKey(k) {
+		return false
+	}
+
+	if m.searching {
+		switch k {
+		case keyEnter:
+			m.searching = false
+		case keyEscape:
+			m.searching, m.search = false, ""
+		case keyBack:
+			if r := []rune(m.search); len(r) > 0 {
+				m.search = string(r[:len(r)-1])
+			}
+		default:
+			if r := []rune(k); len(r) == 1 && unicode.IsPrint(r[0]) {
+				m.search += k
+			}
+		}
+
+		m.filter()
+
+		return false
+	}
+
+	page := m.height / 2
+
+	if page < 1 {
+		page = 1
+	}
+
+	switch k {
+	case "q":
+		return true
+	case keyUp, "k":
+		m.selectIndex(m.index() - 1)
+	case keyDown, "j":
+		m.selectIndex(m.index() + 1)
+	case keyHome, "g":
+		m.selectIndex(0)
+	case keyEnd, "G":
+		m.selectIndex(len(m.view) - 1)
+	case keyPageUp:
+		if m.scroll -= page; m.scroll < 0 {
+			m.scroll = 0
+		}
+	case keyPageDown:
+		m.scroll += page
+	case keyTab, keyLeft, keyRight:
+		m.tab, m.scroll = (m.tab+1)%2, 0
+	case "/":
+		m.searching = true
+	case keyEscape:
+		m.search = ""
+		m.filter()
+	case "p":
+		if m.paused = !m.paused; !m.paused {
+			m.message = fmt.Sprintf("capture resumed, [%v] exchanges were skipped while paused", m.skipped)
+			m.skipped = 0
+		}
+	case "c":
+		m.recs, m.view, m.selected, m.follow, m.offset, m.scroll = nil, nil, 0, true, 0, 0
+		m.message = "cleared"
+	case "y":
+		if rec := m.current(); rec != nil {
+			m.clipboard = intercept.Curl(rec.Request)
+			m.message = fmt.Sprintf("copied curl command for #%v to the clipboard", rec.ID)
+		}
+	case "b":
+		m.binary = !m.binary
+		m.message = fmt.Sprintf("binary bodies set to [%v]", m.binary)
+	case "l":
+		m.limit, m.otherLimit = m.otherLimit, m.limit
+		m.message = fmt.Sprintf("body limit set to [%v]", limitText(m.limit))
+	case "H":
+		if m.breakpoints == nil {
+			m.message = "breakpoints are not enabled"
+			break
+		}
+
+		m.heldMode, m.tab, m.scroll = !m.heldMode, tabRequest, 0
+		m.refreshHeld()
+	}
+
+	return false
+}
+
+// render returns the lines of the ui for a terminal of width w and height h
+func (m *model) render(w, h int) []string {
+	if w < 1 || h < 4 {
+		return nil
+	}
+
+	listH := (h - 3) * 2 / 5
+
+	if listH < 1 {
+		listH = 1
+	}
+
+	detailH := h - 3 - listH
+	m.height = detailH
+	lines := make([]string, 0, h)
+
+	state := ""
+
+	if m.paused {
+		state = fmt.Sprintf(" PAUSED (%v skipped)", m.skipped)
+	}
+
+	if len(m.held) > 0 {
+		state += fmt.Sprintf("  %v HELD", len(m.held))
+	}
+
+	lines = append(lines, reverse(fit(fmt.Sprintf(" hflow  %v/%v exchanges  binary %v  limit %v%v", len(m.view), len(m.recs), m.binary, limitText(m.limit), state), w)))
+
+	rows, i := m.rows()
+
+	if i < m.offset {
+		m.offset = i
+	}
+
+	if i >= m.offset+listH {
+		m.offset = i - listH + 1
+	}
+
+	if m.offset < 0 {
+		m.offset = 0
+	}
+
+	for r := 0; r < listH; r++ {
+		if m.offset+r >= len(rows) {
+			lines = append(lines, "")
+			continue
+		}
+
+		l := fit(" "+rows[m.offset+r], w)
+
+		if m.offset+r == i {
+			l = reverse(l)
+		}
+
+		lines = append(lines, l)
+	}
+
+	tabs := []string{" request ", " response "}
+	tabs[m.tab] = "[" + strings.TrimSpace(tabs[m.tab]) + "]"
+	lines = append(lines, fit(strings.Repeat("─", 2)+strings.Join(tabs, "─")+strings.Repeat("─", w), w))
+
+	detail := m.detail()
+
+	if max := len(detail) - detailH; m.scroll > max {
+		m.scroll = max
+	}
+
+	if m.scroll < 0 {
+		m.scroll = 0
+	}
+
+	for r := 0; r < detailH; r++ {
+		if l := m.scroll + r; l < len(detail) {
+			lines = append(lines, fit(detail[l], w))
+			continue
+		}
+
+		lines = append(lines, "")
+	}
+
+	switch {
+	case m.editing:
+		lines = append(lines, fit(fmt.Sprintf("edit held %v [%v]> %v█", m.currentHeld().On, m.heldID, m.edit), w))
+	case m.searching:
+		lines = append(lines, fit("/"+m.search+"█", w))
+	case m.message != "":
+		lines = append(lines, fit(m.message, w))
+	case m.heldMode:
+		lines = append(lines, fit(heldHelp, w))
+	case m.search != "":
+		lines = append(lines, fit(fmt.Sprintf("search [%v] esc to clear  %v", m.search, help), w))
+	default:
+		lines = append(lines, fit(help, w))
+	}
+
+	return lines
+}
+
+// rows returns the lines of the list pane and the index of the selected line
+func (m *model) rows() ([]string, int) {
+	if m.heldMode {
+		return m.heldRows()
+	}
+
+	rows := make([]string, 0, len(m.view))
+
+	for _, rec := range m.view {
+		rows = append(rows, summary(rec))
+	}
+
+	return rows, m.index()
+}
+
+// detail returns the lines of the detail pane, being the request or response of the selected exchange
+func (m *model) detail() []string {
+	rec := m.current()
+
+	if m.heldMode {
+		rec = m.heldRecord()
+	}
+
+	if rec == nil {
+		return nil
+	}
+
+	text := ""
+
+	switch {
+	case m.tab == tabRequest:
+		text, _ = intercept.Text(&intercept.Record{ID: rec.ID, Start: rec.Start, End: rec.End, Request: rec.Request, Response: &intercept.ProxyResponse{}}, m.binary, m.limit)
+	case rec.Response == nil:
+		text = "the response has not yet been received"
+	default:
+		_, text = intercept.Text(rec, m.binary, m.limit)
+	}
+
+	return strings.Split(strings.TrimRight(text, "\n"), "\n")
+}
+
+// summary returns the single line description of rec shown in the exchange list
+func summary(rec *intercept.Record) string {
+	status, duration := "...", ""
+
+	if rec.Response != nil {
+		status, duration = strconv.Itoa(rec.Response.StatusCode), rec.End.Sub(rec.Start).Round(time.Millisecond).String()
+	}
+
+	return fmt.Sprintf("#%-6v %v  %-7v %v  %-9v %v", rec.ID, rec.Start.Format("15:04:05"), rec.Request.Method, status, duration, rec.Request.URL.String())
+}
+
+func limitText(limit int) string {
+	if limit < 0 {
+		return "none"
+	}
+
+	return strconv.Itoa(limit)
+}
+
+// fit returns s with control characters replaced and truncated or padded to w characters
+func fit(s string, w int) string {
+	rs := make([]rune, 0, w)
+
+	for _, r := range s {
+		if len(rs) == w {
+			break
+		}
+
+		switch {
+		case r == '\t':
+			r = ' '
+		case unicode.IsControl(r) || r == unicode.ReplacementChar:
+			r = '.'
+		}
+
+		rs = append(rs, r)
+	}
+
+	return string(rs) + strings.Repeat(" ", w-len(rs))
+}
+
+func reverse(s string) string {
+	return "\x1b[7m" + s + "\x1b[0m"
+}
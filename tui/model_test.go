@@ -0,0 +1,152 @@
+package tui
+
+import (
+	"comradequinn/hflow/proxy/intercept"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func record(id uint64, rawURL string) *intercept.Record {
+	u, _ := url.Parse(rawURL)
+	start := time.Now()
+
+	return &intercept.Record{
+		ID:       id,
+		Start:    start,
+		End:      start.Add(time.Millisecond),
+		Request:  &intercept.ProxyRequest{Method: http.MethodGet, URL: *u, Header: http.Header{}},
+		Response: &intercept.ProxyResponse{StatusCode: http.StatusOK, Status: "200 OK", Header: http.Header{"Content-Type": []string{"text/plain"}}, Body: []byte("rs-body")},
+	}
+}
+
+func TestModel(t *testing.T) {
+	newTestModel := func() *model {
+		m := newModel(10, false, -1, nil)
+
+		for i, u := range []string{"http://a.com/x", "http://b.com/y", "http://a.com/z"} {
+			m.add(record(uint64(i+1), u))
+		}
+
+		return m
+	}
+
+	keys := func(m *model, ks ...string) {
+		for _, k := range ks {
+			m.key(k)
+		}
+	}
+
+	t.Run("Follow", func(t *testing.T) {
+		m := newTestModel()
+
+		if m.selected != 3 {
+			t.Fatalf("expected latest exchange to be selected, got [%v]", m.selected)
+		}
+
+		keys(m, keyUp)
+		m.add(record(4, "http://c.com/"))
+
+		if m.selected != 2 {
+			t.Fatalf("expected selection to stay on exchange [2] when not following, got [%v]", m.selected)
+		}
+	})
+
+	t.Run("Search", func(t *testing.T) {
+		m := newTestModel()
+		keys(m, "/", "a", ".", "c", "o", "m", keyEnter)
+
+		if len(m.view) != 2 || m.searching {
+			t.Fatalf("expected search to match [2] exchanges, got [%v]", len(m.view))
+		}
+
+		keys(m, keyEscape)
+
+		if len(m.view) != 3 {
+			t.Fatalf("expected clearing search to show [3] exchanges, got [%v]", len(m.view))
+		}
+	})
+
+	t.Run("Pause", func(t *testing.T) {
+		m := newTestModel()
+		keys(m, "p")
+		m.add(record(4, "http://c.com/"))
+
+		if len(m.recs) != 3 || m.skipped != 1 {
+			t.Fatalf("expected exchange to be skipped while paused, got [%v] held and [%v] skipped", len(m.recs), m.skipped)
+		}
+	})
+
+	t.Run("Clear", func(t *testing.T) {
+		m := newTestModel()
+		keys(m, "c")
+
+		if len(m.recs) != 0 || len(m.view) != 0 || m.current() != nil {
+			t.Fatalf("expected exchanges to be cleared")
+		}
+	})
+
+	t.Run("Curl", func(t *testing.T) {
+		m := newTestModel()
+		keys(m, "y")
+
+		if !strings.HasPrefix(m.clipboard, "curl -X GET 'http://a.com/z'") {
+			t.Fatalf("expected curl command for selected exchange, got [%v]", m.clipboard)
+		}
+	})
+
+	t.Run("Toggles", func(t *testing.T) {
+		m := newTestModel()
+		keys(m, "b", "l")
+
+		if !m.binary || m.limit != defaultLimit {
+			t.Fatalf("expected binary and limit to be toggled, got [%v] and [%v]", m.binary, m.limit)
+		}
+
+		keys(m, "l")
+
+		if m.limit != -1 {
+			t.Fatalf("expected limit to be toggled back to [-1], got [%v]", m.limit)
+		}
+	})
+
+	t.Run("Render", func(t *testing.T) {
+		m := newTestModel()
+		keys(m, keyTab)
+
+		lines := m.render(80, 20)
+
+		if len(lines) != 20 {
+			t.Fatalf("expected [20] lines, got [%v]", len(lines))
+		}
+
+		out := strings.Join(lines, "\n")
+
+		for _, s := range []string{"http://b.com/y", "[response]", "rs-body"} {
+			if !strings.Contains(out, s) {
+				t.Fatalf("expected rendered ui to contain [%v], got [%v]", s, out)
+			}
+		}
+	})
+
+	if m := newTestModel(); !m.key("q") || !m.key(keyCtrlC) {
+		t.Fatalf("expected q and ctrl+c to quit")
+	}
+}
+
+func TestReadKeys(t *testing.T) {
+	keys := make(chan string, 16)
+	readKeys(strings.NewReader("a\x1b[A\x1b[6~\r\x1b\x7f"), keys)
+
+	got := []string{}
+
+	for k := range keys {
+		got = append(got, k)
+	}
+
+	if expected := "a up pgdn enter esc backspace"; strings.Join(got, " ") != expected {
+		t.Fatalf("expected keys [%v], got [%v]", expected, strings.Join(got, " "))
+	}
+}
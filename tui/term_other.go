@@ -0,0 +1,20 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package tui
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+func makeRaw(fd uintptr) (func(), error) {
+	return nil, fmt.Errorf("the terminal ui is not supported on [%v]", runtime.GOOS)
+}
+
+func size(fd uintptr) (int, int, error) {
+	return 0, 0, fmt.Errorf("the terminal ui is not supported on [%v]", runtime.GOOS)
+}
+
+func notifyResize(c chan<- os.Signal) {}
@@ -0,0 +1,59 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package tui
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"unsafe"
+)
+
+// makeRaw puts the terminal referred to by fd into raw mode and returns a func that restores its previous state
+func makeRaw(fd uintptr) (func(), error) {
+	prev := syscall.Termios{}
+
+	if err := ioctl(fd, ioctlGetTermios, unsafe.Pointer(&prev)); err != nil {
+		return nil, fmt.Errorf("unable to read terminal state: [%v]", err)
+	}
+
+	raw := prev
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN], raw.Cc[syscall.VTIME] = 1, 0
+
+	if err := ioctl(fd, ioctlSetTermios, unsafe.Pointer(&raw)); err != nil {
+		return nil, fmt.Errorf("unable to set terminal to raw mode: [%v]", err)
+	}
+
+	return func() { ioctl(fd, ioctlSetTermios, unsafe.Pointer(&prev)) }, nil
+}
+
+// size returns the width and height, in characters, of the terminal referred to by fd
+func size(fd uintptr) (int, int, error) {
+	ws := struct{ Row, Col, X, Y uint16 }{}
+
+	if err := ioctl(fd, syscall.TIOCGWINSZ, unsafe.Pointer(&ws)); err != nil {
+		return 0, 0, fmt.Errorf("unable to read terminal size: [%v]", err)
+	}
+
+	return int(ws.Col), int(ws.Row), nil
+}
+
+// notifyResize causes c to receive a value whenever the terminal is resized
+func notifyResize(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGWINCH)
+}
+
+func ioctl(fd, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
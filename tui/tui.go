@@ -0,0 +1,175 @@
+// Package tui provides a full-screen terminal interface for browsing captured http exchanges
+package tui
+
+import (
+	"bytes"
+	"comradequinn/hflow/breakpoint"
+	"comradequinn/hflow/capture"
+	"comradequinn/hflow/log"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Run takes over the terminal attached to stdin and stdout, displaying exchanges as they are added to store, until the
+// user quits. Requests and responses held at breakpoints, where breakpoints is not nil, can be edited, forwarded and
+// dropped. Binary and limit set the initial display of bodies, as per intercept.Writer, and max bounds the number of
+// exchanges held by the ui. Logs are shown in the ui's status line while it runs
+func Run(store *capture.Store, breakpoints *breakpoint.Registry, max int, binary bool, limit int) error {
+	in, out := os.Stdin.Fd(), os.Stdout
+
+	restore, err := makeRaw(in)
+
+	if err != nil {
+		return err
+	}
+
+	defer restore()
+
+	io.WriteString(out, "\x1b[?1049h\x1b[?25l")
+	defer io.WriteString(out, "\x1b[?25h\x1b[?1049l")
+
+	logs := make(chan string, 16)
+	log.SetOutput(logWriter(logs))
+	defer log.SetOutput(os.Stderr)
+
+	recs, unsubscribe := store.Subscribe(256)
+	defer unsubscribe()
+
+	keys, resize := make(chan string, 16), make(chan os.Signal, 1)
+	notifyResize(resize)
+	go readKeys(os.Stdin, keys)
+
+	m := newModel(max, binary, limit, breakpoints)
+
+	for _, rec := range store.Recent(max) {
+		m.add(rec)
+	}
+
+	draw := func() {
+		w, h, err := size(out.Fd())
+
+		if err != nil || w == 0 || h == 0 {
+			w, h = 80, 24
+		}
+
+		b := bytes.Buffer{}
+		b.WriteString("\x1b[H")
+		b.WriteString(strings.Join(m.render(w, h), "\x1b[K\r\n"))
+
+		if m.clipboard != "" {
+			fmt.Fprintf(&b, "\x1b]52;c;%v\x07", base64.StdEncoding.EncodeToString([]byte(m.clipboard)))
+			m.clipboard = ""
+		}
+
+		out.Write(b.Bytes())
+	}
+
+	// exchanges can arrive faster than the terminal can be redrawn, so redraws they cause are limited by a ticker
+	tick, dirty := time.NewTicker(time.Millisecond*100), false
+	defer tick.Stop()
+
+	draw()
+
+	for {
+		select {
+		case k, ok := <-keys:
+			if !ok || m.key(k) {
+				return nil
+			}
+
+			draw()
+		case rec, ok := <-recs:
+			if !ok {
+				return nil
+			}
+
+			m.add(rec)
+			dirty = true
+		case l := <-logs:
+			m.message = l
+			dirty = true
+		case <-resize:
+			draw()
+		case <-tick.C:
+			// held requests and responses are redrawn on every tick so that their remaining time is current
+			if breakpoints != nil {
+				n := len(m.held)
+				m.refreshHeld()
+				dirty = dirty || n > 0 || len(m.held) > 0
+			}
+
+			if dirty {
+				draw()
+				dirty = false
+			}
+		}
+	}
+}
+
+// readKeys sends the name of each key read from r to keys, closing keys when r is exhausted
+func readKeys(r io.Reader, keys chan<- string) {
+	defer close(keys)
+
+	escapes := map[string]string{
+		"\x1b[A": keyUp, "\x1b[B": keyDown, "\x1b[C": keyRight, "\x1b[D": keyLeft,
+		"\x1b[5~": keyPageUp, "\x1b[6~": keyPageDown, "\x1b[H": keyHome, "\x1b[F": keyEnd,
+		"\x1b[1~": keyHome, "\x1b[4~": keyEnd, "\x1bOA": keyUp, "\x1bOB": keyDown,
+	}
+
+	controls := map[byte]string{'\r': keyEnter, '\n': keyEnter, '\t': keyTab, 0x7f: keyBack, 0x08: keyBack, 0x03: keyCtrlC}
+
+	b := make([]byte, 64)
+
+	for {
+		n, err := r.Read(b)
+
+		if err != nil {
+			return
+		}
+
+		for s := string(b[:n]); len(s) > 0; {
+			if s[0] == 0x1b {
+				k, l := keyEscape, 1
+
+				for seq, name := range escapes {
+					if strings.HasPrefix(s, seq) {
+						k, l = name, len(seq)
+						break
+					}
+				}
+
+				keys <- k
+				s = s[l:]
+
+				continue
+			}
+
+			if k, ok := controls[s[0]]; ok {
+				keys <- k
+				s = s[1:]
+
+				continue
+			}
+
+			r := []rune(s)[0]
+			keys <- string(r)
+			s = s[len(string(r)):]
+		}
+	}
+}
+
+// logWriter is an io.Writer that sends each write, as a trimmed line, to a channel, discarding writes when it is full
+type logWriter chan<- string
+
+func (w logWriter) Write(b []byte) (int, error) {
+	select {
+	case w <- strings.TrimSpace(string(b)):
+	default:
+	}
+
+	return len(b), nil
+}